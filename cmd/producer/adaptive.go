@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rudderlabs/rudder-go-kit/logger"
+)
+
+// adaptiveLoad looks for the highest events per second the system under test sustains (see ADAPTIVE_LOAD).
+// Every interval it raises the limit by step if the error rate of the requests sent in the last interval is under
+// the target, otherwise it lowers it by step, staying within [min,max].
+type adaptiveLoad struct {
+	targetErrorRate float64
+	step, min, max  float64
+
+	limit atomic.Uint64 // events per second, as float64 bits
+
+	mu            sync.Mutex // guards the fields below
+	lastPublished int64
+	lastFailed    int64
+	best          float64 // highest limit sustained with an error rate under the target
+}
+
+func newAdaptiveLoad(targetErrorRate, step, minLimit, maxLimit float64) (*adaptiveLoad, error) {
+	if targetErrorRate <= 0 || targetErrorRate >= 1 {
+		return nil, fmt.Errorf("target error rate has to be between 0%% and 100%%: %v", targetErrorRate)
+	}
+	if step <= 0 {
+		return nil, fmt.Errorf("step has to be greater than zero: %v", step)
+	}
+	if minLimit <= 0 || maxLimit < minLimit {
+		return nil, fmt.Errorf("invalid bounds: min %v, max %v", minLimit, maxLimit)
+	}
+	a := &adaptiveLoad{
+		targetErrorRate: targetErrorRate,
+		step:            step,
+		min:             minLimit,
+		max:             maxLimit,
+	}
+	a.limit.Store(math.Float64bits(minLimit))
+	return a, nil
+}
+
+// Limit returns the current events per second limit
+func (a *adaptiveLoad) Limit() float64 {
+	return math.Float64frombits(a.limit.Load())
+}
+
+// Best returns the highest events per second sustained with an error rate under the target, zero if none
+func (a *adaptiveLoad) Best() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.best
+}
+
+// Adjust updates the limit given the total number of published and failed requests so far.
+// It returns the limit before and after the adjustment and the error rate since the previous call.
+// The limit is left as it is if no request was sent since the previous call.
+func (a *adaptiveLoad) Adjust(published, failed int64) (float64, float64, float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	requests := (published - a.lastPublished) + (failed - a.lastFailed)
+	failures := failed - a.lastFailed
+	a.lastPublished, a.lastFailed = published, failed
+
+	old := a.Limit()
+	if requests <= 0 {
+		return old, old, 0
+	}
+	errorRate := float64(failures) / float64(requests)
+	var limit float64
+	if errorRate < a.targetErrorRate {
+		a.best = max(a.best, old)
+		limit = min(old+a.step, a.max)
+	} else {
+		limit = max(old-a.step, a.min)
+	}
+	a.limit.Store(math.Float64bits(limit))
+	return old, limit, errorRate
+}
+
+// Run adjusts the limit every interval until ctx is canceled
+func (a *adaptiveLoad) Run(ctx context.Context, interval time.Duration, published, failed func() int64, log logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			old, limit, errorRate := a.Adjust(published(), failed())
+			if old != limit {
+				log.Infon("Adaptive load limit adjusted",
+					logger.NewFloatField("oldEventsPerSecond", old),
+					logger.NewFloatField("eventsPerSecond", limit),
+					logger.NewFloatField("errorRate", errorRate),
+				)
+			}
+		}
+	}
+}
+
+// parseErrorRate parses an error rate either as a percentage (e.g. "0.5%") or as a fraction (e.g. "0.005")
+func parseErrorRate(v string) (float64, error) {
+	value := strings.TrimSpace(v)
+	percentage := strings.HasSuffix(value, "%")
+	rate, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, "%")), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid error rate %q: %w", v, err)
+	}
+	if percentage {
+		rate /= 100
+	}
+	return rate, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rudderlabs/rudder-go-kit/logger"
+	"github.com/stretchr/testify/require"
+)
+
+// stubCapacityPublisher publishes one interval worth of requests at the current limit on every call, failing 10% of
+// them once the limit goes over its capacity
+type stubCapacityPublisher struct {
+	capacity float64
+	limit    func() float64
+
+	mu                sync.Mutex
+	published, failed int64
+}
+
+func (p *stubCapacityPublisher) Publish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	requests := int64(p.limit())
+	failed := int64(0)
+	if p.limit() > p.capacity {
+		failed = requests / 10
+	}
+	p.published += requests - failed
+	p.failed += failed
+}
+
+func (p *stubCapacityPublisher) Published() int64 {
+	p.Publish() // the requests of the last interval
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.published
+}
+
+func (p *stubCapacityPublisher) Failed() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failed
+}
+
+func TestAdaptiveLoad(t *testing.T) {
+	t.Run("converges", func(t *testing.T) {
+		a, err := newAdaptiveLoad(0.005, 500, 500, 10000)
+		require.NoError(t, err)
+		p := &stubCapacityPublisher{capacity: 3000, limit: a.Limit}
+
+		var limits []float64
+		for i := 0; i < 20; i++ {
+			_, limit, _ := a.Adjust(p.Published(), p.Failed())
+			limits = append(limits, limit)
+		}
+		require.Equal(t, []float64{1000, 1500, 2000, 2500, 3000, 3500}, limits[:6])
+		for _, limit := range limits[5:] {
+			require.Contains(t, []float64{3000, 3500}, limit, "the limit oscillates around the capacity")
+		}
+		require.Equal(t, 3000.0, a.Best())
+	})
+
+	t.Run("bounds", func(t *testing.T) {
+		a, err := newAdaptiveLoad(0.005, 500, 1000, 2000)
+		require.NoError(t, err)
+		require.Equal(t, 1000.0, a.Limit(), "it starts from the lower bound")
+
+		old, limit, errorRate := a.Adjust(100, 50)
+		require.Equal(t, 1000.0, old)
+		require.Equal(t, 1000.0, limit, "it does not go under the lower bound")
+		require.InDelta(t, 0.333, errorRate, 0.001)
+
+		for i := int64(1); i <= 3; i++ {
+			_, limit, _ = a.Adjust(100+i*100, 50)
+		}
+		require.Equal(t, 2000.0, limit, "it does not go over the upper bound")
+		require.Equal(t, 2000.0, a.Best())
+
+		old, limit, _ = a.Adjust(400, 50)
+		require.Equal(t, old, limit, "no requests in the last interval")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, args := range [][4]float64{
+			{0, 500, 500, 1000},
+			{1, 500, 500, 1000},
+			{0.01, 0, 500, 1000},
+			{0.01, 500, 0, 1000},
+			{0.01, 500, 1000, 500},
+		} {
+			_, err := newAdaptiveLoad(args[0], args[1], args[2], args[3])
+			require.Error(t, err, args)
+		}
+	})
+}
+
+func TestAdaptiveLoadRun(t *testing.T) {
+	a, err := newAdaptiveLoad(0.01, 100, 100, 5000)
+	require.NoError(t, err)
+	p := &stubCapacityPublisher{capacity: 1200, limit: a.Limit}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Run(ctx, time.Millisecond, p.Published, p.Failed, logger.NOP)
+	}()
+
+	require.Eventually(t, func() bool {
+		return a.Best() == 1200
+	}, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		return a.Limit() <= 1300
+	}, 5*time.Second, time.Millisecond)
+	cancel()
+	<-done
+	require.LessOrEqual(t, a.Limit(), 1300.0)
+	require.Equal(t, 1200.0, a.Best(), "the limit never sustained more than the capacity")
+}
+
+func TestParseErrorRate(t *testing.T) {
+	for input, expected := range map[string]float64{
+		"0.5%":  0.005,
+		"1 %":   0.01,
+		"0.02":  0.02,
+		"10%":   0.1,
+		" 5% ":  0.05,
+		"0.001": 0.001,
+	} {
+		rate, err := parseErrorRate(input)
+		require.NoError(t, err, input)
+		require.InDelta(t, expected, rate, 1e-9, input)
+	}
+	_, err := parseErrorRate("half")
+	require.Error(t, err)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// batchSizesCapper holds the batch sizes concentration of every event type.
+// When the server rejects a payload because it is too large (i.e. 413) the batch sizes that are greater or equal
+// than the rejected one are removed for that event type and the concentration is renormalized.
+type batchSizesCapper struct {
+	batchSizes    []int
+	hotBatchSizes []int
+
+	mu             sync.Mutex
+	concentrations map[string]*atomic.Pointer[[]int] // read-only map, values are swapped atomically
+	maxBatchSizes  map[string]int
+	maxBatchSize   *prometheus.GaugeVec
+}
+
+func newBatchSizesCapper(eventTypes []eventType, batchSizes, hotBatchSizes []int, maxBatchSize *prometheus.GaugeVec) *batchSizesCapper {
+	var (
+		concentration       = getBatchSizesConcentration(batchSizes, hotBatchSizes)
+		initialMaxBatchSize = slices.Max(batchSizes)
+		c                   = &batchSizesCapper{
+			batchSizes:     batchSizes,
+			hotBatchSizes:  hotBatchSizes,
+			concentrations: make(map[string]*atomic.Pointer[[]int], len(eventTypes)),
+			maxBatchSizes:  make(map[string]int, len(eventTypes)),
+			maxBatchSize:   maxBatchSize,
+		}
+	)
+	for _, et := range eventTypes {
+		var p atomic.Pointer[[]int]
+		p.Store(&concentration)
+		c.concentrations[et.String()] = &p
+		c.maxBatchSizes[et.String()] = initialMaxBatchSize
+		c.maxBatchSize.WithLabelValues(et.String()).Set(float64(initialMaxBatchSize))
+	}
+	return c
+}
+
+// Get returns the batch size for the given event type and random number in the [0,100) range
+func (c *batchSizesCapper) Get(eventType string, random int) int {
+	return (*c.concentrations[eventType].Load())[random]
+}
+
+// Cap removes all the batch sizes that are greater or equal than rejectedBatchSize for the given event type.
+// It returns the new max allowed batch size and true if the concentration was changed.
+// If there are no smaller batch sizes left the concentration is left untouched.
+func (c *batchSizesCapper) Cap(eventType string, rejectedBatchSize int) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if rejectedBatchSize > c.maxBatchSizes[eventType] {
+		return c.maxBatchSizes[eventType], false // already capped by a concurrent request
+	}
+
+	var allowedBatchSizes, allowedHotBatchSizes []int
+	for i, batchSize := range c.batchSizes {
+		if batchSize < rejectedBatchSize {
+			allowedBatchSizes = append(allowedBatchSizes, batchSize)
+			allowedHotBatchSizes = append(allowedHotBatchSizes, c.hotBatchSizes[i])
+		}
+	}
+	if len(allowedBatchSizes) == 0 {
+		return c.maxBatchSizes[eventType], false
+	}
+
+	concentration := getBatchSizesConcentration(allowedBatchSizes, renormalizePercentages(allowedHotBatchSizes))
+	c.concentrations[eventType].Store(&concentration)
+	c.maxBatchSizes[eventType] = slices.Max(allowedBatchSizes)
+	c.maxBatchSize.WithLabelValues(eventType).Set(float64(c.maxBatchSizes[eventType]))
+
+	return c.maxBatchSizes[eventType], true
+}
+
+// SetHotBatchSizes replaces the hot batch sizes (e.g. on reload) keeping the batch sizes that were already capped
+// out of the concentrations
+func (c *batchSizesCapper) SetHotBatchSizes(hotBatchSizes []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hotBatchSizes = hotBatchSizes
+	for eventType, maxBatchSize := range c.maxBatchSizes {
+		var allowedBatchSizes, allowedHotBatchSizes []int
+		for i, batchSize := range c.batchSizes {
+			if batchSize <= maxBatchSize {
+				allowedBatchSizes = append(allowedBatchSizes, batchSize)
+				allowedHotBatchSizes = append(allowedHotBatchSizes, hotBatchSizes[i])
+			}
+		}
+		concentration := getBatchSizesConcentration(allowedBatchSizes, renormalizePercentages(allowedHotBatchSizes))
+		c.concentrations[eventType].Store(&concentration)
+	}
+}
+
+// renormalizePercentages scales the given percentages so that they sum up to 100.
+// The rounding remainder is given to the first element.
+// If all percentages are zero then they are split evenly.
+func renormalizePercentages(percentages []int) []int {
+	total := 0
+	for _, p := range percentages {
+		total += p
+	}
+
+	var (
+		sum          = 0
+		renormalized = make([]int, len(percentages))
+	)
+	for i, p := range percentages {
+		if total == 0 {
+			renormalized[i] = 100 / len(percentages)
+		} else {
+			renormalized[i] = p * 100 / total
+		}
+		sum += renormalized[i]
+	}
+	renormalized[0] += 100 - sum
+
+	return renormalized
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+
+	"rudder-load/internal/producer"
+)
+
+func TestBatchSizesCapper(t *testing.T) {
+	const maxBodySize = 600
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if len(body) > maxBodySize {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+
+	maxBatchSize := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "max_batch_size"}, []string{"event_type"})
+	eventTypes := []eventType{{Type: "page"}, {Type: "batch", Values: []int{1, 2}}}
+	capper := newBatchSizesCapper(eventTypes, []int{1, 5, 10}, []int{50, 30, 20}, maxBatchSize)
+
+	publish := func(eventType string) (tooLarge bool) {
+		batchSize := capper.Get(eventType, rand.Intn(100))
+		_, err := p.PublishTo(context.Background(), "key", bytes.Repeat([]byte("a"), batchSize*100), nil)
+		var statusErr *producer.HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusRequestEntityTooLarge {
+			capper.Cap(eventType, batchSize)
+			return true
+		}
+		require.NoError(t, err)
+		return false
+	}
+
+	tooLarge := 0
+	for i := 0; i < 1000; i++ {
+		if publish("page") {
+			tooLarge++
+		}
+	}
+	require.Equal(t, 1, tooLarge, "only the first 413 should be needed to adapt")
+	require.EqualValues(t, 5, capper.maxBatchSizes["page"])
+
+	for i := 0; i < 1000; i++ {
+		require.False(t, publish("page"), "no more 413s are expected after the adaptation")
+	}
+
+	require.EqualValues(t, 10, capper.maxBatchSizes["batch(1,2)"], "other event types should not be affected")
+}
+
+func TestBatchSizesCapperCap(t *testing.T) {
+	maxBatchSize := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "max_batch_size"}, []string{"event_type"})
+	capper := newBatchSizesCapper([]eventType{{Type: "page"}}, []int{1, 5, 10}, []int{50, 30, 20}, maxBatchSize)
+
+	newMax, ok := capper.Cap("page", 10)
+	require.True(t, ok)
+	require.Equal(t, 5, newMax)
+
+	// 50% and 30% renormalized to 62% and 37% plus the remainder to the first element
+	concentration := *capper.concentrations["page"].Load()
+	for i := 0; i < 63; i++ {
+		require.Equal(t, 1, concentration[i])
+	}
+	for i := 63; i < 100; i++ {
+		require.Equal(t, 5, concentration[i])
+	}
+
+	_, ok = capper.Cap("page", 10) // already capped
+	require.False(t, ok)
+
+	newMax, ok = capper.Cap("page", 1) // cannot go below the smallest batch size
+	require.False(t, ok)
+	require.Equal(t, 5, newMax)
+
+	require.Equal(t, []int{50, 50}, renormalizePercentages([]int{0, 0}))
+	require.Equal(t, []int{100}, renormalizePercentages([]int{20}))
+}
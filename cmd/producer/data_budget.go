@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dataBudget accounts for the bytes that went over the wire (i.e. after compression).
+// When a limit is set, onExhausted is called exactly once as soon as the sent bytes reach it.
+type dataBudget struct {
+	limit       int64 // zero means no limit
+	sent        atomic.Int64
+	remaining   prometheus.Gauge
+	onExhausted func()
+	once        sync.Once
+}
+
+func newDataBudget(limit int64, remaining prometheus.Gauge, onExhausted func()) *dataBudget {
+	if limit > 0 {
+		remaining.Set(float64(limit))
+	}
+	return &dataBudget{
+		limit:       limit,
+		remaining:   remaining,
+		onExhausted: onExhausted,
+	}
+}
+
+// Add records n sent bytes and returns true if the budget is exhausted
+func (b *dataBudget) Add(n int64) bool {
+	sent := b.sent.Add(n)
+	if b.limit <= 0 {
+		return false
+	}
+	b.remaining.Set(float64(max(b.limit-sent, 0)))
+	if sent < b.limit {
+		return false
+	}
+	b.once.Do(b.onExhausted)
+	return true
+}
+
+// Exhausted returns true if a limit is set and the sent bytes reached it
+func (b *dataBudget) Exhausted() bool {
+	return b.limit > 0 && b.sent.Load() >= b.limit
+}
+
+// Sent returns the number of bytes sent so far
+func (b *dataBudget) Sent() int64 {
+	return b.sent.Load()
+}
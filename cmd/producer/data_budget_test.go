@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataBudget(t *testing.T) {
+	gaugeValue := func(t *testing.T, g prometheus.Gauge) float64 {
+		var m dto.Metric
+		require.NoError(t, g.Write(&m))
+		return m.GetGauge().GetValue()
+	}
+
+	t.Run("no limit", func(t *testing.T) {
+		remaining := prometheus.NewGauge(prometheus.GaugeOpts{Name: "remaining"})
+		b := newDataBudget(0, remaining, func() { t.Fatal("unexpected call") })
+		require.False(t, b.Add(1000))
+		require.False(t, b.Exhausted())
+		require.EqualValues(t, 1000, b.Sent())
+		require.Zero(t, gaugeValue(t, remaining))
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		var calls int
+		remaining := prometheus.NewGauge(prometheus.GaugeOpts{Name: "remaining"})
+		b := newDataBudget(100, remaining, func() { calls++ })
+		require.EqualValues(t, 100, gaugeValue(t, remaining))
+
+		require.False(t, b.Add(60))
+		require.EqualValues(t, 40, gaugeValue(t, remaining))
+		require.True(t, b.Add(60))
+		require.True(t, b.Exhausted())
+		require.Zero(t, gaugeValue(t, remaining))
+		require.True(t, b.Add(10))
+		require.Equal(t, 1, calls)
+		require.EqualValues(t, 130, b.Sent())
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			calls int
+		)
+		remaining := prometheus.NewGauge(prometheus.GaugeOpts{Name: "remaining"})
+		b := newDataBudget(1000, remaining, func() { mu.Lock(); calls++; mu.Unlock() })
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.Add(20)
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, 1, calls)
+		require.True(t, b.Exhausted())
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+type eventSize struct {
+	Bytes  int
+	Weight int
+}
+
+// parseEventSizes parses EVENT_SIZE_BYTES which is either a single size (e.g. "10kb") or a weighted distribution
+// like "1kb:70,10kb:25,100kb:5" whose weights should sum to 100
+func parseEventSizes(input string) ([]eventSize, error) {
+	if !strings.Contains(input, ":") {
+		size, err := parseEventSizeBytes(input)
+		if err != nil {
+			return nil, err
+		}
+		return []eventSize{{Bytes: size, Weight: 100}}, nil
+	}
+
+	var (
+		total = 0
+		parts = strings.Split(input, ",")
+		sizes = make([]eventSize, 0, len(parts))
+	)
+	for _, part := range parts {
+		kv := strings.Split(strings.TrimSpace(part), ":")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid event size %q: expected <size>:<weight>", part)
+		}
+		size, err := parseEventSizeBytes(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		weight, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight for event size %q: %w", part, err)
+		}
+		total += weight
+		sizes = append(sizes, eventSize{Bytes: size, Weight: weight})
+	}
+	if total != 100 {
+		return nil, fmt.Errorf("event sizes weights should sum to 100: %d", total)
+	}
+	return sizes, nil
+}
+
+// parseEventSizeBytes parses a size either in bytes (e.g. "1024") or with a unit (e.g. "1kb", "1kib")
+func parseEventSizeBytes(input string) (int, error) {
+	input = strings.TrimSpace(input)
+	size, err := strconv.Atoi(input)
+	if err != nil {
+		if size, err = convertToBytes(input); err != nil {
+			return 0, fmt.Errorf("invalid event size %q: %w", input, err)
+		}
+	}
+	if size < 1 {
+		return 0, fmt.Errorf("event size has to be greater than zero: %q", input)
+	}
+	return size, nil
+}
+
+// eventPadder pads the generated events so that they approximate the EVENT_SIZE_BYTES targets
+type eventPadder struct {
+	minSize       int
+	concentration []int // the target size of every percentage
+}
+
+func newEventPadder(sizes []eventSize) *eventPadder {
+	p := &eventPadder{
+		minSize:       sizes[0].Bytes,
+		concentration: make([]int, 100),
+	}
+	startID := 0
+	for _, size := range sizes {
+		p.minSize = min(p.minSize, size.Bytes)
+		for i := startID; i < size.Weight+startID; i++ {
+			p.concentration[i] = size.Bytes
+		}
+		startID += size.Weight
+	}
+	return p
+}
+
+// Validate returns an error if the events of the sample payload are bigger than the smallest target size already
+// without padding, in which case the target could never be reached
+func (p *eventPadder) Validate(eventType string, sample []byte) error {
+	_, events, err := decodeBatch(sample)
+	if err != nil {
+		return fmt.Errorf("cannot decode %s payload: %w", eventType, err)
+	}
+	for _, event := range events {
+		unpadded, err := padEvent(event, 0)
+		if err != nil {
+			return fmt.Errorf("cannot pad %s event: %w", eventType, err)
+		}
+		if len(unpadded) > p.minSize {
+			return fmt.Errorf("target event size of %d bytes is smaller than the %d bytes of the %s events without padding",
+				p.minSize, len(unpadded), eventType,
+			)
+		}
+	}
+	return nil
+}
+
+// Pad adds a properties.padding field of random alphanumeric characters to every event of the batch so that every
+// serialized event is as big as a target size picked by weight. Events bigger than their target are left unpadded.
+func (p *eventPadder) Pad(payload []byte) ([]byte, error) {
+	msg, events, err := decodeBatch(payload)
+	if err != nil {
+		return nil, err
+	}
+	padded := make([]json.RawMessage, len(events))
+	for i, event := range events {
+		if padded[i], err = padEvent(event, p.concentration[rand.Intn(100)]); err != nil {
+			return nil, err
+		}
+	}
+	return encodeBatch(msg, padded)
+}
+
+func decodeBatch(payload []byte) (map[string]json.RawMessage, []map[string]json.RawMessage, error) {
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return nil, nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	batch, ok := msg["batch"]
+	if !ok {
+		return nil, nil, fmt.Errorf("payload has no batch")
+	}
+	var events []map[string]json.RawMessage
+	if err := json.Unmarshal(batch, &events); err != nil {
+		return nil, nil, fmt.Errorf("invalid batch: %w", err)
+	}
+	return msg, events, nil
+}
+
+func encodeBatch(msg map[string]json.RawMessage, events []json.RawMessage) ([]byte, error) {
+	var err error
+	if msg["batch"], err = json.Marshal(events); err != nil {
+		return nil, fmt.Errorf("cannot encode batch: %w", err)
+	}
+	return json.Marshal(msg)
+}
+
+// padEvent returns the serialized event with a padding property that makes it as big as the target size
+func padEvent(event map[string]json.RawMessage, target int) (json.RawMessage, error) {
+	properties := make(map[string]json.RawMessage)
+	if raw, ok := event["properties"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &properties); err != nil {
+			return nil, fmt.Errorf("invalid event properties: %w", err)
+		}
+	}
+
+	encode := func(padding string) (json.RawMessage, error) {
+		properties["padding"], _ = json.Marshal(padding)
+		var err error
+		if event["properties"], err = json.Marshal(properties); err != nil {
+			return nil, fmt.Errorf("cannot encode event properties: %w", err)
+		}
+		return json.Marshal(event)
+	}
+
+	encoded, err := encode("")
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode event: %w", err)
+	}
+	if missing := target - len(encoded); missing > 0 {
+		// alphanumeric characters are not escaped so the event grows by exactly the padding length
+		return encode(randomAlphanumeric(missing))
+	}
+	return encoded, nil
+}
+
+func randomAlphanumeric(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphanumeric[rand.Intn(len(alphanumeric))]
+	}
+	return string(b)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEventSizes(t *testing.T) {
+	t.Run("single size", func(t *testing.T) {
+		sizes, err := parseEventSizes("10kb")
+		require.NoError(t, err)
+		require.Equal(t, []eventSize{{Bytes: 10000, Weight: 100}}, sizes)
+
+		sizes, err = parseEventSizes("2048")
+		require.NoError(t, err)
+		require.Equal(t, []eventSize{{Bytes: 2048, Weight: 100}}, sizes)
+	})
+	t.Run("distribution", func(t *testing.T) {
+		sizes, err := parseEventSizes("1kb:70, 10kib:25,100kb:5")
+		require.NoError(t, err)
+		require.Equal(t, []eventSize{
+			{Bytes: 1000, Weight: 70},
+			{Bytes: 10240, Weight: 25},
+			{Bytes: 100000, Weight: 5},
+		}, sizes)
+
+		p := newEventPadder(sizes)
+		require.Equal(t, 1000, p.minSize)
+		count := make(map[int]int)
+		for _, size := range p.concentration {
+			count[size]++
+		}
+		require.Equal(t, map[int]int{1000: 70, 10240: 25, 100000: 5}, count)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, input := range []string{"", "abc", "0", "-1kb", "1kb:70,10kb:20", "1kb:70,10kb", "1kb:x"} {
+			_, err := parseEventSizes(input)
+			require.Error(t, err, input)
+		}
+	})
+}
+
+func TestEventPadder(t *testing.T) {
+	templates, err := getTemplates("./../../templates/")
+	require.NoError(t, err)
+
+	generate := func(eventType string, n int) []byte {
+		id := identity{UserID: "user-1", AnonymousID: "anonymous-1"}
+		return eventGenerators[eventType](templates[eventType], id, "load-run-id", n, nil, time.Now())
+	}
+
+	for _, eventType := range []string{"track", "page", "identify", "alias"} {
+		for _, target := range []int{10_000, 100_000} {
+			for _, batchSize := range []int{1, 5} {
+				p := newEventPadder([]eventSize{{Bytes: target, Weight: 100}})
+				require.NoError(t, p.Validate(eventType, generate(eventType, 1)))
+
+				payload, err := p.Pad(generate(eventType, batchSize))
+				require.NoError(t, err)
+				require.True(t, json.Valid(payload))
+				require.InEpsilon(t, target*batchSize, len(payload), 0.05, "%s x%d", eventType, batchSize)
+
+				var batch struct {
+					Batch []struct {
+						Type       string         `json:"type"`
+						Properties map[string]any `json:"properties"`
+					} `json:"batch"`
+				}
+				require.NoError(t, json.Unmarshal(payload, &batch))
+				require.Len(t, batch.Batch, batchSize)
+				for _, event := range batch.Batch {
+					require.Equal(t, eventType, event.Type)
+					require.Regexp(t, "^[a-zA-Z0-9]+$", event.Properties["padding"])
+				}
+			}
+		}
+	}
+
+	t.Run("target smaller than the template", func(t *testing.T) {
+		p := newEventPadder([]eventSize{{Bytes: 100, Weight: 50}, {Bytes: 10_000, Weight: 50}})
+		err := p.Validate("track", generate("track", 1))
+		require.ErrorContains(t, err, "target event size of 100 bytes is smaller than the")
+		require.ErrorContains(t, err, "of the track events without padding")
+	})
+	t.Run("events bigger than the target are left unpadded", func(t *testing.T) {
+		p := newEventPadder([]eventSize{{Bytes: 100, Weight: 100}})
+		payload, err := p.Pad(generate("page", 1))
+		require.NoError(t, err)
+		require.Contains(t, string(payload), `"padding":""`)
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -16,69 +17,144 @@ import (
 // TODO: make trackEventNames configurable
 var trackEventNames = []string{"checkout", "view", "add_to_cart", "event_1", "event_2", "event_3"}
 
-type eventGenerator func(t *template.Template, userID, loadRunID string, n int, values []int) []byte
+type eventGenerator func(t *template.Template, id identity, loadRunID string, n int, values []int, timestamp time.Time) []byte
 
 var eventGenerators = map[string]eventGenerator{
 	"page":     pageFunc,
 	"track":    trackFunc,
 	"identify": identifyFunc,
+	"alias":    aliasFunc,
+}
+
+// templateData holds the values the templates are executed with.
+// A struct is cheaper than a map since the templates access its fields without allocating.
+type templateData struct {
+	NoOfEvents        int
+	Name              string
+	MessageID         string
+	UserID            string
+	AnonymousID       string
+	PreviousID        string
+	Event             string
+	Timestamp         string
+	OriginalTimestamp string
+	SentAt            string
+	LoadRunID         string
+}
+
+// buffersPool holds the buffers the templates are executed into
+var buffersPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// executeTemplate executes the template into a pooled buffer and returns a copy of the result,
+// since the payload is held by the publishers after the buffer is reused
+func executeTemplate(t *template.Template, data any) ([]byte, error) {
+	buf := buffersPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		buffersPool.Put(buf)
+	}()
+	if err := t.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(buf.Bytes()), nil
 }
 
 var (
-	pageFunc eventGenerator = func(t *template.Template, userID, loadRunID string, n int, _ []int) []byte {
-		var buf bytes.Buffer
-		err := t.Execute(&buf, map[string]any{
-			"NoOfEvents":        n,
-			"Name":              "Home",
-			"MessageID":         uuid.New().String(),
-			"AnonymousID":       userID,
-			"OriginalTimestamp": time.Now().Format(time.RFC3339),
-			"SentAt":            time.Now().Format(time.RFC3339),
-			"LoadRunID":         loadRunID,
+	pageFunc eventGenerator = func(t *template.Template, id identity, loadRunID string, n int, _ []int, timestamp time.Time) []byte {
+		ts := timestamp.Format(time.RFC3339)
+		payload, err := executeTemplate(t, &templateData{
+			NoOfEvents:        n,
+			Name:              "Home",
+			MessageID:         uuid.New().String(),
+			UserID:            id.UserID,
+			AnonymousID:       id.AnonymousID,
+			OriginalTimestamp: ts,
+			SentAt:            ts,
+			LoadRunID:         loadRunID,
 		})
 		if err != nil {
 			panic(fmt.Errorf("cannot execute page template: %w", err))
 		}
-		return buf.Bytes()
+		return payload
 	}
 
-	trackFunc eventGenerator = func(t *template.Template, userID, loadRunID string, n int, _ []int) []byte {
-		var buf bytes.Buffer
-		err := t.Execute(&buf, map[string]any{
-			"NoOfEvents": n,
-			"UserID":     userID,
-			"Event":      trackEventNames[rand.Intn(len(trackEventNames))],
-			"Timestamp":  time.Now().Format(time.RFC3339),
-			"LoadRunID":  loadRunID,
+	trackFunc eventGenerator = func(t *template.Template, id identity, loadRunID string, n int, _ []int, timestamp time.Time) []byte {
+		payload, err := executeTemplate(t, &templateData{
+			NoOfEvents:  n,
+			UserID:      id.UserID,
+			AnonymousID: id.AnonymousID,
+			Event:       trackEventNames[rand.Intn(len(trackEventNames))],
+			Timestamp:   timestamp.Format(time.RFC3339),
+			LoadRunID:   loadRunID,
 		})
 		if err != nil {
-			panic(fmt.Errorf("cannot execute page template: %w", err))
+			panic(fmt.Errorf("cannot execute track template: %w", err))
 		}
-		return buf.Bytes()
-	}
-
-	identifyFunc eventGenerator = func(t *template.Template, userID, loadRunID string, n int, _ []int) []byte {
-		var buf bytes.Buffer
-		err := t.Execute(&buf, map[string]any{
-			"NoOfEvents":        n,
-			"MessageID":         uuid.New().String(),
-			"AnonymousID":       userID,
-			"OriginalTimestamp": time.Now().Format(time.RFC3339),
-			"SentAt":            time.Now().Format(time.RFC3339),
-			"LoadRunID":         loadRunID,
+		return payload
+	}
+
+	identifyFunc eventGenerator = func(t *template.Template, id identity, loadRunID string, n int, _ []int, timestamp time.Time) []byte {
+		ts := timestamp.Format(time.RFC3339)
+		payload, err := executeTemplate(t, &templateData{
+			NoOfEvents:        n,
+			MessageID:         uuid.New().String(),
+			UserID:            id.UserID,
+			AnonymousID:       id.AnonymousID,
+			OriginalTimestamp: ts,
+			SentAt:            ts,
+			LoadRunID:         loadRunID,
 		})
 		if err != nil {
-			panic(fmt.Errorf("cannot execute page template: %w", err))
+			panic(fmt.Errorf("cannot execute identify template: %w", err))
+		}
+		return payload
+	}
+
+	aliasFunc eventGenerator = func(t *template.Template, id identity, loadRunID string, n int, _ []int, timestamp time.Time) []byte {
+		ts := timestamp.Format(time.RFC3339)
+		payload, err := executeTemplate(t, &templateData{
+			NoOfEvents:        n,
+			UserID:            id.UserID,
+			PreviousID:        id.AnonymousID,
+			OriginalTimestamp: ts,
+			SentAt:            ts,
+			LoadRunID:         loadRunID,
+		})
+		if err != nil {
+			panic(fmt.Errorf("cannot execute alias template: %w", err))
 		}
-		return buf.Bytes()
+		return payload
 	}
 
-	eventTypesRegexp = regexp.MustCompile(`(\w+)(\(([\d,]+)\))?`)
+	eventTypesRegexp = regexp.MustCompile(`(\w+)(\(([^)]*)\))?`)
 )
 
 type eventType struct {
-	Type   string
-	Values []int
+	Type    string
+	Values  []int
+	Members []batchMember // the weighted event types of a mixed batch, whose sizes are in Values
+}
+
+// String returns the event type as it was defined in EVENT_TYPES (e.g. "batch(10,0)")
+func (e eventType) String() string {
+	if len(e.Values) == 0 && len(e.Members) == 0 {
+		return e.Type
+	}
+	values := make([]string, len(e.Values))
+	for i, v := range e.Values {
+		values[i] = strconv.Itoa(v)
+	}
+	if len(e.Members) == 0 {
+		return e.Type + "(" + strings.Join(values, ",") + ")"
+	}
+	members := make([]string, len(e.Members))
+	for i, m := range e.Members {
+		members[i] = m.Type + ":" + strconv.Itoa(m.Weight)
+	}
+	if len(values) == 0 {
+		return e.Type + "(" + strings.Join(members, ",") + ")"
+	}
+	return e.Type + "(" + strings.Join(members, ",") + ";sizes=" + strings.Join(values, ",") + ")"
 }
 
 func parseEventTypes(input string) ([]eventType, error) {
@@ -86,14 +162,22 @@ func parseEventTypes(input string) ([]eventType, error) {
 	events := make([]eventType, 0, len(matches))
 	for _, match := range matches {
 		et := match[1] // First group: the type (e.g., 'page', 'batch')
+		if et == mixedBatchEventType && strings.Contains(match[3], ":") {
+			members, sizes, err := parseMixedBatch(match[3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid event type %q: %w", match[0], err)
+			}
+			events = append(events, eventType{Type: et, Values: sizes, Members: members})
+			continue
+		}
 		var values []int
 		if match[3] != "" { // Third group: the comma-separated numbers inside parentheses
 			valuesSplit := strings.Split(match[3], ",")
 			values = make([]int, 0, len(valuesSplit))
 			for _, v := range valuesSplit {
-				val, err := strconv.Atoi(v)
+				val, err := strconv.Atoi(strings.TrimSpace(v))
 				if err != nil {
-					return nil, err
+					return nil, fmt.Errorf("invalid value in event type %q: %w", match[0], err)
 				}
 				values = append(values, val)
 			}
@@ -103,13 +187,31 @@ func parseEventTypes(input string) ([]eventType, error) {
 	return events, nil
 }
 
+func getEventTypeNamesConcentration(eventTypes []eventType, hotEventTypes []int) []string {
+	var (
+		startID                 = 0
+		eventTypeConcentrations = make([]string, 100)
+	)
+	for i, hotEventPercentage := range hotEventTypes {
+		for j := startID; j < hotEventPercentage+startID; j++ {
+			eventTypeConcentrations[j] = eventTypes[i].String()
+		}
+		startID += hotEventPercentage
+	}
+	return eventTypeConcentrations
+}
+
+// getEventTypesConcentration returns the generator of every percentage. The generators return the payload with its
+// number of events, which differs from n for the mixed batches with their own sizes.
 func getEventTypesConcentration(
 	loadRunID string,
 	eventTypes []eventType,
 	hotEventTypes []int,
 	eventGenerators map[string]eventGenerator,
 	templates map[string]*template.Template,
-) []func(userID string, n int) []byte {
+	identities identityResolver,
+	timestamps func() time.Time,
+) []func(userID string, n int) ([]byte, int) {
 	totalPercentage := 0
 	for _, percentage := range hotEventTypes {
 		totalPercentage += percentage
@@ -123,12 +225,16 @@ func getEventTypesConcentration(
 
 	var (
 		startID             = 0
-		eventsConcentration = make([]func(string, int) []byte, 100)
+		eventsConcentration = make([]func(string, int) ([]byte, int), 100)
 	)
 	for i, hotEventPercentage := range hotEventTypes {
 		et := eventTypes[i]
-		f := func(userID string, n int) []byte {
-			return eventGenerators[et.Type](templates[et.Type], userID, loadRunID, n, et.Values)
+		f := func(userID string, n int) ([]byte, int) {
+			id := identities.Resolve(et.Type, userID)
+			return eventGenerators[et.Type](templates[et.Type], id, loadRunID, n, et.Values, timestamps()), n
+		}
+		if len(et.Members) > 0 {
+			f = newMixedBatchGenerator(et, eventGenerators, templates, loadRunID, identities, timestamps).Generate
 		}
 		for i := startID; i < hotEventPercentage+startID; i++ {
 			eventsConcentration[i] = f
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+var (
+	uuidRegexp       = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	sessionIDRegexp  = regexp.MustCompile(`"sessionId": \d+`)
+	trackEventRegexp = regexp.MustCompile(`"event": "(checkout|view|add_to_cart|event_1|event_2|event_3)"`)
+)
+
+// normalizeRandomFields replaces the fields that change on every execution with placeholders
+func normalizeRandomFields(payload []byte) []byte {
+	payload = uuidRegexp.ReplaceAll(payload, []byte("<uuid>"))
+	payload = sessionIDRegexp.ReplaceAll(payload, []byte(`"sessionId": <nowNano>`))
+	return trackEventRegexp.ReplaceAll(payload, []byte(`"event": "<event>"`))
+}
+
+func TestEventGeneratorsGolden(t *testing.T) {
+	templates, err := getTemplates("./../../templates/")
+	require.NoError(t, err)
+
+	id := identity{UserID: "user-1", AnonymousID: "anonymous-1"}
+	timestamp := time.Date(2024, 10, 28, 9, 42, 15, 0, time.UTC)
+	for eventType, generator := range eventGenerators {
+		t.Run(eventType, func(t *testing.T) {
+			payload := normalizeRandomFields(generator(templates[eventType], id, "load-run-id", 3, nil, timestamp))
+
+			golden := filepath.Join("testdata", eventType+".golden")
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(golden, payload, 0o644))
+			}
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, string(expected), string(payload))
+		})
+	}
+}
+
+func TestEventGenerators(t *testing.T) {
+	templates, err := getTemplates("./../../templates/")
+	require.NoError(t, err)
+	schemas, err := loadSchemas("testdata/schemas")
+	require.NoError(t, err)
+
+	id := identity{UserID: "user-1", AnonymousID: "anonymous-1"}
+	for _, tc := range []struct {
+		eventType string
+		generator eventGenerator
+		fields    map[string]string
+	}{
+		{eventType: "track", generator: trackFunc, fields: map[string]string{"userId": "user-1", "anonymousId": "anonymous-1"}},
+		{eventType: "page", generator: pageFunc, fields: map[string]string{"userId": "user-1", "anonymousId": "anonymous-1"}},
+		{eventType: "identify", generator: identifyFunc, fields: map[string]string{"userId": "user-1", "anonymousId": "anonymous-1"}},
+		{eventType: "alias", generator: aliasFunc, fields: map[string]string{"userId": "user-1", "previousId": "anonymous-1"}},
+	} {
+		t.Run(tc.eventType, func(t *testing.T) {
+			payload := tc.generator(templates[tc.eventType], id, "load-run-id", 3, nil, time.Now())
+			require.NoError(t, validatePayload(tc.eventType, payload, schemas))
+
+			var batch struct {
+				Batch []map[string]any `json:"batch"`
+			}
+			require.NoError(t, json.Unmarshal(payload, &batch))
+			require.Len(t, batch.Batch, 3)
+			for _, event := range batch.Batch {
+				require.Equal(t, tc.eventType, event["type"])
+				for field, value := range tc.fields {
+					require.Equal(t, value, event[field], field)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEventGeneration(b *testing.B) {
+	templates, err := getTemplates("./../../templates/")
+	require.NoError(b, err)
+
+	id := identity{UserID: "user-1", AnonymousID: "anonymous-1"}
+	timestamp := time.Now()
+	for _, eventType := range []string{"track", "page", "identify", "alias"} {
+		b.Run(eventType, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = eventGenerators[eventType](templates[eventType], id, "load-run-id", 10, nil, timestamp)
+			}
+		})
+	}
+}
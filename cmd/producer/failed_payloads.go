@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// failedPayloads captures the payloads of the non-retryable publish errors as newline delimited JSON files in a
+// directory (see FAILED_PAYLOAD_DIR). Every slot writes its own files, the files of all the slots are capped by
+// maxBytes: once the cap is reached the oldest closed files are removed to make room for the new captures.
+type failedPayloads struct {
+	dir         string
+	prefix      string // every file name starts with it, e.g. the hostname
+	maxBytes    int64
+	segmentSize int64 // a slot moves to a new file once its current one reaches this size
+	captured    prometheus.Counter
+	onError     func(err error)
+	now         func() time.Time
+
+	mu       sync.Mutex
+	size     int64                // bytes written in the files that were not removed yet
+	segments []failedPayloadsFile // closed files, oldest first
+
+	errorOnce sync.Once
+}
+
+type failedPayloadsFile struct {
+	path string
+	size int64
+}
+
+type failedPayload struct {
+	Timestamp time.Time       `json:"timestamp"`
+	WriteKey  string          `json:"writeKey"`
+	Error     string          `json:"error"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// newFailedPayloads creates the directory if it doesn't exist. The cap is split across the files of the given number
+// of slots so that the files being written can never exceed it on their own.
+// onError is called only once, for the first write failure.
+func newFailedPayloads(
+	dir, prefix string, maxBytes int64, slots int, captured prometheus.Counter, onError func(err error),
+) (*failedPayloads, error) {
+	if maxBytes < 1 {
+		return nil, fmt.Errorf("invalid max size: %d", maxBytes)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create directory %q: %w", dir, err)
+	}
+	return &failedPayloads{
+		dir:         dir,
+		prefix:      prefix,
+		maxBytes:    maxBytes,
+		segmentSize: max(maxBytes/int64(2*max(slots, 1)), 1),
+		captured:    captured,
+		onError:     onError,
+		now:         time.Now,
+	}, nil
+}
+
+// Writer returns the writer of the given slot, it is not safe for concurrent use
+func (f *failedPayloads) Writer(slot int) *failedPayloadsWriter {
+	return &failedPayloadsWriter{f: f, slot: slot}
+}
+
+// reserve makes room for n bytes removing the oldest closed files, it returns false if there is not enough room
+func (f *failedPayloads) reserve(n int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for f.size+n > f.maxBytes && len(f.segments) > 0 {
+		oldest := f.segments[0]
+		f.segments = f.segments[1:]
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			f.fail(fmt.Errorf("cannot remove %q: %w", oldest.path, err))
+		}
+		f.size -= oldest.size
+	}
+	if f.size+n > f.maxBytes {
+		return false
+	}
+	f.size += n
+	return true
+}
+
+// release gives back the n bytes of a failed write
+func (f *failedPayloads) release(n int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.size -= n
+}
+
+func (f *failedPayloads) rotated(file failedPayloadsFile) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.segments = append(f.segments, file)
+}
+
+func (f *failedPayloads) fail(err error) {
+	f.errorOnce.Do(func() { f.onError(err) })
+}
+
+type failedPayloadsWriter struct {
+	f    *failedPayloads
+	slot int
+	seq  int
+
+	file *os.File
+	path string
+	size int64
+}
+
+// Capture writes the payload, the write key and the publish error as a new line of the current file of the slot.
+// Write failures are reported only once by the onError callback and the payload is discarded.
+func (w *failedPayloadsWriter) Capture(writeKey string, payload []byte, publishErr error) {
+	record := failedPayload{
+		Timestamp: w.f.now().UTC(),
+		WriteKey:  writeKey,
+		Error:     publishErr.Error(),
+		Payload:   payload,
+	}
+	if !json.Valid(payload) {
+		quoted, _ := json.Marshal(string(payload))
+		record.Payload = quoted
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		w.f.fail(fmt.Errorf("cannot encode failed payload: %w", err))
+		return
+	}
+	line = append(line, '\n')
+	n := int64(len(line))
+
+	if w.file != nil && w.size+n > w.f.segmentSize {
+		w.rotate()
+	}
+	if !w.f.reserve(n) {
+		return // larger than the room left by the files currently being written
+	}
+	if w.file == nil {
+		w.seq++
+		w.path = filepath.Join(w.f.dir, fmt.Sprintf("%s-%d-%d.ndjson", w.f.prefix, w.slot, w.seq))
+		w.file, err = os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			w.f.release(n)
+			w.f.fail(fmt.Errorf("cannot create %q: %w", w.path, err))
+			return
+		}
+	}
+	written, err := w.file.Write(line)
+	w.size += int64(written)
+	if written < len(line) {
+		w.f.release(n - int64(written))
+	}
+	if err != nil {
+		w.f.fail(fmt.Errorf("cannot write %q: %w", w.path, err))
+		return
+	}
+	w.f.captured.Inc()
+}
+
+// Close closes the current file of the slot, if any
+func (w *failedPayloadsWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// rotate closes the current file and hands it over for removal once the cap is reached
+func (w *failedPayloadsWriter) rotate() {
+	if err := w.file.Close(); err != nil {
+		w.f.fail(fmt.Errorf("cannot close %q: %w", w.path, err))
+	}
+	w.f.rotated(failedPayloadsFile{path: w.path, size: w.size})
+	w.file, w.path, w.size = nil, "", 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+type failingPublisher struct{ err error }
+
+func (p failingPublisher) PublishTo(context.Context, string, []byte, map[string]string) (int, error) {
+	return 0, p.err
+}
+
+func TestFailedPayloads(t *testing.T) {
+	counterValue := func(t *testing.T, c prometheus.Counter) float64 {
+		var m dto.Metric
+		require.NoError(t, c.Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	readRecords := func(t *testing.T, dir string) []failedPayload {
+		files, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+		require.NoError(t, err)
+		var records []failedPayload
+		for _, name := range files {
+			f, err := os.Open(name)
+			require.NoError(t, err)
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				var record failedPayload
+				require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+				records = append(records, record)
+			}
+			require.NoError(t, scanner.Err())
+			require.NoError(t, f.Close())
+		}
+		return records
+	}
+	dirSize := func(t *testing.T, dir string) int64 {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		var size int64
+		for _, entry := range entries {
+			info, err := entry.Info()
+			require.NoError(t, err)
+			size += info.Size()
+		}
+		return size
+	}
+	publishAndCapture := func(t *testing.T, w *failedPayloadsWriter, writeKey string, payload []byte) {
+		client := failingPublisher{err: errors.New("non-retryable error")}
+		_, err := client.PublishTo(context.Background(), "key", payload, map[string]string{"auth": writeKey})
+		require.Error(t, err)
+		w.Capture(writeKey, payload, err)
+	}
+
+	t.Run("contents", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "failed")
+		captured := prometheus.NewCounter(prometheus.CounterOpts{Name: "captured"})
+		fp, err := newFailedPayloads(dir, "producer", 1<<20, 2, captured, func(err error) { t.Fatal(err) })
+		require.NoError(t, err)
+		require.DirExists(t, dir, "the directory should be created upfront")
+		now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		fp.now = func() time.Time { return now }
+
+		w0, w1 := fp.Writer(0), fp.Writer(1)
+		publishAndCapture(t, w0, "write-key-1", []byte(`{"batch":[{"type":"track"}]}`))
+		publishAndCapture(t, w1, "write-key-2", []byte("not json"))
+		require.NoError(t, w0.Close())
+		require.NoError(t, w1.Close())
+
+		require.FileExists(t, filepath.Join(dir, "producer-0-1.ndjson"))
+		require.FileExists(t, filepath.Join(dir, "producer-1-1.ndjson"))
+		require.ElementsMatch(t, []failedPayload{
+			{Timestamp: now, WriteKey: "write-key-1", Error: "non-retryable error", Payload: json.RawMessage(`{"batch":[{"type":"track"}]}`)},
+			{Timestamp: now, WriteKey: "write-key-2", Error: "non-retryable error", Payload: json.RawMessage(`"not json"`)},
+		}, readRecords(t, dir))
+		require.EqualValues(t, 2, counterValue(t, captured))
+	})
+
+	t.Run("size cap", func(t *testing.T) {
+		dir := t.TempDir()
+		captured := prometheus.NewCounter(prometheus.CounterOpts{Name: "captured"})
+		const maxBytes = 4096
+		fp, err := newFailedPayloads(dir, "producer", maxBytes, 1, captured, func(err error) { t.Fatal(err) })
+		require.NoError(t, err)
+
+		w := fp.Writer(0)
+		payload := []byte(`{"batch":[{"type":"track","properties":{"padding":"0123456789012345678901234567890123456789"}}]}`)
+		for i := 0; i < 200; i++ {
+			publishAndCapture(t, w, "write-key", payload)
+			require.LessOrEqual(t, dirSize(t, dir), int64(maxBytes))
+		}
+		require.NoError(t, w.Close())
+
+		require.EqualValues(t, 200, counterValue(t, captured))
+		records := readRecords(t, dir)
+		require.NotEmpty(t, records)
+		require.Less(t, len(records), 200, "the oldest files should have been removed")
+		_, err = os.Stat(filepath.Join(dir, "producer-0-1.ndjson"))
+		require.ErrorIs(t, err, os.ErrNotExist, "the oldest file should have been removed")
+	})
+
+	t.Run("write failures are reported once", func(t *testing.T) {
+		dir := t.TempDir()
+		captured := prometheus.NewCounter(prometheus.CounterOpts{Name: "captured"})
+		var reported []error
+		fp, err := newFailedPayloads(dir, "producer", 1<<20, 1, captured, func(err error) { reported = append(reported, err) })
+		require.NoError(t, err)
+		require.NoError(t, os.Remove(dir))
+
+		w := fp.Writer(0)
+		for i := 0; i < 3; i++ {
+			publishAndCapture(t, w, "write-key", []byte("{}"))
+		}
+		require.Len(t, reported, 1)
+		require.Zero(t, counterValue(t, captured))
+	})
+
+	t.Run("invalid max size", func(t *testing.T) {
+		_, err := newFailedPayloads(t.TempDir(), "producer", 0, 1, nil, nil)
+		require.Error(t, err)
+	})
+}
@@ -16,9 +16,14 @@ import (
 type message struct {
 	Payload    []byte
 	UserID     string
+	EventType  string
 	NoOfEvents int64
+	ClientIP   xffIP // the IP the user comes from, with SPOOF_CLIENT_IP
 }
 
+// getTemplates parses all the files in the templates directory as one template set so that the event templates can
+// include the partials, i.e. the files starting with templatesPartialPrefix (e.g. {{template "context" .}} with
+// "context" defined in _context.json.tmpl). Every other file is the template of the event type named after it.
 func getTemplates(templatesPath string) (map[string]*template.Template, error) {
 	files, err := os.ReadDir(templatesPath)
 	if err != nil {
@@ -29,31 +34,42 @@ func getTemplates(templatesPath string) (map[string]*template.Template, error) {
 		"uuid":    func() string { return uuid.New().String() },
 		"sub":     func(a, b int) int { return a - b },
 		"nowNano": func() int64 { return time.Now().UnixNano() },
-		"loop": func(n int) <-chan int {
-			ch := make(chan int)
-			go func() {
-				for i := 0; i < n; i++ {
-					ch <- i
-				}
-				close(ch)
-			}()
-			return ch
+		// loop returns the indexes to range over, i.e. {{range $i := loop 3}} iterates over 0, 1, 2
+		"loop": func(n int) []int {
+			indexes := make([]int, n)
+			for i := range indexes {
+				indexes[i] = i
+			}
+			return indexes
 		},
+		// randomInt returns a random number in [min, max], i.e. {{randomInt 1 6}}
+		"randomInt": func(min, max int) int { return min + rand.Intn(max-min+1) },
+		// randomFrom returns one of its arguments at random, i.e. {{randomFrom "ios" "android" "web"}}
+		"randomFrom": func(values ...any) any { return values[rand.Intn(len(values))] },
+		// formatTime formats the current UTC time, i.e. {{formatTime "2006-01-02"}}
+		"formatTime": func(layout string) string { return time.Now().UTC().Format(layout) },
 	}
 
-	templates := make(map[string]*template.Template)
+	var (
+		set        = template.New("").Funcs(funcMap)
+		eventTypes = make(map[string]string) // event type -> template name
+	)
 	for _, file := range files {
-		if !file.IsDir() {
-			tmpl, err := template.New(file.Name()).Funcs(funcMap).ParseFiles(filepath.Join(templatesPath, file.Name()))
-			if err != nil {
-				return nil, fmt.Errorf("cannot parse template file: %w", err)
-			}
-
-			eventType := strings.Replace(file.Name(), templatesExtension, "", 1)
-			templates[eventType] = tmpl
+		if file.IsDir() {
+			continue
+		}
+		if _, err := set.ParseFiles(filepath.Join(templatesPath, file.Name())); err != nil {
+			return nil, fmt.Errorf("cannot parse template file: %w", err)
+		}
+		if !strings.HasPrefix(file.Name(), templatesPartialPrefix) {
+			eventTypes[strings.Replace(file.Name(), templatesExtension, "", 1)] = file.Name()
 		}
 	}
 
+	templates := make(map[string]*template.Template, len(eventTypes))
+	for eventType, name := range eventTypes {
+		templates[eventType] = set.Lookup(name)
+	}
 	return templates, nil
 }
 
@@ -69,6 +85,11 @@ func getUserIDsConcentration(totalUsers int, hotUserGroups []int, random bool) [
 		panic("total users must be divisible by the number of hot user groups")
 	}
 
+	return getUserIDsConcentrationOf(newUserIDs(totalUsers, random), hotUserGroups)
+}
+
+// newUserIDs returns either random UUIDs or the numbers from 0 to totalUsers-1
+func newUserIDs(totalUsers int, random bool) []string {
 	userIDs := make([]string, totalUsers)
 	for i := 0; i < totalUsers; i++ {
 		if random {
@@ -77,8 +98,14 @@ func getUserIDsConcentration(totalUsers int, hotUserGroups []int, random bool) [
 			userIDs[i] = strconv.Itoa(i)
 		}
 	}
+	return userIDs
+}
 
+// getUserIDsConcentrationOf splits the given users in hot user groups, so that the same users can be
+// redistributed when the hot user groups change
+func getUserIDsConcentrationOf(userIDs []string, hotUserGroups []int) []func() string {
 	var (
+		totalUsers           = len(userIDs)
 		startUserID          = 0
 		startConcentration   = 0
 		userIDsConcentration = make([]func() string, 100)
@@ -202,6 +229,42 @@ func mustInt(s string) int {
 	return i
 }
 
+func optionalInt(s string, def int) int {
+	v := os.Getenv(s)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		panic(fmt.Errorf("invalid int: %s: %v", s, err))
+	}
+	return i
+}
+
+func optionalFloat(s string, def float64) float64 {
+	v := os.Getenv(s)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		panic(fmt.Errorf("invalid float: %s: %v", s, err))
+	}
+	return f
+}
+
+func optionalDuration(s string, def time.Duration) time.Duration {
+	v := os.Getenv(s)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		panic(fmt.Errorf("invalid duration: %s: %v", s, err))
+	}
+	return d
+}
+
 func optionalBool(s string, def bool) bool {
 	v := os.Getenv(s)
 	if v == "" {
@@ -244,21 +307,3 @@ func mustMap(s string) []int {
 	}
 	return r
 }
-
-func printErr(err error, retry ...bool) {
-	if len(retry) > 0 && retry[0] == true {
-		_, _ = fmt.Fprintf(os.Stdout, "error: %v (retrying...)\n\n", err)
-		return
-	}
-	_, _ = fmt.Fprintf(os.Stdout, "error: %v\n\n", err)
-}
-
-func printLeakyErr(ch chan<- error, err error, retry ...bool) {
-	if len(retry) > 0 && retry[0] == true {
-		err = fmt.Errorf("%v (retrying...)", err)
-	}
-	select {
-	case ch <- err:
-	default:
-	}
-}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// blockedSendThreshold is how long a generator has to wait for the publishers for a send to count as blocked
+const blockedSendThreshold = time.Millisecond
+
+// messageSender hands the generated messages to the publishers recording for how long the generators are blocked.
+// Generators that are blocked most of the time mean that the publishers are the bottleneck, while an always empty
+// channel means that there are not enough generators.
+type messageSender struct {
+	ch             chan<- *message
+	blockedSends   prometheus.Counter // sends blocked for more than blockedSendThreshold
+	blockedSeconds prometheus.Counter
+}
+
+// Send returns an error if the context is canceled before the message is handed to a publisher
+func (s *messageSender) Send(ctx context.Context, msg *message) error {
+	start := time.Now()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case s.ch <- msg:
+	}
+	blocked := time.Since(start)
+	s.blockedSeconds.Add(blocked.Seconds())
+	if blocked > blockedSendThreshold {
+		s.blockedSends.Inc()
+	}
+	return nil
+}
+
+// sampleChannelDepth sets the gauge to the number of messages waiting for the publishers on every interval,
+// until the context is canceled
+func sampleChannelDepth(ctx context.Context, ch chan *message, depth prometheus.Gauge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			depth.Set(float64(len(ch)))
+		}
+	}
+}
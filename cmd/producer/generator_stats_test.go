@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageSender(t *testing.T) {
+	counterValue := func(t *testing.T, c prometheus.Counter) float64 {
+		var m dto.Metric
+		require.NoError(t, c.Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	newSender := func(ch chan *message) *messageSender {
+		return &messageSender{
+			ch:             ch,
+			blockedSends:   prometheus.NewCounter(prometheus.CounterOpts{Name: "blocked_sends"}),
+			blockedSeconds: prometheus.NewCounter(prometheus.CounterOpts{Name: "blocked_seconds"}),
+		}
+	}
+	const messages = 10
+
+	t.Run("slow consumer", func(t *testing.T) {
+		ch := make(chan *message)
+		go func() {
+			for range ch {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+		defer close(ch)
+
+		s := newSender(ch)
+		for i := 0; i < messages; i++ {
+			require.NoError(t, s.Send(context.Background(), &message{}))
+		}
+		// every send but the first one waits for the consumer to be done with the previous message
+		require.GreaterOrEqual(t, counterValue(t, s.blockedSeconds), 0.08)
+		require.GreaterOrEqual(t, counterValue(t, s.blockedSends), float64(messages-2))
+	})
+	t.Run("fast consumer", func(t *testing.T) {
+		ch := make(chan *message, messages)
+		s := newSender(ch)
+		for i := 0; i < messages; i++ {
+			require.NoError(t, s.Send(context.Background(), &message{}))
+		}
+		require.Less(t, counterValue(t, s.blockedSeconds), 0.01)
+		require.Zero(t, counterValue(t, s.blockedSends))
+	})
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s := newSender(make(chan *message))
+		require.ErrorIs(t, s.Send(ctx, &message{}), context.Canceled)
+	})
+}
+
+func TestSampleChannelDepth(t *testing.T) {
+	ch := make(chan *message, 10)
+	for i := 0; i < 3; i++ {
+		ch <- &message{}
+	}
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "depth"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sampleChannelDepth(ctx, ch, depth, time.Millisecond)
+	}()
+
+	require.Eventually(t, func() bool {
+		var m dto.Metric
+		_ = depth.Write(&m)
+		return m.GetGauge().GetValue() == 3
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// healthHandler always replies with 200 OK once the HTTP server is up
+func healthHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// readyHandler replies with 200 OK once ready is true and with 503 Service Unavailable before that
+// or as soon as ctx is canceled (i.e. during shutdown)
+func readyHandler(ctx context.Context, ready *atomic.Bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if !ready.Load() || ctx.Err() != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthAndReady(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var ready atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", healthHandler)
+	mux.Handle("/ready", readyHandler(ctx, &ready))
+
+	statusCode := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	// starting up
+	require.Equal(t, http.StatusOK, statusCode("/health"))
+	require.Equal(t, http.StatusServiceUnavailable, statusCode("/ready"))
+
+	// generating load
+	ready.Store(true)
+	require.Equal(t, http.StatusOK, statusCode("/health"))
+	require.Equal(t, http.StatusOK, statusCode("/ready"))
+
+	// shutting down
+	cancel()
+	require.Equal(t, http.StatusOK, statusCode("/health"))
+	require.Equal(t, http.StatusServiceUnavailable, statusCode("/ready"))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/google/uuid"
+)
+
+const (
+	identityModeSimple = "simple" // the same ID is used as userId and anonymousId
+	identityModeSplit  = "split"  // every user has a stable anonymousId that differs from its userId
+	identityModeAlias  = "alias"  // like split, plus alias events linking the two IDs are allowed
+)
+
+// anonymousIDNamespace is used to derive a stable anonymousId from every userId
+var anonymousIDNamespace = uuid.MustParse("5c4b6d0e-6d7a-4c1e-9f55-3a3c1b2f7e10")
+
+// identity holds the IDs carried by a generated event, empty IDs are omitted from the payload
+type identity struct {
+	UserID      string
+	AnonymousID string
+}
+
+func parseIdentityMode(v string) (string, error) {
+	switch v {
+	case identityModeSimple, identityModeSplit, identityModeAlias:
+		return v, nil
+	default:
+		return "", fmt.Errorf("identity mode out of the known domain [%s,%s,%s]: %s",
+			identityModeSimple, identityModeSplit, identityModeAlias, v,
+		)
+	}
+}
+
+type identityResolver struct {
+	mode string
+	// anonymousPercentage is the percentage of users whose track and page events carry only the anonymousId
+	anonymousPercentage int
+}
+
+// Resolve returns the IDs that an event of the given type should carry for the given user
+func (r identityResolver) Resolve(eventType, userID string) identity {
+	if r.mode == identityModeSimple {
+		switch eventType {
+		case "track":
+			return identity{UserID: userID}
+		case "identify":
+			return identity{UserID: userID, AnonymousID: userID}
+		default:
+			return identity{AnonymousID: userID}
+		}
+	}
+
+	id := identity{
+		UserID:      userID,
+		AnonymousID: uuid.NewSHA1(anonymousIDNamespace, []byte(userID)).String(),
+	}
+	if eventType != "identify" && eventType != "alias" && r.isAnonymous(userID) {
+		id.UserID = ""
+	}
+	return id
+}
+
+// isAnonymous returns true if the user is in the anonymous percentage, the result is stable for every userID
+func (r identityResolver) isAnonymous(userID string) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(userID))
+	return int(h.Sum32()%100) < r.anonymousPercentage
+}
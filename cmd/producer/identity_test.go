@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityModes(t *testing.T) {
+	templates, err := getTemplates("./../../templates/")
+	require.NoError(t, err)
+
+	// generate returns the first event of the batch generated for the given user
+	generate := func(t *testing.T, r identityResolver, eventType, userID string) map[string]any {
+		t.Helper()
+		payload := eventGenerators[eventType](templates[eventType], r.Resolve(eventType, userID), "load-run-id", 1, nil, time.Now())
+		var batch struct {
+			Batch []map[string]any `json:"batch"`
+		}
+		require.NoError(t, json.Unmarshal(payload, &batch), string(payload))
+		require.Len(t, batch.Batch, 1)
+		return batch.Batch[0]
+	}
+
+	t.Run("simple", func(t *testing.T) {
+		r := identityResolver{mode: identityModeSimple}
+
+		track := generate(t, r, "track", "user-1")
+		require.Equal(t, "user-1", track["userId"])
+		require.NotContains(t, track, "anonymousId")
+
+		page := generate(t, r, "page", "user-1")
+		require.Equal(t, "user-1", page["anonymousId"])
+		require.NotContains(t, page, "userId")
+
+		identify := generate(t, r, "identify", "user-1")
+		require.Equal(t, "user-1", identify["userId"])
+		require.Equal(t, "user-1", identify["anonymousId"])
+	})
+
+	t.Run("split", func(t *testing.T) {
+		r := identityResolver{mode: identityModeSplit, anonymousPercentage: 50}
+
+		var anonymousUsers, knownUsers int
+		for i := 0; i < 100; i++ {
+			userID := "user-" + strconv.Itoa(i)
+
+			identify := generate(t, r, "identify", userID)
+			require.Equal(t, userID, identify["userId"])
+			anonymousID := identify["anonymousId"]
+			require.NotEmpty(t, anonymousID)
+			require.NotEqual(t, userID, anonymousID)
+			require.Equal(t, anonymousID, generate(t, r, "identify", userID)["anonymousId"], "the mapping should be stable")
+
+			for _, eventType := range []string{"track", "page"} {
+				event := generate(t, r, eventType, userID)
+				require.Equal(t, anonymousID, event["anonymousId"])
+				if r.isAnonymous(userID) {
+					require.NotContains(t, event, "userId")
+				} else {
+					require.Equal(t, userID, event["userId"])
+				}
+			}
+			if r.isAnonymous(userID) {
+				anonymousUsers++
+			} else {
+				knownUsers++
+			}
+		}
+		require.Positive(t, anonymousUsers)
+		require.Positive(t, knownUsers)
+
+		for _, percentage := range []int{0, 100} {
+			r := identityResolver{mode: identityModeSplit, anonymousPercentage: percentage}
+			event := generate(t, r, "track", "user-1")
+			if percentage == 0 {
+				require.Equal(t, "user-1", event["userId"])
+			} else {
+				require.NotContains(t, event, "userId")
+			}
+		}
+	})
+
+	t.Run("alias", func(t *testing.T) {
+		r := identityResolver{mode: identityModeAlias, anonymousPercentage: 100}
+
+		alias := generate(t, r, "alias", "user-1")
+		require.Equal(t, "alias", alias["type"])
+		require.Equal(t, "user-1", alias["userId"])
+		require.Equal(t, generate(t, r, "identify", "user-1")["anonymousId"], alias["previousId"])
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		for _, v := range []string{identityModeSimple, identityModeSplit, identityModeAlias} {
+			mode, err := parseIdentityMode(v)
+			require.NoError(t, err)
+			require.Equal(t, v, mode)
+		}
+		_, err := parseIdentityMode("stitch")
+		require.Error(t, err)
+	})
+}
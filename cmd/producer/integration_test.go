@@ -1,13 +1,45 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/prometheus/common/expfmt"
+	"github.com/rudderlabs/rudder-go-kit/logger"
 	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+
+	"rudder-load/internal/producer"
 )
 
+// setBaseEnv sets the minimal environment to run the producer, the tests override what they need
+func setBaseEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("MODE", "stdout")
+	t.Setenv("HOSTNAME", "rudder-load-baseline-0-test")
+	t.Setenv("CONCURRENCY", "1")
+	t.Setenv("MESSAGE_GENERATORS", "1")
+	t.Setenv("MAX_EVENTS_PER_SECOND", "100")
+	t.Setenv("SOURCES", "2lNXnjJU9xrbUERT3Uy3Po8jKbr")
+	t.Setenv("SOFT_MEMORY_LIMIT", "256mb")
+	t.Setenv("TOTAL_USERS", "100")
+	t.Setenv("HOT_USER_GROUPS", "100")
+	t.Setenv("EVENT_TYPES", "track")
+	t.Setenv("HOT_EVENT_TYPES", "100")
+	t.Setenv("BATCH_SIZES", "1")
+	t.Setenv("HOT_BATCH_SIZES", "100")
+	t.Setenv("TEMPLATES_PATH", "./../../templates/")
+}
+
 func TestIntegration(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -46,9 +78,279 @@ func TestIntegration(t *testing.T) {
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		if exitCode := run(ctx); exitCode != 0 {
+		if exitCode := run(ctx, logger.NOP); exitCode != 0 {
+			t.Errorf("run exited with %d", exitCode)
+		}
+	}()
+	<-done
+}
+
+func TestIntegrationMetricsByEventType(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Setenv("MODE", "stdout")
+	t.Setenv("HOSTNAME", "rudder-load-baseline-0-test")
+	t.Setenv("CONCURRENCY", "1")
+	t.Setenv("MESSAGE_GENERATORS", "1")
+	t.Setenv("MAX_EVENTS_PER_SECOND", "100")
+	t.Setenv("SOURCES", "2lNXnjJU9xrbUERT3Uy3Po8jKbr")
+	t.Setenv("SOFT_MEMORY_LIMIT", "256mb")
+	t.Setenv("TOTAL_USERS", "100")
+	t.Setenv("HOT_USER_GROUPS", "100")
+	t.Setenv("EVENT_TYPES", "track,page")
+	t.Setenv("HOT_EVENT_TYPES", "50,50")
+	t.Setenv("BATCH_SIZES", "1")
+	t.Setenv("HOT_BATCH_SIZES", "100")
+	t.Setenv("TEMPLATES_PATH", "./../../templates/")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if exitCode := run(ctx, logger.NOP); exitCode != 0 {
 			t.Errorf("run exited with %d", exitCode)
 		}
 	}()
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://localhost:9102/metrics")
+		if err != nil {
+			return false
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(resp.Body)
+		if err != nil {
+			return false
+		}
+		mf, ok := families[metricsPrefix+"published_messages_by_type_count"]
+		if !ok {
+			return false
+		}
+		published := make(map[string]float64)
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "event_type" {
+					published[l.GetValue()] = m.GetCounter().GetValue()
+				}
+			}
+		}
+		return len(published) == 2 && published["track"] > 0 && published["page"] > 0
+	}, 10*time.Second, 100*time.Millisecond)
+
+	cancel()
 	<-done
 }
+
+func TestIntegrationShutdownDrain(t *testing.T) {
+	publish := func(t *testing.T, drainTimeout string) int64 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var requests atomic.Int64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) == 20 {
+				cancel()
+			}
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(srv.Close)
+
+		t.Setenv("MODE", "http")
+		t.Setenv("HOSTNAME", "rudder-load-baseline-0-test")
+		t.Setenv("CONCURRENCY", "10")
+		t.Setenv("MESSAGE_GENERATORS", "1")
+		t.Setenv("MAX_EVENTS_PER_SECOND", "0")
+		t.Setenv("SOURCES", "2lNXnjJU9xrbUERT3Uy3Po8jKbr")
+		t.Setenv("SOFT_MEMORY_LIMIT", "256mb")
+		t.Setenv("TOTAL_USERS", "100")
+		t.Setenv("HOT_USER_GROUPS", "100")
+		t.Setenv("EVENT_TYPES", "track")
+		t.Setenv("HOT_EVENT_TYPES", "100")
+		t.Setenv("BATCH_SIZES", "1")
+		t.Setenv("HOT_BATCH_SIZES", "100")
+		t.Setenv("HTTP_READ_TIMEOUT", "5s")
+		t.Setenv("HTTP_WRITE_TIMEOUT", "5s")
+		t.Setenv("HTTP_ENDPOINT", srv.URL)
+		t.Setenv("TEMPLATES_PATH", "./../../templates/")
+		t.Setenv("SHUTDOWN_DRAIN_TIMEOUT", drainTimeout)
+
+		if exitCode := run(ctx, logger.NOP); exitCode != 0 {
+			t.Errorf("run exited with %d", exitCode)
+		}
+		return requests.Load()
+	}
+
+	withoutDrain := publish(t, "0")
+	withDrain := publish(t, "10s")
+	// with 10 slots there are at most 9 other requests in flight when the 20th request cancels the context,
+	// on top of those the drain publishes the 10 messages buffered in the channel
+	require.LessOrEqual(t, withoutDrain, int64(29))
+	require.GreaterOrEqual(t, withDrain, int64(30))
+}
+
+func TestIntegrationMaxData(t *testing.T) {
+	const (
+		maxData        = 50000
+		concurrency    = 10
+		maxMessageSize = 1000 // a track with a batch size of 1 is well below this
+	)
+
+	var receivedBytes atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		receivedBytes.Add(int64(len(body)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("MODE", "http")
+	t.Setenv("HOSTNAME", "rudder-load-baseline-0-test")
+	t.Setenv("CONCURRENCY", strconv.Itoa(concurrency))
+	t.Setenv("MESSAGE_GENERATORS", "1")
+	t.Setenv("MAX_EVENTS_PER_SECOND", "0")
+	t.Setenv("SOURCES", "2lNXnjJU9xrbUERT3Uy3Po8jKbr")
+	t.Setenv("SOFT_MEMORY_LIMIT", "256mb")
+	t.Setenv("TOTAL_USERS", "100")
+	t.Setenv("HOT_USER_GROUPS", "100")
+	t.Setenv("EVENT_TYPES", "track")
+	t.Setenv("HOT_EVENT_TYPES", "100")
+	t.Setenv("BATCH_SIZES", "1")
+	t.Setenv("HOT_BATCH_SIZES", "100")
+	t.Setenv("HTTP_READ_TIMEOUT", "5s")
+	t.Setenv("HTTP_WRITE_TIMEOUT", "5s")
+	t.Setenv("HTTP_ENDPOINT", srv.URL)
+	t.Setenv("TEMPLATES_PATH", "./../../templates/")
+
+	t.Run("run ends once the budget is exhausted", func(t *testing.T) {
+		t.Setenv("MAX_DATA", "50kb")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		require.Equal(t, 0, run(ctx, logger.NOP))
+		require.NoError(t, ctx.Err(), "run should return without a termination signal")
+		require.GreaterOrEqual(t, receivedBytes.Load(), int64(maxData))
+		// only the messages that were in flight when the budget got exhausted can overshoot it
+		require.LessOrEqual(t, receivedBytes.Load(), int64(maxData+concurrency*maxMessageSize))
+	})
+
+	t.Run("invalid budget", func(t *testing.T) {
+		for _, maxData := range []string{"50", "abc", "0kb"} {
+			t.Setenv("MAX_DATA", maxData)
+			require.Equal(t, 1, run(context.Background(), logger.NOP), maxData)
+		}
+	})
+}
+
+func TestIntegrationTotalEvents(t *testing.T) {
+	const (
+		totalEvents  = 1000
+		maxBatchSize = 5
+	)
+
+	var receivedEvents atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Batch []json.RawMessage `json:"batch"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		receivedEvents.Add(int64(len(payload.Batch)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	setBaseEnv(t)
+	t.Setenv("MODE", "http")
+	t.Setenv("CONCURRENCY", "10")
+	t.Setenv("MESSAGE_GENERATORS", "4")
+	t.Setenv("MAX_EVENTS_PER_SECOND", "0")
+	t.Setenv("EVENT_TYPES", "track,page")
+	t.Setenv("HOT_EVENT_TYPES", "50,50")
+	t.Setenv("BATCH_SIZES", "1,"+strconv.Itoa(maxBatchSize))
+	t.Setenv("HOT_BATCH_SIZES", "50,50")
+	t.Setenv("HTTP_READ_TIMEOUT", "5s")
+	t.Setenv("HTTP_WRITE_TIMEOUT", "5s")
+	t.Setenv("HTTP_ENDPOINT", srv.URL)
+	t.Setenv("TOTAL_EVENTS", strconv.Itoa(totalEvents))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	require.Equal(t, 0, run(ctx, logger.NOP))
+	require.NoError(t, ctx.Err(), "run should return without a termination signal")
+	// only the message reaching the limit can overshoot it
+	require.GreaterOrEqual(t, receivedEvents.Load(), int64(totalEvents))
+	require.Less(t, receivedEvents.Load(), int64(totalEvents+maxBatchSize))
+}
+
+func TestIntegrationFileMode(t *testing.T) {
+	const totalEvents = 50
+	dir := t.TempDir()
+
+	setBaseEnv(t)
+	t.Setenv("MODE", modeFile)
+	t.Setenv("CONCURRENCY", "3")
+	t.Setenv("USE_ONE_CLIENT_PER_SLOT", "true")
+	t.Setenv("MAX_EVENTS_PER_SECOND", "0")
+	t.Setenv("TOTAL_EVENTS", strconv.Itoa(totalEvents))
+	t.Setenv("FILE_OUTPUT_PATH", dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	require.Equal(t, 0, run(ctx, logger.NOP))
+
+	// the files are flushed when run returns
+	files, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	require.NoError(t, err)
+	require.Len(t, files, 3, "one file per slot")
+	var records int
+	for _, file := range files {
+		f, err := os.Open(file)
+		require.NoError(t, err)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record producer.FileRecord
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records++
+		}
+		require.NoError(t, scanner.Err())
+		require.NoError(t, f.Close())
+	}
+	require.Equal(t, totalEvents, records)
+}
+
+func TestIntegrationTotalEventsBlockedGenerators(t *testing.T) {
+	const totalEvents = 200
+
+	var receivedEvents atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Batch []json.RawMessage `json:"batch"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		receivedEvents.Add(int64(len(payload.Batch)))
+		time.Sleep(time.Millisecond) // the generators are blocked sending most of the time
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	setBaseEnv(t)
+	t.Setenv("MODE", "http")
+	t.Setenv("CONCURRENCY", "1")
+	t.Setenv("MESSAGE_GENERATORS", "8")
+	t.Setenv("MAX_EVENTS_PER_SECOND", "0")
+	t.Setenv("HTTP_ENDPOINT", srv.URL)
+	t.Setenv("TOTAL_EVENTS", strconv.Itoa(totalEvents))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	require.Equal(t, 0, run(ctx, logger.NOP))
+	require.NoError(t, ctx.Err(), "run should return without a termination signal")
+	// with a batch size of one there is no overshoot and the messages of the blocked generators are not lost
+	require.EqualValues(t, totalEvents, receivedEvents.Load())
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type keyRotation struct {
+	OldKey string
+	NewKey string
+	After  time.Duration // offset from the start of the publishing
+}
+
+// parseKeyRotations parses write key rotations like "old-key:new-key@15m,other-key:another-key@30m"
+func parseKeyRotations(input string) ([]keyRotation, error) {
+	parts := strings.Split(input, ",")
+	rotations := make([]keyRotation, 0, len(parts))
+	for _, part := range parts {
+		keys, offset, ok := strings.Cut(strings.TrimSpace(part), "@")
+		if !ok {
+			return nil, fmt.Errorf("invalid key rotation %q: expected <old key>:<new key>@<offset>", part)
+		}
+		oldKey, newKey, ok := strings.Cut(keys, ":")
+		if !ok || oldKey == "" || newKey == "" {
+			return nil, fmt.Errorf("invalid keys in key rotation %q: expected <old key>:<new key>", part)
+		}
+		if oldKey == newKey {
+			return nil, fmt.Errorf("key rotation %q rotates a key to itself", part)
+		}
+		after, err := time.ParseDuration(offset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset in key rotation %q: %w", part, err)
+		}
+		if after < 0 {
+			return nil, fmt.Errorf("offset in key rotation %q cannot be negative", part)
+		}
+		rotations = append(rotations, keyRotation{OldKey: oldKey, NewKey: newKey, After: after})
+	}
+	slices.SortStableFunc(rotations, func(a, b keyRotation) int {
+		return cmp.Compare(a.After, b.After)
+	})
+	return rotations, nil
+}
+
+// keyRotator maps the write keys to the ones they were rotated to.
+// The mapping is replaced as a whole on every rotation so that the publishers can read it without locks.
+type keyRotator struct {
+	mu      sync.Mutex // serializes the rotations
+	rotated atomic.Pointer[map[string]string]
+}
+
+// WriteKey returns the key that the given write key was rotated to, or the write key itself
+func (r *keyRotator) WriteKey(writeKey string) string {
+	rotated := r.rotated.Load()
+	if rotated == nil {
+		return writeKey
+	}
+	if newKey, ok := (*rotated)[writeKey]; ok {
+		return newKey
+	}
+	return writeKey
+}
+
+// Rotate replaces the old key with the new one, including where the old key replaced a previous key
+func (r *keyRotator) Rotate(oldKey, newKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rotated := make(map[string]string)
+	if current := r.rotated.Load(); current != nil {
+		rotated = maps.Clone(*current)
+	}
+	for k, v := range rotated {
+		if v == oldKey {
+			rotated[k] = newKey
+		}
+	}
+	rotated[oldKey] = newKey
+	r.rotated.Store(&rotated)
+}
+
+// Run fires the rotations once their offsets elapse, calling onRotation after every one of them.
+// It returns when all the rotations are fired or the context is canceled.
+func (r *keyRotator) Run(ctx context.Context, rotations []keyRotation, onRotation func(keyRotation)) {
+	start := time.Now()
+	for _, rotation := range rotations {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(start.Add(rotation.After))):
+		}
+		r.Rotate(rotation.OldKey, rotation.NewKey)
+		onRotation(rotation)
+	}
+}
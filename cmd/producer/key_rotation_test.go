@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rudderlabs/rudder-go-kit/logger"
+	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyRotations(t *testing.T) {
+	rotations, err := parseKeyRotations("b:c@30m, a:b@15m")
+	require.NoError(t, err)
+	require.Equal(t, []keyRotation{
+		{OldKey: "a", NewKey: "b", After: 15 * time.Minute},
+		{OldKey: "b", NewKey: "c", After: 30 * time.Minute},
+	}, rotations)
+
+	for _, input := range []string{"", "a:b", "a@15m", ":b@15m", "a:@15m", "a:a@15m", "a:b@x", "a:b@-1m"} {
+		_, err := parseKeyRotations(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestKeyRotator(t *testing.T) {
+	t.Run("rotate", func(t *testing.T) {
+		var r keyRotator
+		require.Equal(t, "a", r.WriteKey("a"))
+
+		r.Rotate("a", "b")
+		require.Equal(t, "b", r.WriteKey("a"))
+		require.Equal(t, "b", r.WriteKey("b"))
+		require.Equal(t, "x", r.WriteKey("x"))
+
+		r.Rotate("b", "c") // the traffic of a was moved to b so it follows it
+		require.Equal(t, "c", r.WriteKey("a"))
+		require.Equal(t, "c", r.WriteKey("b"))
+	})
+	t.Run("run", func(t *testing.T) {
+		var (
+			r     keyRotator
+			fired []keyRotation
+		)
+		r.Run(context.Background(), []keyRotation{
+			{OldKey: "a", NewKey: "b", After: 10 * time.Millisecond},
+			{OldKey: "x", NewKey: "y", After: 20 * time.Millisecond},
+		}, func(rotation keyRotation) {
+			fired = append(fired, rotation)
+		})
+		require.Len(t, fired, 2)
+		require.Equal(t, "b", r.WriteKey("a"))
+		require.Equal(t, "y", r.WriteKey("x"))
+	})
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var r keyRotator
+		r.Run(ctx, []keyRotation{{OldKey: "a", NewKey: "b", After: time.Hour}}, func(keyRotation) {
+			t.Error("no rotation should fire")
+		})
+		require.Equal(t, "a", r.WriteKey("a"))
+	})
+}
+
+func TestIntegrationKeyRotation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		writeKeys []string // in the order they were first observed
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeKey, _, _ := r.BasicAuth()
+		mu.Lock()
+		if len(writeKeys) == 0 || writeKeys[len(writeKeys)-1] != writeKey {
+			writeKeys = append(writeKeys, writeKey)
+		}
+		if writeKey == "new-key" {
+			cancel()
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	setBaseEnv(t)
+	t.Setenv("MODE", "http")
+	t.Setenv("SOURCES", "old-key")
+	t.Setenv("HTTP_ENDPOINT", srv.URL)
+	t.Setenv("KEY_ROTATION", "old-key:new-key@200ms")
+
+	require.Equal(t, 0, run(ctx, logger.NOP))
+	require.ErrorIs(t, ctx.Err(), context.Canceled, "the new key should be observed before the timeout")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"old-key", "new-key"}, writeKeys)
+}
+
+func TestIntegrationKeyRotationUnknownKey(t *testing.T) {
+	setBaseEnv(t)
+	t.Setenv("SOURCES", "old-key")
+	t.Setenv("KEY_ROTATION", "other-key:new-key@1m")
+
+	require.Equal(t, 1, run(context.Background(), logger.NOP))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/bits"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencySubBucketBits is the number of bits of every power of two range that are tracked, i.e. every range is split
+	// in 32 buckets and the quantiles have a relative error of at most 1/32 (~3%)
+	latencySubBucketBits = 5
+	latencySubBuckets    = 1 << latencySubBucketBits
+	latencyBuckets       = 64 * latencySubBuckets
+)
+
+// latencyHistogram accumulates durations in log-linear buckets (HDR-style) so that the quantiles can be computed
+// with bounded memory no matter how long the run is. It is safe for concurrent use.
+type latencyHistogram struct {
+	counts [latencyBuckets]atomic.Int64
+	count  atomic.Int64
+	max    atomic.Int64
+}
+
+// Record adds a duration to the histogram, negative durations are recorded as zero
+func (h *latencyHistogram) Record(d time.Duration) {
+	v := max(int64(d), 0)
+	h.counts[latencyBucket(v)].Add(1)
+	h.count.Add(1)
+	for {
+		current := h.max.Load()
+		if v <= current || h.max.CompareAndSwap(current, v) {
+			return
+		}
+	}
+}
+
+// Quantile returns the duration below which the given fraction (0-1) of the recorded durations fall.
+// The result is the highest duration of its bucket, capped by the max recorded duration.
+func (h *latencyHistogram) Quantile(q float64) time.Duration {
+	count := h.count.Load()
+	if count == 0 {
+		return 0
+	}
+	rank := max(int64(math.Ceil(q*float64(count))), 1)
+	var cumulative int64
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		if cumulative >= rank {
+			return time.Duration(min(latencyBucketUpperBound(i), h.max.Load()))
+		}
+	}
+	return time.Duration(h.max.Load())
+}
+
+// Summary returns a snapshot of the percentiles of the recorded durations
+func (h *latencyHistogram) Summary(mode string) latencySummary {
+	return latencySummary{
+		Mode:  mode,
+		Count: h.count.Load(),
+		P50:   h.Quantile(0.5),
+		P90:   h.Quantile(0.9),
+		P99:   h.Quantile(0.99),
+		Max:   time.Duration(h.max.Load()),
+	}
+}
+
+// latencyBucket returns the bucket of v: values below 64 have their own bucket, the others are tracked with
+// latencySubBucketBits+1 significant bits
+func latencyBucket(v int64) int {
+	if v < 2*latencySubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - latencySubBucketBits - 1
+	return (shift+1)*latencySubBuckets + int(v>>shift) - latencySubBuckets
+}
+
+// latencyBucketUpperBound returns the highest value of the given bucket
+func latencyBucketUpperBound(bucket int) int64 {
+	if bucket < 2*latencySubBuckets {
+		return int64(bucket)
+	}
+	shift := bucket/latencySubBuckets - 1
+	lower := int64(bucket%latencySubBuckets+latencySubBuckets) << shift
+	return lower + (int64(1) << shift) - 1
+}
+
+type latencySummary struct {
+	Mode  string
+	Count int64
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// MarshalJSON encodes the durations as milliseconds
+func (s latencySummary) MarshalJSON() ([]byte, error) {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return json.Marshal(struct {
+		Mode  string  `json:"mode"`
+		Count int64   `json:"count"`
+		P50   float64 `json:"p50Ms"`
+		P90   float64 `json:"p90Ms"`
+		P99   float64 `json:"p99Ms"`
+		Max   float64 `json:"maxMs"`
+	}{
+		Mode:  s.Mode,
+		Count: s.Count,
+		P50:   ms(s.P50),
+		P90:   ms(s.P90),
+		P99:   ms(s.P99),
+		Max:   ms(s.Max),
+	})
+}
+
+// summaryHandler replies with the publish latency percentiles recorded so far
+func summaryHandler(h *latencyHistogram, mode string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(h.Summary(mode))
+	}
+}
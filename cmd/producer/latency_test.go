@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	requireWithin := func(t *testing.T, expected, actual time.Duration) {
+		t.Helper()
+		require.InDelta(t, float64(expected), float64(actual), float64(expected)/latencySubBuckets,
+			"expected %s, got %s", expected, actual)
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		var h latencyHistogram
+		require.Equal(t, latencySummary{Mode: "http"}, h.Summary("http"))
+	})
+
+	t.Run("uniform", func(t *testing.T) {
+		// 1ms, 2ms, ..., 1000ms
+		var h latencyHistogram
+		for _, i := range rand.Perm(1000) {
+			h.Record(time.Duration(i+1) * time.Millisecond)
+		}
+		s := h.Summary("http")
+		require.EqualValues(t, 1000, s.Count)
+		requireWithin(t, 500*time.Millisecond, s.P50)
+		requireWithin(t, 900*time.Millisecond, s.P90)
+		requireWithin(t, 990*time.Millisecond, s.P99)
+		require.Equal(t, time.Second, s.Max)
+		requireWithin(t, 250*time.Millisecond, h.Quantile(0.25))
+		require.Equal(t, time.Second, h.Quantile(1))
+	})
+
+	t.Run("long tail", func(t *testing.T) {
+		var h latencyHistogram
+		for i := 0; i < 985; i++ {
+			h.Record(10 * time.Millisecond)
+		}
+		for i := 0; i < 10; i++ {
+			h.Record(200 * time.Millisecond)
+		}
+		for i := 0; i < 5; i++ {
+			h.Record(5 * time.Second)
+		}
+		s := h.Summary("http")
+		requireWithin(t, 10*time.Millisecond, s.P50)
+		requireWithin(t, 10*time.Millisecond, s.P90)
+		requireWithin(t, 200*time.Millisecond, s.P99)
+		require.Equal(t, 5*time.Second, s.Max)
+	})
+
+	t.Run("small values are exact", func(t *testing.T) {
+		for v := int64(0); v < 2*latencySubBuckets; v++ {
+			require.Equal(t, v, latencyBucketUpperBound(latencyBucket(v)))
+		}
+	})
+
+	t.Run("buckets", func(t *testing.T) {
+		previous := -1
+		for _, v := range []int64{0, 63, 64, 65, 66, 127, 128, 1e6, 1e9, 3600e9, 1<<63 - 1} {
+			bucket := latencyBucket(v)
+			require.Less(t, bucket, latencyBuckets)
+			require.GreaterOrEqual(t, bucket, previous)
+			require.GreaterOrEqual(t, latencyBucketUpperBound(bucket), v)
+			previous = bucket
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var (
+			h  latencyHistogram
+			wg sync.WaitGroup
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					h.Record(time.Duration(j+1) * time.Microsecond)
+				}
+			}()
+		}
+		wg.Wait()
+		s := h.Summary("http")
+		require.EqualValues(t, 10000, s.Count)
+		require.Equal(t, time.Millisecond, s.Max)
+		requireWithin(t, 500*time.Microsecond, s.P50)
+	})
+}
+
+func TestSummaryHandler(t *testing.T) {
+	var h latencyHistogram
+	h.Record(10 * time.Millisecond)
+	h.Record(20 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	summaryHandler(&h, "http").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/summary", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var summary map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &summary))
+	require.Equal(t, "http", summary["mode"])
+	require.EqualValues(t, 2, summary["count"])
+	require.InDelta(t, 10, summary["p50Ms"], 0.5)
+	require.InDelta(t, 20, summary["maxMs"], 0.01)
+	require.InDelta(t, 20, summary["p99Ms"], 0.01)
+}
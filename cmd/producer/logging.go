@@ -0,0 +1,34 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rudderlabs/rudder-go-kit/logger"
+)
+
+// leakyLogger logs at most one warning per interval, the others are dropped.
+// It is meant for errors that can happen for every message (e.g. timeouts) and would otherwise flood the logs.
+type leakyLogger struct {
+	log      logger.Logger
+	interval time.Duration
+	now      func() time.Time
+	next     atomic.Int64 // unix nano after which the next warning can be logged
+}
+
+func newLeakyLogger(log logger.Logger, interval time.Duration) *leakyLogger {
+	return &leakyLogger{
+		log:      log,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+func (l *leakyLogger) Warnn(msg string, fields ...logger.Field) {
+	now := l.now().UnixNano()
+	next := l.next.Load()
+	if now < next || !l.next.CompareAndSwap(next, now+l.interval.Nanoseconds()) {
+		return
+	}
+	l.log.Warnn(msg, fields...)
+}
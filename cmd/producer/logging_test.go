@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rudderlabs/rudder-go-kit/logger"
+	"github.com/stretchr/testify/require"
+)
+
+// testLogger records the messages logged with the non-sugared methods
+type testLogger struct {
+	logger.Logger
+
+	mu     sync.Mutex
+	errors []string
+	warns  []string
+}
+
+func newTestLogger() *testLogger                            { return &testLogger{Logger: logger.NOP} }
+func (l *testLogger) Withn(_ ...logger.Field) logger.Logger { return l }
+func (l *testLogger) Child(_ string) logger.Logger          { return l }
+
+func (l *testLogger) Errorn(msg string, _ ...logger.Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, msg)
+}
+
+func (l *testLogger) Warnn(msg string, _ ...logger.Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warns = append(l.warns, msg)
+}
+
+func TestRunErrorsAreLogged(t *testing.T) {
+	setBaseEnv(t)
+	t.Setenv("CONCURRENCY", "0")
+
+	t.Run("invalid concurrency", func(t *testing.T) {
+		log := newTestLogger()
+		require.Equal(t, 1, run(context.Background(), log))
+		require.Equal(t, []string{"Concurrency has to be greater than zero"}, log.errors)
+	})
+
+	t.Run("invalid hostname", func(t *testing.T) {
+		t.Setenv("HOSTNAME", "some-host")
+		log := newTestLogger()
+		require.Equal(t, 1, run(context.Background(), log))
+		require.Equal(t, []string{"Hostname should start with " + hostnameSep}, log.errors)
+	})
+}
+
+func TestLeakyLogger(t *testing.T) {
+	log := newTestLogger()
+	now := time.Now()
+	l := newLeakyLogger(log, time.Second)
+	l.now = func() time.Time { return now }
+
+	l.Warnn("first")
+	l.Warnn("dropped")
+	now = now.Add(time.Second)
+	l.Warnn("second")
+	now = now.Add(500 * time.Millisecond)
+	l.Warnn("dropped")
+
+	require.Equal(t, []string{"first", "second"}, log.warns)
+}
@@ -11,20 +11,25 @@ import (
 	"regexp"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 
 	"rudder-load/internal/producer"
 	"rudder-load/internal/stats"
 
+	"github.com/rudderlabs/rudder-go-kit/config"
+	"github.com/rudderlabs/rudder-go-kit/logger"
 	"github.com/rudderlabs/rudder-go-kit/profiler"
 	kitsync "github.com/rudderlabs/rudder-go-kit/sync"
 	"github.com/rudderlabs/rudder-go-kit/throttling"
@@ -35,12 +40,17 @@ import (
 const (
 	modeStdout = "stdout"
 	modeHTTP   = "http"
+	modeFile   = "file"
 
 	hostnameSep = "rudder-load-"
 
 	templatesExtension = ".json.tmpl"
+	// templatesPartialPrefix marks the templates that can be included by the event templates, they are not event types
+	templatesPartialPrefix = "_"
 
 	metricsPrefix = "rudder_load_"
+
+	otlpShutdownTimeout = 10 * time.Second
 )
 
 type publisher interface {
@@ -57,65 +67,182 @@ type publisherCloser interface {
 }
 
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
-	os.Exit(run(ctx))
+
+	loggerFactory := logger.NewFactory(config.New())
+	exitCode := run(ctx, loggerFactory.NewLogger().Child("producer"))
+	loggerFactory.Sync()
+	os.Exit(exitCode)
 }
 
-func run(ctx context.Context) int {
+func run(ctx context.Context, log logger.Logger) int {
 	var (
-		hostname              = mustString("HOSTNAME")
-		mode                  = mustString("MODE")
-		loadRunID             = optionalString("LOAD_RUN_ID", uuid.New().String())
-		concurrency           = mustInt("CONCURRENCY")
-		messageGenerators     = mustInt("MESSAGE_GENERATORS")
-		useOneClientPerSlot   = optionalBool("USE_ONE_CLIENT_PER_SLOT", false)
-		enableSoftMemoryLimit = optionalBool("ENABLE_SOFT_MEMORY_LIMIT", false)
-		softMemoryLimit       = mustBytes("SOFT_MEMORY_LIMIT")
-		totalUsers            = mustInt("TOTAL_USERS")
-		hotUserGroups         = mustMap("HOT_USER_GROUPS")
-		eventTypes            = mustString("EVENT_TYPES")
-		hotEventTypes         = mustMap("HOT_EVENT_TYPES")
-		batchSizes            = mustMap("BATCH_SIZES")
-		hotBatchSizes         = mustMap("HOT_BATCH_SIZES")
-		maxEventsPerSecond    = mustInt("MAX_EVENTS_PER_SECOND")
-		templatesPath         = optionalString("TEMPLATES_PATH", "./templates/")
+		hostname               = mustString("HOSTNAME")
+		mode                   = mustString("MODE")
+		loadRunID              = optionalString("LOAD_RUN_ID", uuid.New().String())
+		concurrency            = optionalInt("CONCURRENCY", 0)        // derived from GOMAXPROCS if not set
+		messageGenerators      = optionalInt("MESSAGE_GENERATORS", 0) // derived from GOMAXPROCS if not set
+		useOneClientPerSlot    = optionalBool("USE_ONE_CLIENT_PER_SLOT", false)
+		enableSoftMemoryLimit  = optionalBool("ENABLE_SOFT_MEMORY_LIMIT", false)
+		softMemoryLimit        = mustBytes("SOFT_MEMORY_LIMIT")
+		totalUsers             = mustInt("TOTAL_USERS")
+		hotUserGroups          = mustMap("HOT_USER_GROUPS")
+		eventTypes             = mustString("EVENT_TYPES")
+		hotEventTypes          = mustMap("HOT_EVENT_TYPES")
+		batchSizes             = mustMap("BATCH_SIZES")
+		hotBatchSizes          = mustMap("HOT_BATCH_SIZES")
+		maxEventsPerSecond     = mustInt("MAX_EVENTS_PER_SECOND")
+		templatesPath          = optionalString("TEMPLATES_PATH", "./templates/")
+		xffSimulation          = optionalBool("XFF_SIMULATION", false)
+		xffPoolSize            = optionalInt("XFF_POOL_SIZE", 10000)
+		xffCIDRs               = optionalString("XFF_CIDRS", "")
+		xffSticky              = optionalBool("XFF_STICKY", false)
+		spoofClientIP          = optionalBool("SPOOF_CLIENT_IP", false)
+		clientIPPoolsValue     = optionalString("CLIENT_IP_POOLS", "")
+		httpQueryParams        = optionalString("HTTP_QUERY_PARAMS", "")
+		validateSourcesOnStart = optionalBool("VALIDATE_SOURCES_ON_START", false)
+		dropInvalidSources     = optionalBool("DROP_INVALID_SOURCES", false)
+		validateSourcesTimeout = optionalDuration("VALIDATE_SOURCES_TIMEOUT", 5*time.Second)
+		pushgatewayURL         = optionalString("PUSHGATEWAY_URL", "")
+		pushgatewayTimeout     = optionalDuration("PUSHGATEWAY_TIMEOUT", 10*time.Second)
+		eventsPerSecondRamp    = optionalString("EVENTS_PER_SECOND_RAMP", "")
+		trafficPatternValue    = optionalString("TRAFFIC_PATTERN", trafficPatternSteady)
+		burstShape             = optionalString("BURST_SHAPE", burstShapeSine)
+		burstFactor            = optionalFloat("BURST_FACTOR", 2)
+		burstPeriod            = optionalDuration("BURST_PERIOD", time.Minute)
+		reloadConfigFile       = optionalString("RELOAD_CONFIG_FILE", "")
+		adaptiveLoadEnabled    = optionalBool("ADAPTIVE_LOAD", false)
+		adaptiveTargetError    = optionalString("ADAPTIVE_TARGET_ERROR_RATE", "0.5%")
+		adaptiveStep           = optionalInt("ADAPTIVE_STEP", 500)
+		adaptiveInterval       = optionalDuration("ADAPTIVE_INTERVAL", 30*time.Second)
+		adaptiveMin            = optionalInt("ADAPTIVE_MIN_EVENTS_PER_SECOND", 0)
+		shutdownDrainTimeout   = optionalDuration("SHUTDOWN_DRAIN_TIMEOUT", 0)
+		validatePayloadsMode   = optionalString("VALIDATE_PAYLOADS", validatePayloadsOff)
+		schemasPath            = optionalString("SCHEMAS_PATH", "")
+		sourcesFile            = optionalString("SOURCES_FILE", "")
+		maxDataValue           = optionalString("MAX_DATA", "")
+		eventsPerSecondSources = optionalString("MAX_EVENTS_PER_SECOND_PER_SOURCE", "")
+		identityModeValue      = optionalString("IDENTITY_MODE", identityModeSimple)
+		anonymousPercentage    = optionalInt("IDENTITY_ANONYMOUS_PERCENTAGE", 50)
+		prewarmConnections     = optionalBool("HTTP_PREWARM_CONNECTIONS", false)
+		prewarmDuration        = optionalDuration("HTTP_PREWARM_DURATION", 10*time.Second)
+		timestampSkewValue     = optionalString("TIMESTAMP_SKEW", "")
+		totalEvents            = optionalInt("TOTAL_EVENTS", 0)
+		otlpEndpoint           = optionalString("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+		enableTracing          = optionalBool("ENABLE_TRACING", false)
+		validatorType          = optionalString("VALIDATOR_TYPE", "")
+		eventSizeValue         = optionalString("EVENT_SIZE_BYTES", "")
+		sourceAssignmentValue  = optionalString("SOURCE_ASSIGNMENT", sourceAssignmentStrict)
+		assignmentModeValue    = optionalString("SOURCE_ASSIGNMENT_MODE", sourceAssignmentModeReplica)
+		keyRotationValue       = optionalString("KEY_ROTATION", "")
+		publishTimeout         = optionalDuration("PUBLISH_TIMEOUT", 0)
+		slowRequestThreshold   = optionalDuration("SLOW_REQUEST_THRESHOLD", 0)
+		retryAfterMax          = optionalDuration("RETRY_AFTER_MAX", 30*time.Second)
+		sourceHeadersValue     = optionalString("SOURCE_HEADERS", "")
+		sessionMode            = optionalBool("SESSION_MODE", false)
+		sessionScriptValue     = optionalString("SESSION_SCRIPT", "identify,page,track*5")
+		sessionEmitValue       = optionalString("SESSION_EMIT", sessionEmitBatch)
+		failedPayloadDir       = optionalString("FAILED_PAYLOAD_DIR", "")
+		failedPayloadMaxMB     = optionalInt("FAILED_PAYLOAD_MAX_MB", 100)
 	)
 
 	sourcesList := strings.Split(os.Getenv("SOURCES"), ",")
 	if len(sourcesList) < 1 {
-		printErr(fmt.Errorf("invalid number of sources [<1]: %d", len(sourcesList)))
+		log.Errorn("Invalid number of sources [<1]", logger.NewIntField("sources", int64(len(sourcesList))))
 		return 1
 	}
 
 	if strings.Index(hostname, hostnameSep) != 0 {
-		printErr(fmt.Errorf("hostname should start with %s", hostnameSep))
+		log.Errorn("Hostname should start with "+hostnameSep, logger.NewStringField("hostname", hostname))
 		return 1
 	}
 
 	re := regexp.MustCompile(`rudder-load-([a-z]+)-(\d+)`)
 	match := re.FindStringSubmatch(hostname)
 	if len(match) <= 2 {
-		printErr(fmt.Errorf("hostname is invalid: %s", hostname))
+		log.Errorn("Hostname is invalid", logger.NewStringField("hostname", hostname))
 		return 1
 	}
 
 	deploymentName := match[1]
 	if deploymentName == "" {
-		printErr(fmt.Errorf("deployment name is empty"))
+		log.Errorn("Deployment name is empty", logger.NewStringField("hostname", hostname))
 		return 1
 	}
 	instanceNumber, err := strconv.Atoi(match[2])
 	if err != nil {
-		printErr(fmt.Errorf("error getting instance number from hostname: %v", err))
+		log.Errorn("Error getting instance number from hostname", logger.NewStringField("hostname", hostname), logger.NewErrorField(err))
+		return 1
+	}
+
+	var (
+		sourcesConcentration []sourceConfig
+		sourcesWriteKeys     = sourcesList
+	)
+	if sourcesFile != "" {
+		sources, err := readSourcesFile(sourcesFile)
+		if err != nil {
+			log.Errorn("Error reading sources file", logger.NewStringField("sourcesFile", sourcesFile), logger.NewErrorField(err))
+			return 1
+		}
+		if validateSourcesOnStart {
+			log.Errorn("VALIDATE_SOURCES_ON_START is not supported together with SOURCES_FILE")
+			return 1
+		}
+		sourcesConcentration = getSourcesConcentration(sources)
+		sourcesWriteKeys = make([]string, 0, len(sources))
+		for _, source := range sources {
+			sourcesWriteKeys = append(sourcesWriteKeys, source.WriteKey)
+		}
+	}
+	log = log.Withn(
+		logger.NewStringField("mode", mode),
+		logger.NewStringField("deployment", deploymentName),
+		logger.NewStringField("loadRunID", loadRunID),
+	)
+
+	sourceAssignment, err := parseSourceAssignment(sourceAssignmentValue)
+	if err != nil {
+		log.Errorn("Invalid SOURCE_ASSIGNMENT", logger.NewErrorField(err))
 		return 1
 	}
-	if len(sourcesList) < (instanceNumber + 1) {
-		printErr(fmt.Errorf("instance number %d is greater than the number of sources %d", instanceNumber, len(sourcesList)))
+	sourceAssignmentMode, err := parseSourceAssignmentMode(assignmentModeValue)
+	if err != nil {
+		log.Errorn("Invalid SOURCE_ASSIGNMENT_MODE", logger.NewErrorField(err))
 		return 1
 	}
+	writeKey := sourcesFileWriteKey
+	pinnedSources := sourcesList // see SOURCE_ASSIGNMENT_MODE=pinned
+	switch {
+	case sourceAssignmentMode == sourceAssignmentModePinned:
+		if sourcesConcentration != nil {
+			log.Errorn("SOURCE_ASSIGNMENT_MODE=pinned is not supported together with SOURCES_FILE: " +
+				"the slots stick to a source so the source weights cannot be honored")
+			return 1
+		}
+		writeKey = pinnedWriteKey
+	case sourcesConcentration == nil:
+		writeKey, err = assignSource(sourcesList, instanceNumber, sourceAssignment)
+		if err != nil {
+			log.Errorn("Cannot assign a source to the instance",
+				logger.NewIntField("instanceNumber", int64(instanceNumber)),
+				logger.NewIntField("sources", int64(len(sourcesList))),
+				logger.NewErrorField(err),
+			)
+			return 1
+		}
+	}
+
+	// the producer would otherwise schedule its goroutines on all the CPUs of the node
+	cpuQuota, err := readCPUQuota(cgroupRoot)
+	if err != nil {
+		log.Warnn("Cannot read the CPU quota, GOMAXPROCS is left as it is", logger.NewErrorField(err))
+	}
+	maxProcs := setMaxProcs(cpuQuota, os.Getenv("GOMAXPROCS"), runtime.GOMAXPROCS)
+	concurrency, messageGenerators = workerPoolSize(concurrency, messageGenerators, maxProcs)
 	if concurrency < 1 {
-		printErr(fmt.Errorf("concurrency has to be greater than zero: %d", concurrency))
+		log.Errorn("Concurrency has to be greater than zero", logger.NewIntField("concurrency", int64(concurrency)))
 		return 1
 	}
 
@@ -127,16 +254,19 @@ func run(ctx context.Context) int {
 	}
 
 	if len(eventTypes) == 0 {
-		printErr(fmt.Errorf("event types cannot be empty"))
+		log.Errorn("Event types cannot be empty")
 		return 1
 	}
 	parsedEventTypes, err := parseEventTypes(eventTypes)
 	if err != nil {
-		printErr(fmt.Errorf("error parsing event types: %v", err))
+		log.Errorn("Error parsing event types", logger.NewErrorField(err))
 		return 1
 	}
 	if len(parsedEventTypes) != len(hotEventTypes) {
-		printErr(fmt.Errorf("event types and hot event types should have the same length: %+v - %+v", parsedEventTypes, hotEventTypes))
+		log.Errorn("Event types and hot event types should have the same length",
+			logger.NewStringField("eventTypes", fmt.Sprintf("%+v", parsedEventTypes)),
+			logger.NewStringField("hotEventTypes", fmt.Sprintf("%+v", hotEventTypes)),
+		)
 		return 1
 	}
 	hotEventTypesPercentage := 0
@@ -144,15 +274,18 @@ func run(ctx context.Context) int {
 		hotEventTypesPercentage += v
 	}
 	if hotEventTypesPercentage != 100 {
-		printErr(fmt.Errorf("hot event types should sum to 100"))
+		log.Errorn("Hot event types should sum to 100")
 		return 1
 	}
 	if len(batchSizes) != len(hotBatchSizes) {
-		printErr(fmt.Errorf("batch sizes and hot batch sizes should have the same length: %+v - %+v", batchSizes, hotBatchSizes))
+		log.Errorn("Batch sizes and hot batch sizes should have the same length",
+			logger.NewStringField("batchSizes", fmt.Sprintf("%+v", batchSizes)),
+			logger.NewStringField("hotBatchSizes", fmt.Sprintf("%+v", hotBatchSizes)),
+		)
 		return 1
 	}
 	if len(hotUserGroups) < 1 {
-		printErr(fmt.Errorf("hot user groups should have at least one element"))
+		log.Errorn("Hot user groups should have at least one element")
 		return 1
 	}
 	hotUserGroupsPercentage := 0
@@ -160,39 +293,340 @@ func run(ctx context.Context) int {
 		hotUserGroupsPercentage += v
 	}
 	if hotUserGroupsPercentage != 100 {
-		printErr(fmt.Errorf("hot user groups should sum to 100"))
+		log.Errorn("Hot user groups should sum to 100")
 		return 1
 	}
 	if totalUsers&len(hotUserGroups) != 0 {
-		printErr(fmt.Errorf("total users should be a multiple of the number of hot user groups"))
+		log.Errorn("Total users should be a multiple of the number of hot user groups")
 		return 1
 	}
 	if messageGenerators < 1 {
-		printErr(fmt.Errorf("message generators has to be greater than zero: %d", messageGenerators))
+		log.Errorn("Message generators has to be greater than zero", logger.NewIntField("messageGenerators", int64(messageGenerators)))
+		return 1
+	}
+
+	identityMode, err := parseIdentityMode(identityModeValue)
+	if err != nil {
+		log.Errorn("Invalid IDENTITY_MODE", logger.NewErrorField(err))
+		return 1
+	}
+	if anonymousPercentage < 0 || anonymousPercentage > 100 {
+		log.Errorn("Identity anonymous percentage should be between 0 and 100",
+			logger.NewIntField("anonymousPercentage", int64(anonymousPercentage)),
+		)
+		return 1
+	}
+	for _, et := range parsedEventTypes {
+		isAlias := et.Type == "alias"
+		for _, m := range et.Members {
+			isAlias = isAlias || m.Type == "alias"
+		}
+		if isAlias && identityMode != identityModeAlias {
+			log.Errorn("Alias events require IDENTITY_MODE=" + identityModeAlias)
+			return 1
+		}
+	}
+
+	var (
+		sessionScript []sessionStep
+		sessionEmit   string
+	)
+	if sessionMode {
+		sessionScript, err = parseSessionScript(sessionScriptValue)
+		if err != nil {
+			log.Errorn("Invalid SESSION_SCRIPT", logger.NewErrorField(err))
+			return 1
+		}
+		for _, step := range sessionScript {
+			if step.EventType == "alias" && identityMode != identityModeAlias {
+				log.Errorn("Alias events require IDENTITY_MODE=" + identityModeAlias)
+				return 1
+			}
+		}
+		sessionEmit, err = parseSessionEmit(sessionEmitValue)
+		if err != nil {
+			log.Errorn("Invalid SESSION_EMIT", logger.NewErrorField(err))
+			return 1
+		}
+	}
+
+	if totalEvents < 0 {
+		log.Errorn("Total events cannot be negative", logger.NewIntField("totalEvents", int64(totalEvents)))
+		return 1
+	}
+
+	skew, err := parseTimestampSkew(timestampSkewValue)
+	if err != nil {
+		log.Errorn("Invalid TIMESTAMP_SKEW", logger.NewErrorField(err))
+		return 1
+	}
+
+	var keyRotations []keyRotation
+	if keyRotationValue != "" {
+		keyRotations, err = parseKeyRotations(keyRotationValue)
+		if err != nil {
+			log.Errorn("Invalid KEY_ROTATION", logger.NewErrorField(err))
+			return 1
+		}
+		known := make(map[string]bool, len(sourcesWriteKeys))
+		for _, writeKey := range sourcesWriteKeys {
+			known[writeKey] = true
+		}
+		for _, rotation := range keyRotations { // a key can be rotated again once it replaced another one
+			if !known[rotation.OldKey] {
+				log.Errorn("Invalid KEY_ROTATION: the key to rotate is not a source", logger.NewStringField("oldKey", rotation.OldKey))
+				return 1
+			}
+			known[rotation.NewKey] = true
+		}
+	}
+
+	writeKeys := slices.Clone(sourcesWriteKeys)
+	for _, rotation := range keyRotations {
+		writeKeys = append(writeKeys, rotation.NewKey)
+	}
+	var sourceHeaders map[string]map[string]string
+	if sourceHeadersValue != "" {
+		sourceHeaders, err = parseSourceHeaders(sourceHeadersValue, writeKeys)
+		if err != nil {
+			log.Errorn("Invalid SOURCE_HEADERS", logger.NewErrorField(err))
+			return 1
+		}
+	}
+	if mode == modeHTTP {
+		if err := producer.ValidateSignatureSecrets(os.Environ(), writeKeys); err != nil {
+			log.Errorn("Invalid HTTP_SIGNATURE_SECRET", logger.NewErrorField(err))
+			return 1
+		}
+	}
+
+	var padder *eventPadder
+	if eventSizeValue != "" {
+		sizes, err := parseEventSizes(eventSizeValue)
+		if err != nil {
+			log.Errorn("Invalid EVENT_SIZE_BYTES", logger.NewErrorField(err))
+			return 1
+		}
+		padder = newEventPadder(sizes)
+	}
+
+	validatePayloads, err := parseValidatePayloads(validatePayloadsMode)
+	if err != nil {
+		log.Errorn("Invalid VALIDATE_PAYLOADS", logger.NewErrorField(err))
+		return 1
+	}
+	var schemas map[string]*payloadSchema // the payloads are only checked to be valid JSON if there is no schema
+	if validatePayloads != validatePayloadsOff && schemasPath != "" {
+		schemas, err = loadSchemas(schemasPath)
+		if err != nil {
+			log.Errorn("Cannot load payload schemas", logger.NewStringField("schemasPath", schemasPath), logger.NewErrorField(err))
+			return 1
+		}
+	}
+
+	var maxData int
+	if maxDataValue != "" {
+		maxData, err = convertToBytes(maxDataValue)
+		if err != nil {
+			log.Errorn("Invalid MAX_DATA", logger.NewStringField("maxData", maxDataValue), logger.NewErrorField(err))
+			return 1
+		}
+		if maxData < 1 {
+			log.Errorn("MAX_DATA has to be greater than zero", logger.NewStringField("maxData", maxDataValue))
+			return 1
+		}
+	}
+
+	var ramp []rampSegment
+	if eventsPerSecondRamp != "" {
+		ramp, err = parseRamp(eventsPerSecondRamp)
+		if err != nil {
+			log.Errorn("Error parsing events per second ramp", logger.NewErrorField(err))
+			return 1
+		}
+		if len(ramp) == 1 { // a single segment is just a constant rate
+			maxEventsPerSecond = int(ramp[0].EventsPerSecond)
+			ramp = nil
+		}
+	}
+
+	trafficPattern, err := parseTrafficPattern(trafficPatternValue)
+	if err != nil {
+		log.Errorn("Invalid TRAFFIC_PATTERN", logger.NewErrorField(err))
 		return 1
 	}
+	var burst *burstModulation
+	if trafficPattern == trafficPatternBursty {
+		if maxEventsPerSecond <= 0 && ramp == nil {
+			log.Errorn("TRAFFIC_PATTERN=bursty requires MAX_EVENTS_PER_SECOND or EVENTS_PER_SECOND_RAMP")
+			return 1
+		}
+		if eventsPerSecondSources != "" {
+			log.Errorn("TRAFFIC_PATTERN=bursty is not supported together with MAX_EVENTS_PER_SECOND_PER_SOURCE")
+			return 1
+		}
+		burst, err = newBurstModulation(burstShape, burstFactor, burstPeriod)
+		if err != nil {
+			log.Errorn("Invalid burst settings", logger.NewErrorField(err))
+			return 1
+		}
+	}
+
+	var adaptive *adaptiveLoad
+	if adaptiveLoadEnabled {
+		if maxEventsPerSecond <= 0 {
+			log.Errorn("ADAPTIVE_LOAD requires MAX_EVENTS_PER_SECOND as upper bound")
+			return 1
+		}
+		if ramp != nil || burst != nil || eventsPerSecondSources != "" {
+			log.Errorn("ADAPTIVE_LOAD is not supported together with EVENTS_PER_SECOND_RAMP, " +
+				"TRAFFIC_PATTERN=bursty or MAX_EVENTS_PER_SECOND_PER_SOURCE")
+			return 1
+		}
+		targetErrorRate, err := parseErrorRate(adaptiveTargetError)
+		if err != nil {
+			log.Errorn("Invalid ADAPTIVE_TARGET_ERROR_RATE", logger.NewErrorField(err))
+			return 1
+		}
+		if adaptiveMin <= 0 { // starts from one step by default
+			adaptiveMin = adaptiveStep
+		}
+		adaptive, err = newAdaptiveLoad(
+			targetErrorRate, float64(adaptiveStep), float64(adaptiveMin), float64(maxEventsPerSecond),
+		)
+		if err != nil {
+			log.Errorn("Invalid adaptive load settings", logger.NewErrorField(err))
+			return 1
+		}
+		if adaptiveInterval <= 0 {
+			log.Errorn("ADAPTIVE_INTERVAL has to be greater than zero")
+			return 1
+		}
+	}
 
 	// Creating throttler
 	throttler, err := throttling.New(throttling.WithInMemoryGCRA(int64(maxEventsPerSecond)))
 	if err != nil {
-		printErr(fmt.Errorf("cannot create throttler: %v", err))
+		log.Errorn("Cannot create throttler", logger.NewErrorField(err))
 		return 1
 	}
 
-	writeKey := sourcesList[instanceNumber]
+	var perSourceThrottlers *sourceThrottlers
+	if eventsPerSecondSources != "" {
+		if ramp != nil {
+			log.Errorn("MAX_EVENTS_PER_SECOND_PER_SOURCE is not supported together with EVENTS_PER_SECOND_RAMP")
+			return 1
+		}
+		limits, err := parseEventsPerSecondPerSource(eventsPerSecondSources, sourcesWriteKeys)
+		if err != nil {
+			log.Errorn("Error parsing events per second per source", logger.NewErrorField(err))
+			return 1
+		}
+		perSourceThrottlers, err = newSourceThrottlers(limits)
+		if err != nil {
+			log.Errorn("Cannot create per source throttlers", logger.NewErrorField(err))
+			return 1
+		}
+	}
+
+	var xffIPs *xffPool
+	if xffSimulation {
+		xffIPs, err = newXFFPool(xffPoolSize, strings.Split(xffCIDRs, ","), xffSticky)
+		if err != nil {
+			log.Errorn("Cannot create X-Forwarded-For pool", logger.NewErrorField(err))
+			return 1
+		}
+	}
+
+	var userIPs *clientIPs
+	if spoofClientIP {
+		if xffSimulation {
+			log.Errorn("SPOOF_CLIENT_IP and XFF_SIMULATION cannot be enabled at the same time")
+			return 1
+		}
+		pools, err := parseClientIPPools(clientIPPoolsValue)
+		if err != nil {
+			log.Errorn("Invalid CLIENT_IP_POOLS", logger.NewErrorField(err))
+			return 1
+		}
+		userIPs = newClientIPs(pools)
+	}
+
+	var queryParamsConcentration []string
+	if httpQueryParams != "" {
+		variants, err := parseQueryParamsVariants(httpQueryParams)
+		if err != nil {
+			log.Errorn("Error parsing query params", logger.NewErrorField(err))
+			return 1
+		}
+		queryParamsConcentration = getQueryParamsConcentration(variants)
+	}
 
-	fmt.Printf("Hostname: %s\n", hostname)
-	fmt.Printf("CPUs: %d\n", runtime.GOMAXPROCS(-1))
-	fmt.Printf("Mode: %s\n", mode)
-	fmt.Printf("Concurrency: %d\n", concurrency)
-	fmt.Printf("Message generators: %d\n", messageGenerators)
-	fmt.Printf("Use one client per slot: %v\n", useOneClientPerSlot)
-	fmt.Printf("Instance number: %d\n", instanceNumber)
-	fmt.Printf("WriteKey handled by this replica: %s\n", writeKey)
-	fmt.Printf("Total users: %d\n", totalUsers)
+	if validateSourcesOnStart && mode == modeHTTP {
+		log.Infon("Validating sources...")
+		p, err := producer.NewHTTPProducer(os.Environ())
+		if err != nil {
+			log.Errorn("Cannot create publisher to validate sources", logger.NewErrorField(err))
+			return 1
+		}
+		if sourceAssignmentMode == sourceAssignmentModePinned {
+			pinnedSources, err = preflightValidSources(log, p, sourcesList, dropInvalidSources, validateSourcesTimeout)
+		} else {
+			writeKey, err = preflightSources(log, p, sourcesList, instanceNumber, dropInvalidSources, validateSourcesTimeout)
+		}
+		_ = p.Close()
+		if err != nil {
+			log.Errorn("Error validating sources", logger.NewErrorField(err))
+			return 1
+		}
+	}
+	startupFields := []logger.Field{
+		logger.NewStringField("hostname", hostname),
+		logger.NewIntField("cpus", int64(maxProcs)),
+		logger.NewFloatField("cpuQuota", cpuQuota),
+		logger.NewIntField("concurrency", int64(concurrency)),
+		logger.NewIntField("messageGenerators", int64(messageGenerators)),
+		logger.NewBoolField("useOneClientPerSlot", useOneClientPerSlot),
+		logger.NewIntField("instanceNumber", int64(instanceNumber)),
+		logger.NewIntField("totalUsers", int64(totalUsers)),
+		logger.NewStringField("identityMode", identityMode),
+		logger.NewStringField("timestampSkew", timestampSkewValue),
+	}
+	if totalEvents > 0 {
+		startupFields = append(startupFields, logger.NewIntField("totalEvents", int64(totalEvents)))
+	}
+	if sourcesConcentration != nil {
+		startupFields = append(startupFields, logger.NewStringField("sourcesFile", sourcesFile))
+	} else {
+		startupFields = append(startupFields,
+			logger.NewStringField("writeKey", writeKey),
+			logger.NewStringField("sourceAssignment", sourceAssignment),
+			logger.NewStringField("sourceAssignmentMode", sourceAssignmentMode),
+		)
+		if sourceAssignmentMode == sourceAssignmentModePinned {
+			startupFields = append(startupFields, logger.NewStringField("pinnedSources", strings.Join(pinnedSources, ",")))
+		}
+	}
+	if xffSimulation {
+		startupFields = append(startupFields,
+			logger.NewIntField("xffPoolSize", int64(xffPoolSize)),
+			logger.NewBoolField("xffSticky", xffSticky),
+		)
+	}
+	if spoofClientIP {
+		startupFields = append(startupFields, logger.NewStringField("clientIPPools", clientIPPoolsValue))
+	}
+	if maxData > 0 {
+		startupFields = append(startupFields, logger.NewStringField("dataBudget", byteCount(uint64(maxData))))
+	}
 	if enableSoftMemoryLimit {
-		fmt.Printf("Soft memory limit at 80%% of %s: %s\n", byteCount(uint64(softMemoryLimit)), byteCount(uint64(newMemoryLimit)))
+		// the soft memory limit is set at 80% of SOFT_MEMORY_LIMIT
+		startupFields = append(startupFields,
+			logger.NewStringField("softMemoryLimit", byteCount(uint64(softMemoryLimit))),
+			logger.NewStringField("effectiveSoftMemoryLimit", byteCount(uint64(newMemoryLimit))),
+		)
 	}
+	log.Infon("Starting producer", startupFields...)
 
 	// PROMETHEUS REGISTRY - START
 	reg := prometheus.NewRegistry()
@@ -215,31 +649,266 @@ func run(ctx context.Context) int {
 	})
 	msgGenLag := prometheus.NewCounter(prometheus.CounterOpts{
 		Name:        metricsPrefix + "msg_generation_lag",
-		Help:        "If less than a ms then this is increased meaning there are not enough generators per publishers.",
+		Help:        "Number of generated messages that waited more than a ms for a publisher (i.e. publishers are the bottleneck)",
+		ConstLabels: constLabels,
+	})
+	generatorBlockedSeconds := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        metricsPrefix + "generator_blocked_seconds_total",
+		Help:        "Time spent by the generators waiting for a publisher to take the generated messages",
+		ConstLabels: constLabels,
+	})
+	messagesChannelDepth := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        metricsPrefix + "messages_channel_depth",
+		Help:        "Number of generated messages waiting for a publisher, sampled every second",
 		ConstLabels: constLabels,
 	})
-	throttled := prometheus.NewCounter(prometheus.CounterOpts{
+	throttled := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name:        metricsPrefix + "throttled",
-		Help:        "Number of times we get throttled",
+		Help:        "Number of times we get throttled, by the client limits or by the server (i.e. retried 429 responses)",
+		ConstLabels: constLabels,
+	}, []string{"by", "source"}) // source is the write key, set only with MAX_EVENTS_PER_SECOND_PER_SOURCE
+	xffBuckets := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        metricsPrefix + "xff_bucket_total",
+		Help:        "Number of requests per X-Forwarded-For /8 bucket",
+		ConstLabels: constLabels,
+	}, []string{"bucket"})
+	maxBatchSize := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        metricsPrefix + "max_batch_size",
+		Help:        "Max allowed batch size per event type, reduced when the server replies with 413",
+		ConstLabels: constLabels,
+	}, []string{"event_type"})
+	targetEventsPerSecond := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        metricsPrefix + "target_events_per_second",
+		Help:        "Current target of events per second (0 means no limit)",
+		ConstLabels: constLabels,
+	})
+	retries := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        metricsPrefix + "retries_count",
+		Help:        "Number of retried requests per status code (\"timeout\" for the timed out ones)",
+		ConstLabels: constLabels,
+	}, []string{"status_code"})
+	publishedMessagesBySource := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        metricsPrefix + "published_messages_by_source_count",
+		Help:        "Number of published messages per write key",
+		ConstLabels: constLabels,
+	}, []string{"source"})
+	publishedMessagesByType := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        metricsPrefix + "published_messages_by_type_count",
+		Help:        "Number of published messages per event type",
+		ConstLabels: constLabels,
+	}, []string{"event_type"})
+	errorsByType := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        metricsPrefix + "errors_by_type_count",
+		Help:        "Number of publish errors per event type",
+		ConstLabels: constLabels,
+	}, []string{"event_type"})
+	invalidPayloads := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        metricsPrefix + "invalid_payloads_count",
+		Help:        "Number of generated payloads that are not valid JSON",
+		ConstLabels: constLabels,
+	})
+	batchSizeHistogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        metricsPrefix + "batch_size",
+		Help:        "Number of events per published message",
+		Buckets:     []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+		ConstLabels: constLabels,
+	})
+	keyRotationsTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        metricsPrefix + "key_rotations_total",
+		Help:        "Number of write key rotations fired (see KEY_ROTATION)",
+		ConstLabels: constLabels,
+	})
+	slowRequests := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        metricsPrefix + "slow_requests_count",
+		Help:        "Number of publish requests that took longer than SLOW_REQUEST_THRESHOLD",
+		ConstLabels: constLabels,
+	})
+	responseValidationErrors := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        metricsPrefix + "response_validation_errors_count",
+		Help:        "Number of 200 OK responses whose body was rejected by VALIDATOR_TYPE",
+		ConstLabels: constLabels,
+	})
+	failedPayloadsCaptured := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        metricsPrefix + "failed_payloads_captured_count",
+		Help:        "Number of payloads of non-retryable publish errors written to FAILED_PAYLOAD_DIR",
+		ConstLabels: constLabels,
+	})
+	dataBudgetRemaining := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        metricsPrefix + "data_budget_remaining_bytes",
+		Help:        "Bytes that can still be sent before reaching MAX_DATA (0 if there is no budget)",
 		ConstLabels: constLabels,
 	})
 	reg.MustRegister(publishRatePerSecond)
 	reg.MustRegister(msgGenLag)
+	reg.MustRegister(generatorBlockedSeconds)
+	reg.MustRegister(messagesChannelDepth)
 	reg.MustRegister(throttled)
+	reg.MustRegister(xffBuckets)
+	reg.MustRegister(maxBatchSize)
+	reg.MustRegister(targetEventsPerSecond)
+	reg.MustRegister(retries)
+	reg.MustRegister(publishedMessagesByType)
+	reg.MustRegister(publishedMessagesBySource)
+	reg.MustRegister(errorsByType)
+	reg.MustRegister(invalidPayloads)
+	reg.MustRegister(batchSizeHistogram)
+	prewarmRequests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        metricsPrefix + "prewarm_requests_count",
+		Help:        "Number of requests sent to pre-warm the connections",
+		ConstLabels: constLabels,
+	}, []string{"error"})
+	endpointHealth := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        metricsPrefix + "endpoint_health",
+		Help:        "Whether the HTTP endpoint is healthy (1) or skipped after consecutive failures (0)",
+		ConstLabels: constLabels,
+	}, []string{"endpoint"})
+	endpointRequests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        metricsPrefix + "endpoint_requests_count",
+		Help:        "Number of requests per HTTP endpoint",
+		ConstLabels: constLabels,
+	}, []string{"endpoint", "error"})
+	goMaxProcs := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        metricsPrefix + "gomaxprocs",
+		Help:        "Number of CPUs the producer runs on (GOMAXPROCS), derived from the CPU quota if not set",
+		ConstLabels: constLabels,
+	})
+	cpuQuotaGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        metricsPrefix + "cpu_quota",
+		Help:        "CPU quota of the container in CPUs (0 means no limit)",
+		ConstLabels: constLabels,
+	})
+	goMaxProcs.Set(float64(maxProcs))
+	cpuQuotaGauge.Set(cpuQuota)
+	reg.MustRegister(goMaxProcs)
+	reg.MustRegister(cpuQuotaGauge)
+	reg.MustRegister(dataBudgetRemaining)
+	reg.MustRegister(prewarmRequests)
+	reg.MustRegister(endpointHealth)
+	reg.MustRegister(endpointRequests)
+	reg.MustRegister(keyRotationsTotal)
+	reg.MustRegister(slowRequests)
+	reg.MustRegister(failedPayloadsCaptured)
+	reg.MustRegister(responseValidationErrors)
 	// PROMETHEUS REGISTRY - END
 
+	var rampLimiter *rampThrottler
+	switch {
+	case burst != nil: // the bursts modulate either the constant rate or the ramp
+		target := func(time.Duration) float64 { return float64(maxEventsPerSecond) }
+		if ramp != nil {
+			target = func(elapsed time.Duration) float64 { return float64(rampTarget(ramp, elapsed)) }
+		}
+		rampLimiter = newRateThrottler(func(elapsed time.Duration) float64 {
+			return burst.Modulate(target(elapsed), elapsed)
+		}, targetEventsPerSecond)
+	case ramp != nil:
+		rampLimiter = newRampThrottler(ramp, targetEventsPerSecond)
+	case adaptive != nil:
+		rampLimiter = newRateThrottler(func(time.Duration) float64 { return adaptive.Limit() }, targetEventsPerSecond)
+	default:
+		targetEventsPerSecond.Set(float64(maxEventsPerSecond))
+	}
+
 	// Setting up dependencies for publishers - START
+	var deadLetters *failedPayloads // see FAILED_PAYLOAD_DIR
+	if failedPayloadDir != "" {
+		deadLetters, err = newFailedPayloads(
+			failedPayloadDir, hostname, int64(failedPayloadMaxMB)*1024*1024, concurrency, failedPayloadsCaptured,
+			func(err error) {
+				log.Errorn("Cannot capture failed payloads, further errors are not logged",
+					logger.NewStringField("failedPayloadDir", failedPayloadDir),
+					logger.NewErrorField(err),
+				)
+			},
+		)
+		if err != nil {
+			log.Errorn("Invalid FAILED_PAYLOAD_DIR", logger.NewErrorField(err))
+			return 1
+		}
+	}
+
+	// the stdout publisher is shared by all the slots so that STDOUT_MAX_EVENTS applies to the whole producer
+	var stdoutPublisher *producer.StdOutPublisher
+	if mode == modeStdout {
+		stdoutPublisher, err = producer.NewStdoutPublisher(os.Environ())
+		if err != nil {
+			log.Errorn("Cannot create stdout publisher", logger.NewErrorField(err))
+			return 1
+		}
+	}
+	responseValidator, err := producer.NewResponseValidator(validatorType)
+	if err != nil {
+		log.Errorn("Invalid VALIDATOR_TYPE", logger.NewErrorField(err))
+		return 1
+	}
+
+	var (
+		tracer          trace.Tracer                // nil if ENABLE_TRACING is false
+		shutdownTracing func(context.Context) error // flushes the spans, nil if tracing is disabled
+	)
+	if enableTracing {
+		tracerProvider, err := producer.NewOTLPTracerProvider(ctx, map[string]string{
+			"deployment": deploymentName,
+			"mode":       mode,
+			"hostname":   hostname,
+		})
+		if err != nil {
+			log.Errorn("Cannot create OTLP tracer provider", logger.NewErrorField(err))
+			return 1
+		}
+		tracer = tracerProvider.Tracer("rudder-load")
+		shutdownTracing = tracerProvider.Shutdown
+	}
 	publisherFactory := func(clientID string) (publisherCloser, error) {
 		switch mode {
 		case modeHTTP:
-			return producer.NewHTTPProducer(os.Environ())
+			return producer.NewHTTPProducer(os.Environ(), producer.WithOnRetry(func(statusCode int) {
+				if statusCode == 0 { // timed out
+					retries.WithLabelValues("timeout").Inc()
+					return
+				}
+				retries.WithLabelValues(strconv.Itoa(statusCode)).Inc()
+				if statusCode == http.StatusTooManyRequests {
+					throttled.WithLabelValues("server", "").Inc()
+				}
+			}), producer.WithOnEndpointRequest(func(endpoint string, failed bool) {
+				endpointRequests.WithLabelValues(endpoint, strconv.FormatBool(failed)).Inc()
+			}), producer.WithOnEndpointHealth(func(endpoint string, healthy bool) {
+				if healthy {
+					endpointHealth.WithLabelValues(endpoint).Set(1)
+				} else {
+					endpointHealth.WithLabelValues(endpoint).Set(0)
+				}
+			}), producer.WithSourceHeaders(sourceHeaders), producer.WithTracer(tracer),
+				producer.WithResponseValidator(responseValidator), producer.WithRetryAfterMax(retryAfterMax),
+				producer.WithRequestTimeout(publishTimeout),
+			)
 		case modeStdout:
-			return producer.NewStdoutPublisher(), nil
+			return stdoutPublisher, nil
+		case modeFile:
+			return producer.NewFilePublisher(clientID, os.Environ())
 		default:
 			return nil, fmt.Errorf("unknown mode: %s", mode)
 		}
 	}
 
+	var (
+		statsOptions []stats.Option
+		shutdownOTLP func(context.Context) error // flushes the OTLP metrics, nil if OTLP export is disabled
+	)
+	if mode == modeHTTP {
+		statsOptions = append(statsOptions, stats.WithHTTPResponses())
+	}
+	if otlpEndpoint != "" {
+		meterProvider, err := stats.NewOTLPMeterProvider(ctx)
+		if err != nil {
+			log.Errorn("Cannot create OTLP meter provider", logger.NewErrorField(err))
+			return 1
+		}
+		statsOptions = append(statsOptions, stats.WithMeterProvider(meterProvider))
+		shutdownOTLP = meterProvider.Shutdown
+	}
+
 	statsFactory, err := stats.NewFactory(reg, stats.Data{
 		Prefix:         metricsPrefix,
 		WriteKey:       writeKey,
@@ -247,20 +916,33 @@ func run(ctx context.Context) int {
 		Mode:           mode,
 		Concurrency:    concurrency,
 		TotalUsers:     totalUsers,
-	})
+	}, statsOptions...)
 	if err != nil {
-		printErr(fmt.Errorf("cannot create stats factory: %v", err))
+		log.Errorn("Cannot create stats factory", logger.NewErrorField(err))
 		return 1
 	}
 
-	var client publisherCloser
+	var (
+		client            publisherCloser
+		publishers        []publisherCloser // closed once all the slots return, e.g. to flush the files
+		prewarmWorkers    []prewarmer       // one per connection to establish before publishing
+		addPrewarmWorkers = func(p publisherCloser, connections int) {
+			if hp, ok := p.(*producer.HTTPProducer); ok && prewarmConnections {
+				for j := 0; j < min(connections, hp.MaxConnsPerHost()); j++ {
+					prewarmWorkers = append(prewarmWorkers, hp)
+				}
+			}
+		}
+	)
 	if !useOneClientPerSlot {
 		p, err := publisherFactory(os.Getenv("HOSTNAME"))
 		if err != nil {
-			printErr(fmt.Errorf("cannot create publisher: %v", err))
+			log.Errorn("Cannot create publisher", logger.NewErrorField(err))
 			return 1
 		}
+		addPrewarmWorkers(p, concurrency) // every slot can hold a connection
 		client = statsFactory.New(p)
+		publishers = append(publishers, client)
 	}
 	// Setting up dependencies for publishers - END
 
@@ -268,29 +950,38 @@ func run(ctx context.Context) int {
 		wg                  sync.WaitGroup
 		httpServersWG       sync.WaitGroup
 		publishedMessages   atomic.Int64
+		failedMessages      atomic.Int64 // messages not published because of an error, used with ADAPTIVE_LOAD
 		processedBytes      atomic.Int64
-		sentBytes           atomic.Int64
+		ready               atomic.Bool
+		generatedEvents     atomic.Int64 // events handed to the publishers, used with TOTAL_EVENTS
+		totalEventsReached  atomic.Bool
 		startPublishingTime time.Time
-		printer             = make(chan struct{})
-		leakyErrors         = make(chan error, 1)
+		leakyLog            = newLeakyLogger(log, time.Second)
 		messages            = make(chan *message, concurrency)
+		rotator             keyRotator                      // see KEY_ROTATION
+		reloader            atomic.Pointer[trafficReloader] // see RELOAD_CONFIG_FILE, set once the generators start
+		latencies           latencyHistogram
+		timer               = &publishTimer{
+			slowThreshold: slowRequestThreshold,
+			slowRequests:  slowRequests,
+			latencies:     &latencies,
+		}
 	)
 
-	go func() {
-		for {
-			select {
-			case <-printer:
-				return
-			case <-time.After(time.Second):
-				select {
-				case <-printer:
-					return
-				case err := <-leakyErrors:
-					printErr(err)
-				}
-			}
-		}
-	}()
+	// Once the data budget is exhausted (or TOTAL_EVENTS are generated) the generators stop, the publishers finish
+	// the in-flight messages and the servers are shut down so that run can return without waiting for a termination
+	// signal.
+	generatorsCtx, stopGenerators := context.WithCancel(ctx)
+	defer stopGenerators()
+	serversCtx, stopServers := context.WithCancel(ctx)
+	defer stopServers()
+	budget := newDataBudget(int64(maxData), dataBudgetRemaining, func() {
+		log.Infon("Data budget exhausted, stopping...", logger.NewStringField("dataBudget", byteCount(uint64(maxData))))
+		stopGenerators()
+	})
+	completed := func() bool {
+		return budget.Exhausted() || totalEventsReached.Load()
+	}
 
 	// HTTP METRICS SERVER - START
 	httpServersWG.Add(1)
@@ -302,6 +993,12 @@ func run(ctx context.Context) int {
 			Registry:          reg,
 			EnableOpenMetrics: true,
 		}))
+		mux.HandleFunc("/health", healthHandler)
+		mux.Handle("/ready", readyHandler(ctx, &ready))
+		mux.Handle("/summary", summaryHandler(&latencies, mode))
+		if reloadConfigFile != "" {
+			mux.Handle("/reload", reloadHandler(&reloader, log))
+		}
 		srv := http.Server{
 			Addr:    ":9102",
 			Handler: mux,
@@ -310,16 +1007,16 @@ func run(ctx context.Context) int {
 		httpServersWG.Add(1)
 		go func() {
 			defer httpServersWG.Done()
-			<-ctx.Done()
-			fmt.Printf("Shutting down the HTTP metrics server...\n")
+			<-serversCtx.Done()
+			log.Infon("Shutting down the HTTP metrics server...")
 			if err := srv.Shutdown(context.Background()); err != nil {
-				printErr(fmt.Errorf("HTTP server shutdown: %w", err))
+				log.Errorn("HTTP server shutdown", logger.NewErrorField(err))
 			}
 		}()
 
 		err := srv.ListenAndServe()
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			printErr(fmt.Errorf("HTTP server: %w", err))
+			log.Errorn("HTTP server", logger.NewErrorField(err))
 		}
 	}()
 	// HTTP METRICS SERVER - END
@@ -329,33 +1026,152 @@ func run(ctx context.Context) int {
 	go func() {
 		defer httpServersWG.Done()
 
-		err := profiler.StartServer(ctx, 7777)
+		err := profiler.StartServer(serversCtx, 7777)
 		if err != nil {
-			printErr(fmt.Errorf("profiler server error: %w", err))
+			log.Errorn("Profiler server", logger.NewErrorField(err))
 		}
 	}()
 	// PROFILER SERVER - END
 
+	// The generators stop as soon as ctx is canceled while the publishers keep draining the messages channel
+	// for up to SHUTDOWN_DRAIN_TIMEOUT before publishCtx is canceled as well.
+	publishCtx := ctx
+	var publishedAtShutdown atomic.Int64
+	if shutdownDrainTimeout > 0 {
+		var cancelPublish context.CancelFunc
+		publishCtx, cancelPublish = context.WithCancel(context.WithoutCancel(ctx))
+		defer cancelPublish()
+
+		go func() {
+			select {
+			case <-publishCtx.Done():
+				return
+			case <-ctx.Done():
+			}
+			publishedAtShutdown.Store(publishedMessages.Load())
+			log.Infon("Draining messages...", logger.NewDurationField("shutdownDrainTimeout", shutdownDrainTimeout))
+			select {
+			case <-publishCtx.Done():
+			case <-time.After(shutdownDrainTimeout):
+				cancelPublish()
+			}
+		}()
+	}
+
 	defer func() {
-		fmt.Printf("Waiting for all routines to return...\n")
+		log.Infon("Waiting for all routines to return...")
 		wg.Wait()
+		for _, p := range publishers {
+			if err := p.Close(); err != nil {
+				log.Errorn("Cannot close publisher", logger.NewErrorField(err))
+			}
+		}
 
-		close(printer)
+		timeToPublish := time.Since(startPublishingTime)
+		summaryFields := []logger.Field{
+			logger.NewDurationField("timeToPublish", timeToPublish.Round(time.Millisecond)),
+			logger.NewIntField("publishedMessages", publishedMessages.Load()),
+			logger.NewIntField("processedBytes", processedBytes.Load()),
+			logger.NewIntField("sentBytes", budget.Sent()),
+			logger.NewFloatField("publishingRate", float64(publishedMessages.Load())/timeToPublish.Seconds()),
+		}
+		latencySummary := latencies.Summary(mode)
+		summaryFields = append(summaryFields,
+			logger.NewDurationField("publishLatencyP50", latencySummary.P50),
+			logger.NewDurationField("publishLatencyP90", latencySummary.P90),
+			logger.NewDurationField("publishLatencyP99", latencySummary.P99),
+			logger.NewDurationField("publishLatencyMax", latencySummary.Max),
+		)
+		if shutdownDrainTimeout > 0 {
+			summaryFields = append(summaryFields,
+				logger.NewIntField("drainedMessages", publishedMessages.Load()-publishedAtShutdown.Load()),
+				logger.NewIntField("droppedMessages", int64(len(messages))),
+			)
+		}
+		if budget.Exhausted() {
+			summaryFields = append(summaryFields, logger.NewIntField("dataBudget", int64(maxData)))
+		}
+		if totalEvents > 0 {
+			summaryFields = append(summaryFields,
+				logger.NewIntField("totalEvents", int64(totalEvents)),
+				logger.NewIntField("generatedEvents", generatedEvents.Load()),
+			)
+		}
+		if adaptive != nil {
+			summaryFields = append(summaryFields, logger.NewFloatField("adaptiveBestEventsPerSecond", adaptive.Best()))
+		}
+		log.Infon("Summary", summaryFields...)
 
-		fmt.Printf("Time to publish: %s\n", time.Since(startPublishingTime).Round(time.Millisecond))
+		fmt.Printf("Time to publish: %s\n", timeToPublish.Round(time.Millisecond))
 		fmt.Printf("Published messages: %d\n", publishedMessages.Load())
 		fmt.Printf("Processed bytes (%d): %s\n", processedBytes.Load(), byteCount(uint64(processedBytes.Load())))
-		fmt.Printf("Sent bytes (%d): %s\n", sentBytes.Load(), byteCount(uint64(sentBytes.Load())))
+		fmt.Printf("Sent bytes (%d): %s\n", budget.Sent(), byteCount(uint64(budget.Sent())))
 		fmt.Printf("Publishing rate (msg/s): %.2f\n",
-			float64(publishedMessages.Load())/time.Since(startPublishingTime).Seconds(),
+			float64(publishedMessages.Load())/timeToPublish.Seconds(),
 		)
+		fmt.Printf("Publish latency (%s, %d requests): p50=%s p90=%s p99=%s max=%s\n",
+			latencySummary.Mode, latencySummary.Count,
+			latencySummary.P50, latencySummary.P90, latencySummary.P99, latencySummary.Max,
+		)
+		if shutdownDrainTimeout > 0 {
+			fmt.Printf("Drained messages: %d\n", publishedMessages.Load()-publishedAtShutdown.Load())
+			fmt.Printf("Dropped messages: %d\n", len(messages))
+		}
+
+		if budget.Exhausted() {
+			fmt.Printf("Data budget: %d bytes, sent: %d bytes, published messages: %d\n",
+				maxData, budget.Sent(), publishedMessages.Load(),
+			)
+		}
+		if adaptive != nil {
+			fmt.Printf("Highest sustained events per second (error rate under %s): %.0f\n",
+				adaptiveTargetError, adaptive.Best(),
+			)
+		}
+		if totalEventsReached.Load() {
+			fmt.Printf("Total events: %d, generated events: %d (overshoot: %d)\n",
+				totalEvents, generatedEvents.Load(), generatedEvents.Load()-int64(totalEvents),
+			)
+		}
 
-		fmt.Printf("Waiting for termination signal to close HTTP metrics server...\n")
+		if pushgatewayURL != "" {
+			log.Infon("Pushing metrics...", logger.NewStringField("pushgatewayURL", pushgatewayURL))
+			if err := pushMetrics(pushgatewayURL, reg, loadRunID, hostname, pushgatewayTimeout); err != nil {
+				log.Errorn("Cannot push metrics", logger.NewStringField("pushgatewayURL", pushgatewayURL), logger.NewErrorField(err))
+			} else {
+				log.Infon("Metrics pushed", logger.NewStringField("pushgatewayURL", pushgatewayURL))
+			}
+		}
+		if shutdownOTLP != nil {
+			log.Infon("Flushing OTLP metrics...", logger.NewStringField("otlpEndpoint", otlpEndpoint))
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), otlpShutdownTimeout)
+			if err := shutdownOTLP(shutdownCtx); err != nil {
+				log.Errorn("Cannot flush OTLP metrics", logger.NewStringField("otlpEndpoint", otlpEndpoint), logger.NewErrorField(err))
+			}
+			cancel()
+		}
+		if shutdownTracing != nil {
+			log.Infon("Flushing spans...")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), otlpShutdownTimeout)
+			if err := shutdownTracing(shutdownCtx); err != nil {
+				log.Errorn("Cannot flush spans", logger.NewErrorField(err))
+			}
+			cancel()
+		}
+
+		if completed() {
+			stopServers()
+		} else {
+			log.Infon("Waiting for termination signal to close HTTP metrics server...")
+		}
 		httpServersWG.Wait()
 	}()
 
+	log.Infon("Building batch sizes concentration...")
+	batchSizesConcentration := newBatchSizesCapper(parsedEventTypes, batchSizes, hotBatchSizes, maxBatchSize)
+
 	// Starting the go routines - START
-	fmt.Printf("Starting %d go routines...\n", concurrency)
+	log.Infon("Starting go routines...", logger.NewIntField("concurrency", int64(concurrency)))
 
 	for i := 0; i < concurrency; i++ {
 		var localClient publisherCloser
@@ -364,69 +1180,173 @@ func run(ctx context.Context) int {
 		} else {
 			p, err := publisherFactory(os.Getenv("HOSTNAME") + "_" + strconv.Itoa(i))
 			if err != nil {
-				printErr(fmt.Errorf("cannot create publisher: %v", err))
+				log.Errorn("Cannot create publisher", logger.NewErrorField(err))
 				return 1
 			}
+			addPrewarmWorkers(p, 1) // a slot publishes one message at a time
 			localClient = statsFactory.New(p)
+			publishers = append(publishers, localClient)
 		}
 
 		wg.Add(1)
 		go func(ch chan *message, client publisherCloser, i int) {
 			defer wg.Done()
 
+			slotLog := log.Withn(logger.NewIntField("slot", int64(i)))
+			slotWriteKey := writeKey
+			if sourceAssignmentMode == sourceAssignmentModePinned {
+				slotWriteKey = pinnedSource(pinnedSources, i)
+				slotLog = slotLog.Withn(logger.NewStringField("writeKey", slotWriteKey))
+			}
+
+			var deadLettersWriter *failedPayloadsWriter
+			if deadLetters != nil {
+				deadLettersWriter = deadLetters.Writer(i)
+				defer func() {
+					if err := deadLettersWriter.Close(); err != nil {
+						slotLog.Warnn("Cannot close failed payloads file", logger.NewErrorField(err))
+					}
+				}()
+			}
+
 			for {
 				select {
-				case <-ctx.Done():
+				case <-publishCtx.Done():
 					return
 				case msg, ok := <-ch:
 					if !ok {
 						return
 					}
+					if publishCtx.Err() != nil { // the message is dropped
+						return
+					}
+					if budget.Exhausted() { // the buffered messages are dropped
+						continue
+					}
 
 					publishRatePerSecond.Set(
 						float64(publishedMessages.Load()) / time.Since(startPublishingTime).Seconds(),
 					)
 
-					if maxEventsPerSecond > 0 {
-						for {
-							allowed, after, _, err := throttler.AllowAfter(ctx, msg.NoOfEvents, int64(maxEventsPerSecond), 1, "key")
-							if err != nil {
-								panic(fmt.Errorf("error getting allowed events: %w", err))
-							}
-							if allowed {
-								break
-							}
-							throttled.Inc()
-							select {
-							case <-ctx.Done():
-								return
-							case <-time.After(after):
-							}
+					extra := map[string]string{
+						"auth":         slotWriteKey,
+						"anonymous_id": msg.UserID,
+						"event_type":   msg.EventType,
+					}
+					if tracer != nil {
+						extra["slot"] = strconv.Itoa(i)
+					}
+					if sourcesConcentration != nil {
+						source := sourcesConcentration[rand.Intn(100)]
+						extra["auth"] = source.WriteKey
+						if source.Endpoint != "" {
+							extra["endpoint"] = source.Endpoint
 						}
 					}
 
-					n, err := client.PublishTo(ctx, msg.UserID, msg.Payload, map[string]string{
-						"auth":         writeKey,
-						"anonymous_id": msg.UserID,
+					// MAX_EVENTS_PER_SECOND is enforced first and then MAX_EVENTS_PER_SECOND_PER_SOURCE, the events
+					// are counted by each limiter only once they are allowed by the previous one
+					var limits []func() (bool, time.Duration, error)
+					switch {
+					case rampLimiter != nil:
+						limits = append(limits, func() (bool, time.Duration, error) {
+							allowed, after := rampLimiter.AllowAfter(msg.NoOfEvents)
+							return allowed, after, nil
+						})
+					case maxEventsPerSecond > 0:
+						limits = append(limits, func() (bool, time.Duration, error) {
+							allowed, after, _, err := throttler.AllowAfter(publishCtx, msg.NoOfEvents, int64(maxEventsPerSecond), 1, "key")
+							return allowed, after, err
+						})
+					}
+					if perSourceThrottlers != nil {
+						limits = append(limits, func() (bool, time.Duration, error) {
+							return perSourceThrottlers.AllowAfter(publishCtx, extra["auth"], msg.NoOfEvents)
+						})
+					}
+					throttledSource := "" // the throttled series are split by write key only with per source limits
+					if perSourceThrottlers != nil {
+						throttledSource = extra["auth"]
+					}
+					for _, allowAfter := range limits {
+						allowed, err := waitAllowed(publishCtx, allowAfter, func() {
+							throttled.WithLabelValues("client", throttledSource).Inc()
+						})
+						if err != nil {
+							panic(fmt.Errorf("error getting allowed events: %w", err))
+						}
+						if !allowed {
+							return
+						}
+					}
+
+					if xffIPs != nil {
+						xff := xffIPs.Get(msg.UserID)
+						extra["x_forwarded_for"] = xff.IP
+						xffBuckets.WithLabelValues(xff.Bucket).Inc()
+					}
+					if msg.ClientIP.IP != "" {
+						extra["x_forwarded_for"] = msg.ClientIP.IP
+						xffBuckets.WithLabelValues(msg.ClientIP.Bucket).Inc()
+					}
+					if queryParamsConcentration != nil {
+						// the variant labels the publish_duration_seconds metric too
+						if variant := queryParamsConcentration[rand.Intn(100)]; variant != "" {
+							extra["query_params"] = variant
+						}
+					}
+
+					extra["auth"] = rotator.WriteKey(extra["auth"])
+
+					batchSizeHistogram.Observe(float64(msg.NoOfEvents))
+					n, err := timer.Publish(publishCtx, client, msg.UserID, msg.Payload, extra, func(elapsed time.Duration) {
+						leakyLog.Warnn("Slow publish request",
+							logger.NewIntField("slot", int64(i)),
+							logger.NewDurationField("duration", elapsed),
+						)
 					})
-					if ctx.Err() != nil {
-						printErr(ctx.Err())
+					if publishCtx.Err() != nil {
+						slotLog.Warnn("Publish canceled", logger.NewErrorField(publishCtx.Err()))
 						continue
 					}
 					if err == nil {
 						publishedMessages.Add(1)
-						sentBytes.Add(int64(n))
+						budget.Add(int64(n))
+						publishedMessagesByType.WithLabelValues(msg.EventType).Inc()
+						publishedMessagesBySource.WithLabelValues(extra["auth"]).Inc()
+						continue
+					}
+					failedMessages.Add(1)
+					errorsByType.WithLabelValues(msg.EventType).Inc()
+
+					var validationErr *producer.ResponseValidationError
+					if errors.As(err, &validationErr) {
+						responseValidationErrors.Inc()
+					}
+
+					var statusErr *producer.HTTPStatusError
+					if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusRequestEntityTooLarge {
+						if newMax, ok := batchSizesConcentration.Cap(msg.EventType, int(msg.NoOfEvents)); ok {
+							slotLog.Warnn("Payload too large, reducing max batch size",
+								logger.NewStringField("eventType", msg.EventType),
+								logger.NewIntField("batchSize", msg.NoOfEvents),
+								logger.NewIntField("maxBatchSize", int64(newMax)),
+							)
+						}
 						continue
 					}
 
 					switch mode {
 					case modeHTTP:
-						if strings.Contains(err.Error(), "i/o timeout") {
-							printLeakyErr(leakyErrors, fmt.Errorf("error for producer %d: %w", i, err), true)
+						if isTimeout(err) { // the message is dropped once the retries are exhausted
+							leakyLog.Warnn("Publish timed out", logger.NewIntField("slot", int64(i)), logger.NewErrorField(err))
 							continue
 						}
 					}
-					printErr(fmt.Errorf("[non-retryable] error for producer %d: %w", i, err))
+					slotLog.Errorn("Non-retryable publish error", logger.NewErrorField(err))
+					if deadLettersWriter != nil {
+						deadLettersWriter.Capture(extra["auth"], msg.Payload, err)
+					}
 					break
 				}
 			}
@@ -434,53 +1354,220 @@ func run(ctx context.Context) int {
 	}
 	// Starting the go routines - END
 
-	fmt.Printf("Getting templates...\n")
+	log.Infon("Getting templates...")
 	templates, err := getTemplates(templatesPath)
 	if err != nil {
-		printErr(fmt.Errorf("cannot get templates: %w", err))
+		log.Errorn("Cannot get templates", logger.NewStringField("templatesPath", templatesPath), logger.NewErrorField(err))
 		return 1
 	}
-	fmt.Printf("Building users concentration...\n")
-	userIDsConcentration := getUserIDsConcentration(totalUsers, hotUserGroups, true)
-	fmt.Printf("Building event types concentration...\n")
-	eventTypesConcentration := getEventTypesConcentration(loadRunID, parsedEventTypes, hotEventTypes, eventGenerators, templates)
-	fmt.Printf("Building batch sizes concentration...\n")
-	batchSizesConcentration := getBatchSizesConcentration(batchSizes, hotBatchSizes)
+	log.Infon("Building users and event types concentrations...")
+	userIDs := newUserIDs(totalUsers, true)
+	identities := identityResolver{
+		mode:                identityMode,
+		anonymousPercentage: anonymousPercentage,
+	}
+	traffic := &trafficReloader{
+		path: reloadConfigFile,
+		validate: func(hot hotSettings) error {
+			return validateHotSettings(hot, totalUsers, len(parsedEventTypes), len(batchSizes))
+		},
+		build: func(hot hotSettings) *trafficDistribution {
+			return &trafficDistribution{
+				hot:            hot,
+				userIDs:        getUserIDsConcentrationOf(userIDs, hot.UserGroups),
+				eventTypeNames: getEventTypeNamesConcentration(parsedEventTypes, hot.EventTypes),
+				eventTypes: getEventTypesConcentration(
+					loadRunID, parsedEventTypes, hot.EventTypes, eventGenerators, templates, identities, skew.Timestamp,
+				),
+			}
+		},
+		onReload: func(hot hotSettings) {
+			batchSizesConcentration.SetHotBatchSizes(hot.BatchSizes)
+		},
+	}
+	traffic.current.Store(traffic.build(hotSettings{
+		UserGroups: hotUserGroups,
+		EventTypes: hotEventTypes,
+		BatchSizes: hotBatchSizes,
+	}))
+	if padder != nil {
+		for _, et := range parsedEventTypes {
+			samples := map[string][]byte{}
+			if len(et.Members) == 0 {
+				samples[et.String()] = eventGenerators[et.Type](
+					templates[et.Type], identity{UserID: "sample"}, loadRunID, 1, et.Values, time.Now(),
+				)
+			}
+			for _, m := range et.Members { // every event of a mixed batch is padded on its own
+				samples[m.Type] = eventGenerators[m.Type](
+					templates[m.Type], identity{UserID: "sample"}, loadRunID, 1, nil, time.Now(),
+				)
+			}
+			for name, sample := range samples {
+				if err := padder.Validate(name, sample); err != nil {
+					log.Errorn("Invalid EVENT_SIZE_BYTES", logger.NewErrorField(err))
+					return 1
+				}
+			}
+		}
+	}
+
+	var sessions *sessionScheduler
+	if sessionMode {
+		for _, step := range sessionScript {
+			if templates[step.EventType] == nil {
+				log.Errorn("Invalid SESSION_SCRIPT: missing template", logger.NewStringField("eventType", step.EventType))
+				return 1
+			}
+			if padder == nil {
+				continue
+			}
+			sample := eventGenerators[step.EventType](templates[step.EventType], identity{UserID: "sample"}, loadRunID, 1, nil, time.Now())
+			if err := padder.Validate(step.EventType, sample); err != nil {
+				log.Errorn("Invalid EVENT_SIZE_BYTES", logger.NewErrorField(err))
+				return 1
+			}
+		}
+		sessions = &sessionScheduler{
+			script:     sessionScript,
+			emit:       sessionEmit,
+			loadRunID:  loadRunID,
+			templates:  templates,
+			identities: identities,
+			timestamps: skew.Timestamp,
+		}
+	}
+
+	if len(prewarmWorkers) > 0 {
+		log.Infon("Pre-warming connections...",
+			logger.NewIntField("connections", int64(len(prewarmWorkers))),
+			logger.NewDurationField("prewarmDuration", prewarmDuration),
+		)
+		prewarm(ctx, prewarmWorkers, prewarmDuration, func(err error) {
+			prewarmRequests.WithLabelValues(strconv.FormatBool(err != nil)).Inc()
+		})
+	}
 
-	fmt.Printf("Publishing messages with %d generators...\n", messageGenerators)
+	log.Infon("Publishing messages...", logger.NewIntField("messageGenerators", int64(messageGenerators)))
 	startPublishingTime = time.Now()
-	group, gCtx := kitsync.NewEagerGroup(ctx, messageGenerators)
+	sender := &messageSender{ch: messages, blockedSends: msgGenLag, blockedSeconds: generatorBlockedSeconds}
+	go sampleChannelDepth(generatorsCtx, messages, messagesChannelDepth, time.Second)
+	if len(keyRotations) > 0 {
+		go rotator.Run(publishCtx, keyRotations, func(rotation keyRotation) {
+			log.Infon("Write key rotated",
+				logger.NewStringField("oldKey", rotation.OldKey),
+				logger.NewStringField("newKey", rotation.NewKey),
+				logger.NewDurationField("after", rotation.After),
+			)
+			keyRotationsTotal.Inc()
+		})
+	}
+	if adaptive != nil {
+		log.Infon("Adaptive load enabled",
+			logger.NewFloatField("eventsPerSecond", adaptive.Limit()),
+			logger.NewDurationField("interval", adaptiveInterval),
+		)
+		go adaptive.Run(publishCtx, adaptiveInterval, publishedMessages.Load, failedMessages.Load, log)
+	}
+	if reloadConfigFile != "" {
+		reloader.Store(traffic)
+		go reloadOnSignal(generatorsCtx, traffic, log)
+	}
+	group, gCtx := kitsync.NewEagerGroup(generatorsCtx, messageGenerators)
 	for i := 0; i < messageGenerators; i++ {
 		group.Go(func() error {
-			defer fmt.Printf("Message generator %d is done\n", i)
+			defer log.Infon("Message generator is done", logger.NewIntField("generator", int64(i)))
+			ready.Store(true)
+			var generated []*message // the messages generated for the picked user, a whole session with SESSION_MODE
 			for {
 				random := rand.Intn(100)
-				userID := userIDsConcentration[random]()
-				batchSize := batchSizesConcentration[random]
-				msg := eventTypesConcentration[random](userID, batchSize)
-				processedBytes.Add(int64(len(msg)))
+				distribution := traffic.Load()
+				userID := distribution.userIDs[random]()
+				if sessions != nil {
+					var err error
+					if generated, err = sessions.Next(userID); err != nil {
+						return fmt.Errorf("cannot generate session: %w", err)
+					}
+				} else {
+					eventType := distribution.eventTypeNames[random]
+					payload, batchSize := distribution.eventTypes[random](userID, batchSizesConcentration.Get(eventType, random))
+					generated = append(generated[:0], &message{
+						Payload:    payload,
+						UserID:     userID,
+						EventType:  eventType,
+						NoOfEvents: int64(batchSize),
+					})
+				}
 
-				start := time.Now()
-				select {
-				case <-gCtx.Done():
-					return gCtx.Err()
-				case messages <- &message{
-					Payload:    msg,
-					UserID:     userID,
-					NoOfEvents: int64(batchSize),
-				}:
-					// Check if delta between now and start is less than 1ms then increment the counter
-					if time.Since(start) < time.Millisecond {
-						msgGenLag.Inc()
+				if userIPs != nil {
+					ip := userIPs.Get(userID)
+					for _, m := range generated {
+						m.ClientIP = ip
+					}
+				}
+
+				for _, m := range generated {
+					eventType, batchSize, msg := m.EventType, int(m.NoOfEvents), m.Payload
+					if validatePayloads != validatePayloadsOff {
+						if err := validatePayload(eventType, msg, schemas); err != nil {
+							invalidPayloads.Inc()
+							if validatePayloads == validatePayloadsStrict {
+								return err
+							}
+							continue
+						}
+					}
+					if padder != nil {
+						padded, err := padder.Pad(msg)
+						if err != nil {
+							return fmt.Errorf("cannot pad %s payload: %w", eventType, err)
+						}
+						msg = padded
+					}
+					processedBytes.Add(int64(len(msg)))
+
+					// the events are reserved before handing the message to the publishers so that only the message
+					// reaching TOTAL_EVENTS can overshoot it. Once it is reached the generators return on their next
+					// reservation, the ones blocked sending messages below the limit are not canceled.
+					var reservedEvents int64
+					if totalEvents > 0 {
+						reservedEvents = generatedEvents.Add(int64(batchSize))
+						if reservedEvents-int64(batchSize) >= int64(totalEvents) {
+							generatedEvents.Add(-int64(batchSize))
+							return nil
+						}
+					}
+
+					m.Payload = msg
+					if err := sender.Send(gCtx, m); err != nil {
+						if totalEvents > 0 {
+							generatedEvents.Add(-int64(batchSize))
+						}
+						return err
+					}
+					if totalEvents > 0 && reservedEvents >= int64(totalEvents) {
+						log.Infon("Total events generated, stopping...",
+							logger.NewIntField("totalEvents", int64(totalEvents)),
+							logger.NewIntField("overshoot", reservedEvents-int64(totalEvents)),
+						)
+						totalEventsReached.Store(true)
+						return nil
 					}
 				}
 			}
 		})
 	}
-	if err := group.Wait(); err != nil {
-		printErr(fmt.Errorf("error generating messages: %w", err))
+	err = group.Wait()
+	if completed() && errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	if err != nil {
+		log.Errorn("Error generating messages", logger.NewErrorField(err))
 	}
 	close(messages)
 
+	if errors.Is(err, errInvalidPayload) {
+		return 1
+	}
 	return 0
 }
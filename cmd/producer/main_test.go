@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -236,6 +239,58 @@ func TestGetTemplates(t *testing.T) {
 	})
 }
 
+func TestGetTemplatesPartials(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+templatesExtension), []byte(content), 0o600))
+	}
+	writeTemplate("_context", `{{define "context"}}{"load_run_id": "{{.LoadRunID}}", "library": {"name": "rudder-load"}}{{end}}`)
+	writeTemplate("_app", `{"name": "{{.App}}", "build": {{randomInt 1 1}}}`)
+	writeTemplate("track", `{
+		"type": "track",
+		"event": "{{.Event}}",
+		"os": "{{randomFrom "ios" "ios"}}",
+		"year": "{{formatTime "2006"}}",
+		"context": {{template "context" .}},
+		"app": {{template "_app.json.tmpl" .}}
+	}`)
+
+	templates, err := getTemplates(dir)
+	require.NoError(t, err)
+	require.Len(t, templates, 1, "partials should not be event types")
+	require.Contains(t, templates, "track")
+
+	var buf bytes.Buffer
+	err = templates["track"].Execute(&buf, map[string]string{
+		"Event":     "Product Viewed",
+		"LoadRunID": "123",
+		"App":       "shop",
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"type": "track",
+		"event": "Product Viewed",
+		"os": "ios",
+		"year": "`+strconv.Itoa(time.Now().UTC().Year())+`",
+		"context": {"load_run_id": "123", "library": {"name": "rudder-load"}},
+		"app": {"name": "shop", "build": 1}
+	}`, buf.String())
+
+	t.Run("random helpers", func(t *testing.T) {
+		writeTemplate("screen", `{{randomInt 1 3}} {{randomFrom "a" "b"}}`)
+		templates, err := getTemplates(dir)
+		require.NoError(t, err)
+
+		seen := make(map[string]bool)
+		for i := 0; i < 200; i++ {
+			buf.Reset()
+			require.NoError(t, templates["screen"].Execute(&buf, nil))
+			seen[buf.String()] = true
+		}
+		require.Len(t, seen, 6, "every combination of [1, 3] and a/b should show up: %v", seen)
+	})
+}
+
 func TestParseEventTypes(t *testing.T) {
 	t.Run("single event type", func(t *testing.T) {
 		events, err := parseEventTypes("page")
@@ -260,6 +315,38 @@ func TestParseEventTypes(t *testing.T) {
 		require.Equal(t, "batch", events[1].Type)
 		require.Equal(t, []int{1, 2, 3}, events[1].Values)
 	})
+	t.Run("mixed batch", func(t *testing.T) {
+		events, err := parseEventTypes("page,batch(track:70,page:20,identify:10;sizes=1,5,10),batch(track:50,page:50)")
+		require.NoError(t, err)
+		require.Len(t, events, 3)
+		require.Equal(t, "page", events[0].Type)
+		require.Equal(t, eventType{
+			Type:    "batch",
+			Values:  []int{1, 5, 10},
+			Members: []batchMember{{Type: "track", Weight: 70}, {Type: "page", Weight: 20}, {Type: "identify", Weight: 10}},
+		}, events[1])
+		require.Equal(t, "batch(track:70,page:20,identify:10;sizes=1,5,10)", events[1].String())
+		require.Equal(t, eventType{
+			Type:    "batch",
+			Members: []batchMember{{Type: "track", Weight: 50}, {Type: "page", Weight: 50}},
+		}, events[2])
+		require.Equal(t, "batch(track:50,page:50)", events[2].String())
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for input, expectedErr := range map[string]string{
+			"page(1,a)":                          `invalid value in event type "page(1,a)"`,
+			"batch(track:70,page:20)":            "batch members weights should sum to 100: 90",
+			"batch(track:70,screen:30)":          `unknown event type in batch member "screen:30"`,
+			"batch(track:x,page:100)":            `invalid weight in batch member "track:x"`,
+			"batch(track:100,page)":              `invalid batch member "page"`,
+			"batch(track:100;1,5)":               `invalid batch sizes "1,5"`,
+			"batch(track:100;sizes=1,0)":         `invalid batch size "0"`,
+			"page,batch(track:50,page:50;sizes)": `invalid batch sizes "sizes"`,
+		} {
+			_, err := parseEventTypes(input)
+			require.ErrorContains(t, err, expectedErr, input)
+		}
+	})
 }
 
 func TestGetUserIDs(t *testing.T) {
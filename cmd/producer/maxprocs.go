@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted in the container
+const cgroupRoot = "/sys/fs/cgroup"
+
+// readCPUQuota returns the CPU quota of the container in CPUs (e.g. 2.5 for a 2500m Kubernetes limit), zero if the
+// CPUs are not limited. Both cgroup v2 (cpu.max) and v1 (cpu.cfs_quota_us and cpu.cfs_period_us) are supported.
+func readCPUQuota(root string) (float64, error) {
+	if v, err := os.ReadFile(filepath.Join(root, "cpu.max")); err == nil { // cgroup v2, e.g. "200000 100000"
+		fields := strings.Fields(string(v))
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("invalid cpu.max: %q", v)
+		}
+		if fields[0] == "max" {
+			return 0, nil
+		}
+		return parseCPUQuota(fields[0], fields[1])
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("cannot read cpu.max: %w", err)
+	}
+
+	quota, err := os.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_quota_us")) // cgroup v1
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot read cpu.cfs_quota_us: %w", err)
+	}
+	if strings.TrimSpace(string(quota)) == "-1" {
+		return 0, nil
+	}
+	period, err := os.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, fmt.Errorf("cannot read cpu.cfs_period_us: %w", err)
+	}
+	return parseCPUQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func parseCPUQuota(quota, period string) (float64, error) {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0, fmt.Errorf("invalid CPU quota: %q", quota)
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0, fmt.Errorf("invalid CPU period: %q", period)
+	}
+	return float64(q) / float64(p), nil
+}
+
+// maxProcsForQuota returns the GOMAXPROCS matching the CPU quota: the quota rounded down, at least one and at most
+// the number of CPUs of the machine. It returns zero if the CPUs are not limited.
+func maxProcsForQuota(quota float64, numCPU int) int {
+	if quota <= 0 {
+		return 0
+	}
+	return min(max(int(math.Floor(quota)), 1), numCPU)
+}
+
+// setMaxProcs sets GOMAXPROCS via gomaxprocs (i.e. runtime.GOMAXPROCS) according to the CPU quota, unless the
+// GOMAXPROCS environment variable (envMaxProcs) is set. It returns the resulting GOMAXPROCS.
+func setMaxProcs(quota float64, envMaxProcs string, gomaxprocs func(n int) int) int {
+	if envMaxProcs == "" {
+		if procs := maxProcsForQuota(quota, runtime.NumCPU()); procs > 0 {
+			gomaxprocs(procs)
+		}
+	}
+	return gomaxprocs(-1)
+}
+
+const (
+	// concurrencyPerCPU and messageGeneratorsPerCPU size the worker pools when CONCURRENCY and MESSAGE_GENERATORS
+	// are not set, keeping the same 4:1 ratio of the default Helm values
+	concurrencyPerCPU       = 200
+	messageGeneratorsPerCPU = 50
+)
+
+// workerPoolSize returns the number of slots and message generators, deriving the ones that are not set (i.e. zero)
+// from the given GOMAXPROCS
+func workerPoolSize(concurrency, messageGenerators, maxProcs int) (int, int) {
+	if concurrency == 0 {
+		concurrency = concurrencyPerCPU * maxProcs
+	}
+	if messageGenerators == 0 {
+		messageGenerators = messageGeneratorsPerCPU * maxProcs
+	}
+	return concurrency, messageGenerators
+}
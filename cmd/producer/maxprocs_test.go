@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCPUQuota(t *testing.T) {
+	write := func(t *testing.T, root, name, content string) {
+		t.Helper()
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0o600))
+	}
+
+	t.Run("cgroup v2", func(t *testing.T) {
+		root := t.TempDir()
+		write(t, root, "cpu.max", "250000 100000\n")
+		quota, err := readCPUQuota(root)
+		require.NoError(t, err)
+		require.Equal(t, 2.5, quota)
+
+		write(t, root, "cpu.max", "max 100000\n")
+		quota, err = readCPUQuota(root)
+		require.NoError(t, err)
+		require.Zero(t, quota, "no limit")
+
+		write(t, root, "cpu.max", "max")
+		_, err = readCPUQuota(root)
+		require.Error(t, err)
+	})
+
+	t.Run("cgroup v1", func(t *testing.T) {
+		root := t.TempDir()
+		write(t, root, "cpu/cpu.cfs_quota_us", "200000\n")
+		write(t, root, "cpu/cpu.cfs_period_us", "100000\n")
+		quota, err := readCPUQuota(root)
+		require.NoError(t, err)
+		require.Equal(t, 2.0, quota)
+
+		write(t, root, "cpu/cpu.cfs_quota_us", "-1\n")
+		quota, err = readCPUQuota(root)
+		require.NoError(t, err)
+		require.Zero(t, quota, "no limit")
+
+		write(t, root, "cpu/cpu.cfs_quota_us", "200000\n")
+		write(t, root, "cpu/cpu.cfs_period_us", "0\n")
+		_, err = readCPUQuota(root)
+		require.Error(t, err)
+	})
+
+	t.Run("no cgroup", func(t *testing.T) {
+		quota, err := readCPUQuota(t.TempDir())
+		require.NoError(t, err)
+		require.Zero(t, quota)
+	})
+}
+
+func TestMaxProcsForQuota(t *testing.T) {
+	require.Equal(t, 0, maxProcsForQuota(0, 16), "no limit")
+	require.Equal(t, 1, maxProcsForQuota(0.5, 16))
+	require.Equal(t, 2, maxProcsForQuota(2, 16))
+	require.Equal(t, 2, maxProcsForQuota(2.9, 16))
+	require.Equal(t, 4, maxProcsForQuota(8, 4), "not more than the CPUs of the machine")
+}
+
+// fakeGOMAXPROCS behaves like runtime.GOMAXPROCS without changing the GOMAXPROCS of the tests
+type fakeGOMAXPROCS int
+
+func (f *fakeGOMAXPROCS) set(n int) int {
+	prev := int(*f)
+	if n > 0 {
+		*f = fakeGOMAXPROCS(n)
+	}
+	return prev
+}
+
+func TestSetMaxProcs(t *testing.T) {
+	t.Run("from the quota", func(t *testing.T) {
+		procs := fakeGOMAXPROCS(8)
+		require.Equal(t, 1, setMaxProcs(1.5, "", procs.set))
+		require.EqualValues(t, 1, procs)
+	})
+
+	t.Run("no quota", func(t *testing.T) {
+		procs := fakeGOMAXPROCS(8)
+		require.Equal(t, 8, setMaxProcs(0, "", procs.set))
+		require.EqualValues(t, 8, procs)
+	})
+
+	t.Run("GOMAXPROCS takes precedence", func(t *testing.T) {
+		procs := fakeGOMAXPROCS(3)
+		require.Equal(t, 3, setMaxProcs(1.5, "3", procs.set))
+		require.EqualValues(t, 3, procs)
+	})
+}
+
+func TestWorkerPoolSize(t *testing.T) {
+	t.Run("from GOMAXPROCS", func(t *testing.T) {
+		concurrency, messageGenerators := workerPoolSize(0, 0, 3)
+		require.Equal(t, 3*concurrencyPerCPU, concurrency)
+		require.Equal(t, 3*messageGeneratorsPerCPU, messageGenerators)
+	})
+
+	t.Run("from the quota", func(t *testing.T) {
+		procs := fakeGOMAXPROCS(8)
+		concurrency, messageGenerators := workerPoolSize(0, 0, setMaxProcs(1.5, "", procs.set))
+		require.Equal(t, concurrencyPerCPU, concurrency)
+		require.Equal(t, messageGeneratorsPerCPU, messageGenerators)
+	})
+
+	t.Run("explicit settings", func(t *testing.T) {
+		concurrency, messageGenerators := workerPoolSize(10, 0, 4)
+		require.Equal(t, 10, concurrency)
+		require.Equal(t, 4*messageGeneratorsPerCPU, messageGenerators)
+
+		concurrency, messageGenerators = workerPoolSize(0, 7, 4)
+		require.Equal(t, 4*concurrencyPerCPU, concurrency)
+		require.Equal(t, 7, messageGenerators)
+	})
+}
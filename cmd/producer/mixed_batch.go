@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// mixedBatchEventType is the event type of the batches whose events are drawn from weighted event types,
+// e.g. "batch(track:70,page:20,identify:10;sizes=1,5,10)"
+const mixedBatchEventType = "batch"
+
+type batchMember struct {
+	Type   string
+	Weight int
+}
+
+// parseMixedBatch parses the content of a mixed batch event type, i.e. the weighted event types of its events
+// (weights should sum to 100) optionally followed by the batch sizes to pick from (e.g. "track:70,page:30;sizes=1,5").
+// Without sizes the batch sizes are picked from BATCH_SIZES.
+func parseMixedBatch(input string) ([]batchMember, []int, error) {
+	membersValue, sizesValue, hasSizes := strings.Cut(input, ";")
+
+	var (
+		total   = 0
+		parts   = strings.Split(membersValue, ",")
+		members = make([]batchMember, 0, len(parts))
+	)
+	for _, part := range parts {
+		memberType, weightValue, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid batch member %q: expected <event type>:<weight>", part)
+		}
+		if _, ok := eventGenerators[memberType]; !ok {
+			return nil, nil, fmt.Errorf("unknown event type in batch member %q", part)
+		}
+		weight, err := strconv.Atoi(weightValue)
+		if err != nil || weight < 0 {
+			return nil, nil, fmt.Errorf("invalid weight in batch member %q", part)
+		}
+		total += weight
+		members = append(members, batchMember{Type: memberType, Weight: weight})
+	}
+	if total != 100 {
+		return nil, nil, fmt.Errorf("batch members weights should sum to 100: %d", total)
+	}
+	if !hasSizes {
+		return members, nil, nil
+	}
+
+	sizesList, ok := strings.CutPrefix(strings.TrimSpace(sizesValue), "sizes=")
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid batch sizes %q: expected sizes=<size>,<size>,...", sizesValue)
+	}
+	parts = strings.Split(sizesList, ",")
+	sizes := make([]int, 0, len(parts))
+	for _, part := range parts {
+		size, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || size < 1 {
+			return nil, nil, fmt.Errorf("invalid batch size %q: expected a number greater than zero", part)
+		}
+		sizes = append(sizes, size)
+	}
+	return members, sizes, nil
+}
+
+// mixedBatchGenerator generates batches interleaving the events of the member types, every event is generated from
+// the template of its type and all of them carry the same anonymousId
+type mixedBatchGenerator struct {
+	concentration []string // the member type of every percentage
+	sizes         []int    // picked uniformly, if empty the batch size is the given one
+
+	generators map[string]eventGenerator
+	templates  map[string]*template.Template
+	loadRunID  string
+	identities identityResolver
+	timestamps func() time.Time
+}
+
+func newMixedBatchGenerator(
+	et eventType,
+	generators map[string]eventGenerator,
+	templates map[string]*template.Template,
+	loadRunID string,
+	identities identityResolver,
+	timestamps func() time.Time,
+) *mixedBatchGenerator {
+	g := &mixedBatchGenerator{
+		concentration: make([]string, 100),
+		sizes:         et.Values,
+		generators:    generators,
+		templates:     templates,
+		loadRunID:     loadRunID,
+		identities:    identities,
+		timestamps:    timestamps,
+	}
+	startID := 0
+	for _, m := range et.Members {
+		for i := startID; i < m.Weight+startID; i++ {
+			g.concentration[i] = m.Type
+		}
+		startID += m.Weight
+	}
+	return g
+}
+
+// Generate returns a batch for the given user together with its number of events
+func (g *mixedBatchGenerator) Generate(userID string, n int) ([]byte, int) {
+	if len(g.sizes) > 0 {
+		n = g.sizes[rand.Intn(len(g.sizes))]
+	}
+
+	var (
+		anonymousID = g.identities.Resolve(mixedBatchEventType, userID).AnonymousID
+		timestamp   = g.timestamps()
+		events      = make([]json.RawMessage, n)
+	)
+	for i := range events {
+		memberType := g.concentration[rand.Intn(100)]
+		id := g.identities.Resolve(memberType, userID)
+		id.AnonymousID = anonymousID
+
+		var payload struct {
+			Batch []json.RawMessage `json:"batch"`
+		}
+		raw := g.generators[memberType](g.templates[memberType], id, g.loadRunID, 1, nil, timestamp)
+		if err := json.Unmarshal(raw, &payload); err != nil || len(payload.Batch) != 1 {
+			panic(fmt.Errorf("invalid %s payload for mixed batch: %v", memberType, err))
+		}
+		events[i] = payload.Batch[0]
+	}
+
+	payload, err := json.Marshal(struct {
+		Batch []json.RawMessage `json:"batch"`
+	}{Batch: events})
+	if err != nil {
+		panic(fmt.Errorf("cannot encode mixed batch: %w", err))
+	}
+	return payload, n
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMixedBatch(t *testing.T) {
+	templates, err := getTemplates("./../../templates/")
+	require.NoError(t, err)
+
+	type batchEvent struct {
+		Type        string `json:"type"`
+		UserID      string `json:"userId"`
+		AnonymousID string `json:"anonymousId"`
+		MessageID   string `json:"messageId"`
+		Event       string `json:"event"`
+		Name        string `json:"name"`
+	}
+	generate := func(t *testing.T, input string, mode string, n int) ([]batchEvent, int) {
+		eventTypes, err := parseEventTypes(input)
+		require.NoError(t, err)
+		concentration := getEventTypesConcentration("load-run-id", eventTypes, []int{100}, eventGenerators, templates,
+			identityResolver{mode: mode}, time.Now,
+		)
+		payload, count := concentration[0]("user-1", n)
+		var batch struct {
+			Batch []batchEvent `json:"batch"`
+		}
+		require.NoError(t, json.Unmarshal(payload, &batch))
+		require.Len(t, batch.Batch, count)
+		return batch.Batch, count
+	}
+
+	t.Run("composition", func(t *testing.T) {
+		types := make(map[string]int)
+		messageIDs := make(map[string]struct{})
+		for i := 0; i < 100; i++ {
+			events, count := generate(t, "batch(track:70,page:20,identify:10)", identityModeSplit, 10)
+			require.Equal(t, 10, count)
+			anonymousID := events[0].AnonymousID
+			require.NotEmpty(t, anonymousID)
+			for _, event := range events {
+				types[event.Type]++
+				require.Equal(t, anonymousID, event.AnonymousID, "the events should share the batch anonymousId")
+				_, err := uuid.Parse(event.MessageID)
+				require.NoError(t, err)
+				messageIDs[event.MessageID] = struct{}{}
+
+				switch event.Type { // type specific bodies from the templates
+				case "track":
+					require.NotEmpty(t, event.Event)
+				case "page":
+					require.Equal(t, "Home", event.Name)
+				case "identify":
+					require.Equal(t, "user-1", event.UserID)
+				default:
+					t.Fatalf("unexpected event type %q", event.Type)
+				}
+			}
+		}
+		require.Len(t, messageIDs, 1000, "every event should have its own messageId")
+		require.InDelta(t, 700, types["track"], 70)
+		require.InDelta(t, 200, types["page"], 60)
+		require.InDelta(t, 100, types["identify"], 40)
+	})
+	t.Run("sizes", func(t *testing.T) {
+		counts := make(map[int]int)
+		for i := 0; i < 100; i++ {
+			_, count := generate(t, "batch(track:50,page:50;sizes=1,5,10)", identityModeSimple, 3)
+			counts[count]++
+		}
+		require.Len(t, counts, 3)
+		for _, size := range []int{1, 5, 10} {
+			require.Positive(t, counts[size], size)
+		}
+	})
+	t.Run("simple identity mode", func(t *testing.T) {
+		events, _ := generate(t, "batch(track:50,page:50)", identityModeSimple, 20)
+		for _, event := range events {
+			require.Equal(t, "user-1", event.AnonymousID)
+		}
+	})
+}
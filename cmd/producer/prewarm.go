@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// prewarmErrorBackoff is how long a worker waits before trying again after a failed request
+	prewarmErrorBackoff = 100 * time.Millisecond
+	// prewarmRequestTimeout is the timeout of every request. The requests in flight when the duration is over are
+	// not aborted since fasthttp closes the connections of timed out requests.
+	prewarmRequestTimeout = 5 * time.Second
+)
+
+type prewarmer interface {
+	Prewarm(deadline time.Time) error
+}
+
+// prewarm establishes one connection per worker before the load starts.
+// The workers are started gradually over the given duration so that the handshakes are spread over time, then each
+// worker keeps sending requests until the duration is over so that its connection cannot be reused by the others.
+// onRequest is called with the result of every request, after a failed one the worker backs off for
+// prewarmErrorBackoff.
+func prewarm(ctx context.Context, workers []prewarmer, duration time.Duration, onRequest func(err error)) {
+	if len(workers) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		interval = duration / time.Duration(len(workers))
+	)
+	for _, w := range workers {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				err := w.Prewarm(time.Now().Add(prewarmRequestTimeout))
+				onRequest(err)
+				if err != nil {
+					select {
+					case <-ctx.Done():
+					case <-time.After(prewarmErrorBackoff):
+					}
+				}
+			}
+		}()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+	}
+	wg.Wait()
+}
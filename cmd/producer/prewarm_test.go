@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"rudder-load/internal/producer"
+)
+
+func TestPrewarm(t *testing.T) {
+	const connections = 10
+
+	// the server accepts only POST requests like the gateway, the prewarm requests are recorded as "METHOD path"
+	newServer := func(t *testing.T) (*httptest.Server, *atomic.Int64, *sync.Map) {
+		var (
+			newConnections  atomic.Int64
+			prewarmRequests sync.Map
+		)
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(5 * time.Millisecond)
+			if r.Method != http.MethodPost {
+				prewarmRequests.Store(r.Method+" "+r.URL.Path, true)
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				newConnections.Add(1)
+			}
+		}
+		srv.Start()
+		t.Cleanup(srv.Close)
+		return srv, &newConnections, &prewarmRequests
+	}
+	requestsOf := func(prewarmRequests *sync.Map) []string {
+		var requests []string
+		prewarmRequests.Range(func(k, _ any) bool {
+			requests = append(requests, k.(string))
+			return true
+		})
+		return requests
+	}
+
+	publishConcurrently := func(t *testing.T, p *producer.HTTPProducer) {
+		var wg sync.WaitGroup
+		for i := 0; i < connections; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := p.PublishTo(context.Background(), "key", []byte("{}"), nil)
+				require.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+	}
+
+	t.Run("with prewarm", func(t *testing.T) {
+		srv, newConnections, prewarmRequests := newServer(t)
+		p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL + "/v1/batch"})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+
+		workers := make([]prewarmer, connections)
+		for i := range workers {
+			workers[i] = p
+		}
+		var requests atomic.Int64
+		prewarm(context.Background(), workers, 200*time.Millisecond, func(err error) {
+			require.NoError(t, err, "non-2xx responses warm the connections too")
+			requests.Add(1)
+		})
+
+		require.Positive(t, requests.Load())
+		require.Equal(t, []string{"HEAD /v1/batch"}, requestsOf(prewarmRequests))
+		prewarmed := newConnections.Load()
+		require.GreaterOrEqual(t, prewarmed, int64(connections/2), "connections should be established before publishing")
+		require.LessOrEqual(t, prewarmed, int64(connections))
+
+		publishConcurrently(t, p)
+		require.LessOrEqual(t, newConnections.Load(), int64(connections), "the pre-warmed connections should be reused")
+	})
+
+	t.Run("path and method", func(t *testing.T) {
+		srv, _, prewarmRequests := newServer(t)
+		p, err := producer.NewHTTPProducer([]string{
+			"HTTP_ENDPOINT=" + srv.URL + "/v1/batch?param=1",
+			"HTTP_PREWARM_METHOD=OPTIONS",
+			"HTTP_PREWARM_PATH=/health",
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+
+		prewarm(context.Background(), []prewarmer{p}, 50*time.Millisecond, func(err error) {
+			require.NoError(t, err)
+		})
+		require.Equal(t, []string{"OPTIONS /health"}, requestsOf(prewarmRequests))
+
+		for _, environ := range [][]string{
+			{"HTTP_ENDPOINT=" + srv.URL, "HTTP_PREWARM_METHOD=DELETE"},
+			{"HTTP_ENDPOINT=" + srv.URL, "HTTP_PREWARM_PATH=health"},
+		} {
+			_, err := producer.NewHTTPProducer(environ)
+			require.Error(t, err, environ)
+		}
+	})
+
+	t.Run("without prewarm", func(t *testing.T) {
+		srv, newConnections, _ := newServer(t)
+		p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+
+		require.Zero(t, newConnections.Load())
+		publishConcurrently(t, p)
+		require.Positive(t, newConnections.Load())
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start := time.Now()
+		prewarm(ctx, []prewarmer{nil, nil}, time.Minute, func(error) { t.Fatal("unexpected request") })
+		require.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=http://127.0.0.1:1"})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+
+		var failed, succeeded atomic.Int64
+		prewarm(context.Background(), []prewarmer{p}, 500*time.Millisecond, func(err error) {
+			if err != nil {
+				failed.Add(1)
+			} else {
+				succeeded.Add(1)
+			}
+		})
+		require.Zero(t, succeeded.Load())
+		require.Positive(t, failed.Load())
+		require.LessOrEqual(t, failed.Load(), int64(5), "the worker should back off after every error")
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// publishTimer records the publishes slower than SLOW_REQUEST_THRESHOLD. The requests are bounded by PUBLISH_TIMEOUT
+// in the HTTP producer instead, so that the ones that time out are retried before the publish is recorded.
+type publishTimer struct {
+	slowThreshold time.Duration // slow requests are not recorded if zero
+	slowRequests  prometheus.Counter
+	latencies     *latencyHistogram // every publish duration is recorded if not nil
+}
+
+// Publish calls onSlow with the elapsed time if the publish takes longer than the slow requests threshold
+func (t *publishTimer) Publish(
+	ctx context.Context, client publisher, key string, payload []byte, extra map[string]string,
+	onSlow func(elapsed time.Duration),
+) (int, error) {
+	start := time.Now()
+	n, err := client.PublishTo(ctx, key, payload, extra)
+	elapsed := time.Since(start)
+	if t.latencies != nil {
+		t.latencies.Record(elapsed)
+	}
+	if t.slowThreshold > 0 && elapsed > t.slowThreshold {
+		t.slowRequests.Inc()
+		onSlow(elapsed)
+	}
+	return n, err
+}
+
+// isTimeout returns true if the publish timed out, either hitting PUBLISH_TIMEOUT or the transport timeouts, once the
+// HTTP producer exhausted its retries
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "i/o timeout")
+}
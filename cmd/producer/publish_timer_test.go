@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"rudder-load/internal/producer"
+)
+
+func TestPublishTimer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if delay, err := time.ParseDuration(r.URL.Query().Get("delay")); err == nil {
+			time.Sleep(delay)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL + "/v1/batch"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+
+	counterValue := func(t *testing.T, c prometheus.Counter) float64 {
+		var m dto.Metric
+		require.NoError(t, c.Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	newTimer := func(slowThreshold time.Duration) *publishTimer {
+		return &publishTimer{
+			slowThreshold: slowThreshold,
+			slowRequests:  prometheus.NewCounter(prometheus.CounterOpts{Name: "slow_requests"}),
+		}
+	}
+	publish := func(timer *publishTimer, p publisher, delay time.Duration) ([]time.Duration, error) {
+		var slow []time.Duration
+		extra := map[string]string{"query_params": "delay=" + delay.String()}
+		_, err := timer.Publish(context.Background(), p, "key", []byte("{}"), extra, func(elapsed time.Duration) {
+			slow = append(slow, elapsed)
+		})
+		return slow, err
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL + "/v1/batch"},
+			producer.WithRequestTimeout(50*time.Millisecond),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+
+		timer := newTimer(0)
+		start := time.Now()
+		_, err = publish(timer, p, time.Second)
+		require.Error(t, err)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.True(t, isTimeout(err))
+		require.Less(t, time.Since(start), time.Second)
+
+		_, err = publish(timer, p, 0)
+		require.NoError(t, err)
+	})
+	t.Run("slow requests", func(t *testing.T) {
+		timer := newTimer(50 * time.Millisecond)
+		slow, err := publish(timer, p, 100*time.Millisecond)
+		require.NoError(t, err)
+		require.Len(t, slow, 1)
+		require.GreaterOrEqual(t, slow[0], 100*time.Millisecond)
+		require.EqualValues(t, 1, counterValue(t, timer.slowRequests))
+
+		slow, err = publish(timer, p, 0)
+		require.NoError(t, err)
+		require.Empty(t, slow)
+		require.EqualValues(t, 1, counterValue(t, timer.slowRequests))
+	})
+	t.Run("no threshold", func(t *testing.T) {
+		timer := newTimer(0)
+		slow, err := publish(timer, p, 100*time.Millisecond)
+		require.NoError(t, err)
+		require.Empty(t, slow)
+		require.Zero(t, counterValue(t, timer.slowRequests))
+	})
+}
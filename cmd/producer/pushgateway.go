@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const pushgatewayJob = "rudder-load"
+
+// pushMetrics pushes the current state of the registry to the Pushgateway grouped by load run ID and instance.
+// It does not accept a context on purpose: it is meant to be called on exit when the run context might already be
+// canceled, so the push is bounded by the given timeout only.
+func pushMetrics(url string, reg prometheus.Gatherer, loadRunID, instance string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := push.New(url, pushgatewayJob).
+		Gatherer(reg).
+		Grouping("load_run_id", loadRunID).
+		Grouping("instance", instance).
+		PushContext(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot push metrics to %s: %w", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+
+	"rudder-load/internal/stats"
+)
+
+func TestPushMetrics(t *testing.T) {
+	var (
+		paths    = make(chan string, 1)
+		families = make(chan []string, 1)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var names []string
+		dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			var mf dto.MetricFamily
+			err := dec.Decode(&mf)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			names = append(names, mf.GetName())
+		}
+		paths <- r.Method + " " + r.URL.Path
+		families <- names
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	reg := prometheus.NewRegistry()
+	_, err := stats.NewFactory(reg, stats.Data{Prefix: metricsPrefix, Mode: "http"})
+	require.NoError(t, err)
+	throttled := prometheus.NewCounter(prometheus.CounterOpts{Name: metricsPrefix + "throttled"})
+	reg.MustRegister(throttled)
+	throttled.Inc()
+
+	err = pushMetrics(srv.URL, reg, "run-1", "rudder-load-http-0", time.Second)
+	require.NoError(t, err)
+
+	require.Equal(t, "PUT /metrics/job/rudder-load/load_run_id/run-1/instance/rudder-load-http-0", <-paths)
+	require.Subset(t, <-families, []string{
+		metricsPrefix + "throttled",
+		metricsPrefix + "publish_messages_total",
+		metricsPrefix + "publish_error_rate_total",
+	})
+}
+
+func TestPushMetricsTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	err := pushMetrics(srv.URL, prometheus.NewRegistry(), "run-1", "rudder-load-http-0", 10*time.Millisecond)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var queryParamsRegexp = regexp.MustCompile(`"([^"]*)"\s*:\s*(\d+)`)
+
+type queryParamsVariant struct {
+	Params string
+	Weight int
+}
+
+// parseQueryParamsVariants parses weighted query parameters variants like `"":70,"routing=fast":20,"routing=slow":10`
+func parseQueryParamsVariants(input string) ([]queryParamsVariant, error) {
+	if leftover := strings.Trim(queryParamsRegexp.ReplaceAllString(input, ""), ", "); leftover != "" {
+		return nil, fmt.Errorf("invalid query params variants %q: unexpected %q", input, leftover)
+	}
+
+	var (
+		total    = 0
+		matches  = queryParamsRegexp.FindAllStringSubmatch(input, -1)
+		variants = make([]queryParamsVariant, 0, len(matches))
+	)
+	for _, match := range matches {
+		if _, err := url.ParseQuery(match[1]); err != nil {
+			return nil, fmt.Errorf("invalid query params %q: %w", match[1], err)
+		}
+		weight, err := strconv.Atoi(match[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight for query params %q: %w", match[1], err)
+		}
+		total += weight
+		variants = append(variants, queryParamsVariant{Params: match[1], Weight: weight})
+	}
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("invalid query params variants %q: no variants found", input)
+	}
+	if total != 100 {
+		return nil, fmt.Errorf("query params variants weights should sum to 100: %d", total)
+	}
+	return variants, nil
+}
+
+func getQueryParamsConcentration(variants []queryParamsVariant) []string {
+	var (
+		startID                  = 0
+		queryParamsConcentration = make([]string, 100)
+	)
+	for _, variant := range variants {
+		for i := startID; i < variant.Weight+startID; i++ {
+			queryParamsConcentration[i] = variant.Params
+		}
+		startID += variant.Weight
+	}
+	return queryParamsConcentration
+}
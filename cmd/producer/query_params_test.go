@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQueryParamsVariants(t *testing.T) {
+	t.Run("weighted variants", func(t *testing.T) {
+		variants, err := parseQueryParamsVariants(`"":70,"routing=fast":20, "routing=slow&skipVerify=true":10`)
+		require.NoError(t, err)
+		require.Equal(t, []queryParamsVariant{
+			{Params: "", Weight: 70},
+			{Params: "routing=fast", Weight: 20},
+			{Params: "routing=slow&skipVerify=true", Weight: 10},
+		}, variants)
+
+		concentration := getQueryParamsConcentration(variants)
+		require.Len(t, concentration, 100)
+		count := make(map[string]int)
+		for _, params := range concentration {
+			count[params]++
+		}
+		require.Equal(t, map[string]int{
+			"":                             70,
+			"routing=fast":                 20,
+			"routing=slow&skipVerify=true": 10,
+		}, count)
+	})
+	t.Run("weights not summing to 100", func(t *testing.T) {
+		_, err := parseQueryParamsVariants(`"":70,"routing=fast":20`)
+		require.Error(t, err)
+	})
+	t.Run("malformed", func(t *testing.T) {
+		_, err := parseQueryParamsVariants(`routing=fast:100`)
+		require.Error(t, err)
+		_, err = parseQueryParamsVariants(`"routing=fast":90,"routing=slow"`)
+		require.Error(t, err)
+		_, err = parseQueryParamsVariants(`"routing=%zz":100`)
+		require.Error(t, err)
+	})
+}
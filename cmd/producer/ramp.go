@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type rampSegment struct {
+	EventsPerSecond int64
+	Duration        time.Duration
+}
+
+// parseRamp parses an events per second ramp like "1000:5m,5000:10m,2000:5m"
+func parseRamp(input string) ([]rampSegment, error) {
+	parts := strings.Split(input, ",")
+	segments := make([]rampSegment, 0, len(parts))
+	for _, part := range parts {
+		kv := strings.Split(strings.TrimSpace(part), ":")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid ramp segment %q: expected <events per second>:<duration>", part)
+		}
+		eventsPerSecond, err := strconv.ParseInt(kv[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid events per second in ramp segment %q: %w", part, err)
+		}
+		if eventsPerSecond < 1 {
+			return nil, fmt.Errorf("events per second in ramp segment %q has to be greater than zero", part)
+		}
+		duration, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration in ramp segment %q: %w", part, err)
+		}
+		if duration <= 0 {
+			return nil, fmt.Errorf("duration in ramp segment %q has to be greater than zero", part)
+		}
+		segments = append(segments, rampSegment{EventsPerSecond: eventsPerSecond, Duration: duration})
+	}
+	return segments, nil
+}
+
+// rampTarget returns the events per second target after the given elapsed time.
+// The first segment holds its target for its whole duration, every following segment linearly interpolates from
+// the previous target to its own. Once the ramp is over the last target is kept.
+func rampTarget(segments []rampSegment, elapsed time.Duration) int64 {
+	if elapsed < segments[0].Duration {
+		return segments[0].EventsPerSecond
+	}
+	elapsed -= segments[0].Duration
+	for i := 1; i < len(segments); i++ {
+		if elapsed < segments[i].Duration {
+			from, to := segments[i-1].EventsPerSecond, segments[i].EventsPerSecond
+			progress := float64(elapsed) / float64(segments[i].Duration)
+			return from + int64(float64(to-from)*progress)
+		}
+		elapsed -= segments[i].Duration
+	}
+	return segments[len(segments)-1].EventsPerSecond
+}
+
+// rampThrottler is a GCRA rate limiter whose rate changes over time, e.g. following an events per second ramp.
+// The in-memory GCRA from rudder-go-kit caches the limiter per key so it cannot change rate on the fly.
+// Like the throttler used with a constant rate, it allows a burst of up to one second worth of events.
+type rampThrottler struct {
+	rate   func(elapsed time.Duration) float64 // events per second since the first call
+	target prometheus.Gauge
+	now    func() time.Time
+
+	mu    sync.Mutex
+	start time.Time // set on the first call
+	tat   time.Time // theoretical arrival time
+}
+
+func newRampThrottler(segments []rampSegment, target prometheus.Gauge) *rampThrottler {
+	return newRateThrottler(func(elapsed time.Duration) float64 {
+		return float64(rampTarget(segments, elapsed))
+	}, target)
+}
+
+// newRateThrottler returns a throttler following the given rate, the target gauge is set to the current rate
+func newRateThrottler(rate func(elapsed time.Duration) float64, target prometheus.Gauge) *rampThrottler {
+	return &rampThrottler{
+		rate:   rate,
+		target: target,
+		now:    time.Now,
+	}
+}
+
+// AllowAfter returns whether the given number of events can be sent now, otherwise how long to wait before retrying
+func (t *rampThrottler) AllowAfter(cost int64) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if t.start.IsZero() {
+		t.start = now
+	}
+	eventsPerSecond := t.rate(now.Sub(t.start))
+	t.target.Set(eventsPerSecond)
+
+	tat := t.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(time.Duration(float64(cost) / eventsPerSecond * float64(time.Second)))
+	if allowAt := newTat.Add(-time.Second); now.Before(allowAt) {
+		return false, allowAt.Sub(now)
+	}
+	t.tat = newTat
+	return true, 0
+}
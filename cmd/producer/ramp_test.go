@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRamp(t *testing.T) {
+	segments, err := parseRamp("1000:5m, 5000:10m,2000:5m")
+	require.NoError(t, err)
+	require.Equal(t, []rampSegment{
+		{EventsPerSecond: 1000, Duration: 5 * time.Minute},
+		{EventsPerSecond: 5000, Duration: 10 * time.Minute},
+		{EventsPerSecond: 2000, Duration: 5 * time.Minute},
+	}, segments)
+
+	for _, input := range []string{"1000", "1000:5m:1", "abc:5m", "1000:abc", "0:5m", "1000:0s", "1000:5m,"} {
+		_, err := parseRamp(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestRampTarget(t *testing.T) {
+	segments := []rampSegment{
+		{EventsPerSecond: 1000, Duration: 5 * time.Minute},
+		{EventsPerSecond: 5000, Duration: 10 * time.Minute},
+		{EventsPerSecond: 2000, Duration: 5 * time.Minute},
+	}
+	require.EqualValues(t, 1000, rampTarget(segments, 0))
+	require.EqualValues(t, 1000, rampTarget(segments, 4*time.Minute))
+	require.EqualValues(t, 1000, rampTarget(segments, 5*time.Minute))
+	require.EqualValues(t, 3000, rampTarget(segments, 10*time.Minute))
+	require.EqualValues(t, 5000, rampTarget(segments, 15*time.Minute))
+	require.EqualValues(t, 3500, rampTarget(segments, 17*time.Minute+30*time.Second))
+	require.EqualValues(t, 2000, rampTarget(segments, 20*time.Minute))
+	require.EqualValues(t, 2000, rampTarget(segments, time.Hour), "the last target should be kept")
+
+	single := []rampSegment{{EventsPerSecond: 1000, Duration: time.Minute}}
+	require.EqualValues(t, 1000, rampTarget(single, 0))
+	require.EqualValues(t, 1000, rampTarget(single, time.Hour))
+}
+
+func TestRampThrottler(t *testing.T) {
+	now := time.Now()
+	target := prometheus.NewGauge(prometheus.GaugeOpts{Name: "target_events_per_second"})
+	throttler := newRampThrottler([]rampSegment{
+		{EventsPerSecond: 10, Duration: 10 * time.Second},
+		{EventsPerSecond: 100, Duration: 10 * time.Second},
+	}, target)
+	throttler.now = func() time.Time { return now }
+
+	// allowed up to one second worth of events at 10 events per second
+	for i := 0; i < 10; i++ {
+		allowed, _ := throttler.AllowAfter(1)
+		require.True(t, allowed, i)
+	}
+	allowed, after := throttler.AllowAfter(1)
+	require.False(t, allowed)
+	require.Equal(t, 100*time.Millisecond, after)
+
+	now = now.Add(after)
+	allowed, _ = throttler.AllowAfter(1)
+	require.True(t, allowed)
+
+	// in the middle of the second segment the target is 55 events per second
+	now = now.Add(15 * time.Second)
+	allowed, _ = throttler.AllowAfter(55)
+	require.True(t, allowed)
+	allowed, _ = throttler.AllowAfter(1)
+	require.False(t, allowed)
+
+	var m dto.Metric
+	require.NoError(t, target.Write(&m))
+	require.EqualValues(t, 55, m.GetGauge().GetValue())
+}
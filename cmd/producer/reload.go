@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/rudderlabs/rudder-go-kit/logger"
+)
+
+// hotSettings are the settings of the traffic distribution that can be reloaded without a restart
+type hotSettings struct {
+	UserGroups []int // HOT_USER_GROUPS
+	EventTypes []int // HOT_EVENT_TYPES
+	BatchSizes []int // HOT_BATCH_SIZES
+}
+
+// trafficDistribution holds the concentrations read by the generators on every message
+type trafficDistribution struct {
+	hot            hotSettings
+	userIDs        []func() string
+	eventTypeNames []string
+	eventTypes     []func(userID string, n int) ([]byte, int)
+}
+
+// trafficReloader swaps the traffic distribution with the one built from the hot settings in a config file
+// (see RELOAD_CONFIG_FILE). The settings missing from the file are left as they are.
+type trafficReloader struct {
+	path     string
+	validate func(hot hotSettings) error
+	build    func(hot hotSettings) *trafficDistribution // called only with valid settings
+	onReload func(hot hotSettings)                      // applies the settings not held by the distribution
+
+	mu      sync.Mutex // serializes the reloads
+	current atomic.Pointer[trafficDistribution]
+}
+
+// Load returns the current traffic distribution, it is safe to call from the generators
+func (r *trafficReloader) Load() *trafficDistribution {
+	return r.current.Load()
+}
+
+// Reload reads the config file and swaps the traffic distribution. Invalid settings are rejected and the current
+// distribution is kept. It returns the settings before and after the reload.
+func (r *trafficReloader) Reload() (hotSettings, hotSettings, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old := r.current.Load().hot
+	hot, err := readHotSettings(r.path, old)
+	if err != nil {
+		return old, old, err
+	}
+	if err := r.validate(hot); err != nil {
+		return old, old, fmt.Errorf("invalid settings in %s: %w", r.path, err)
+	}
+	if r.onReload != nil {
+		r.onReload(hot)
+	}
+	r.current.Store(r.build(hot))
+	return old, hot, nil
+}
+
+// readHotSettings reads the KEY=VALUE lines of the given file (e.g. "HOT_EVENT_TYPES=60,25,15"), blank lines and
+// lines starting with # are ignored. The keys missing from the file keep the current values.
+func readHotSettings(path string, current hotSettings) (hotSettings, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return current, fmt.Errorf("cannot open reload config file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var (
+		hot     = current
+		scanner = bufio.NewScanner(f)
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return current, fmt.Errorf("invalid line %q in %s: expected KEY=VALUE", line, path)
+		}
+		var setting *[]int
+		switch key = strings.TrimSpace(key); key {
+		case "HOT_USER_GROUPS":
+			setting = &hot.UserGroups
+		case "HOT_EVENT_TYPES":
+			setting = &hot.EventTypes
+		case "HOT_BATCH_SIZES":
+			setting = &hot.BatchSizes
+		default:
+			return current, fmt.Errorf("%s cannot be reloaded", key)
+		}
+		if *setting, err = parsePercentages(strings.Trim(strings.TrimSpace(value), `"`)); err != nil {
+			return current, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return current, fmt.Errorf("cannot read reload config file: %w", err)
+	}
+	return hot, nil
+}
+
+// validateHotSettings checks the settings against the ones that cannot be reloaded
+func validateHotSettings(hot hotSettings, totalUsers, eventTypes, batchSizes int) error {
+	if len(hot.UserGroups) < 1 || totalUsers%len(hot.UserGroups) != 0 {
+		return fmt.Errorf("total users should be a multiple of the number of hot user groups: %v", hot.UserGroups)
+	}
+	if len(hot.EventTypes) != eventTypes {
+		return fmt.Errorf("event types and hot event types should have the same length: %v", hot.EventTypes)
+	}
+	if len(hot.BatchSizes) != batchSizes {
+		return fmt.Errorf("batch sizes and hot batch sizes should have the same length: %v", hot.BatchSizes)
+	}
+	for _, setting := range []struct {
+		name        string
+		percentages []int
+	}{
+		{name: "hot user groups", percentages: hot.UserGroups},
+		{name: "hot event types", percentages: hot.EventTypes},
+		{name: "hot batch sizes", percentages: hot.BatchSizes},
+	} {
+		total := 0
+		for _, p := range setting.percentages {
+			total += p
+		}
+		if total != 100 {
+			return fmt.Errorf("%s should sum to 100: %v", setting.name, setting.percentages)
+		}
+	}
+	for _, p := range hot.UserGroups {
+		if p > 0 && totalUsers*p/100 == 0 {
+			return fmt.Errorf("hot user group of %d%% has no users out of %d", p, totalUsers)
+		}
+	}
+	return nil
+}
+
+// parsePercentages parses a comma separated list of non-negative numbers (e.g. "60,25,15")
+func parsePercentages(value string) ([]int, error) {
+	parts := strings.Split(value, ",")
+	percentages := make([]int, 0, len(parts))
+	for _, part := range parts {
+		p, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("invalid percentage %q", part)
+		}
+		percentages = append(percentages, p)
+	}
+	return percentages, nil
+}
+
+// reloadHandler reloads the traffic distribution on POST, it replies 503 until the reloader is set
+func reloadHandler(reloader *atomic.Pointer[trafficReloader], log logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		rl := reloader.Load()
+		if rl == nil {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		if err := reload(rl, log); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// reloadOnSignal reloads the traffic distribution on every SIGHUP until ctx is canceled
+func reloadOnSignal(ctx context.Context, rl *trafficReloader, log logger.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			_ = reload(rl, log)
+		}
+	}
+}
+
+func reload(rl *trafficReloader, log logger.Logger) error {
+	old, hot, err := rl.Reload()
+	if err != nil {
+		log.Errorn("Cannot reload the traffic distribution, keeping the current one", logger.NewErrorField(err))
+		return err
+	}
+	log.Infon("Traffic distribution reloaded",
+		logger.NewStringField("oldHotUserGroups", fmt.Sprint(old.UserGroups)),
+		logger.NewStringField("hotUserGroups", fmt.Sprint(hot.UserGroups)),
+		logger.NewStringField("oldHotEventTypes", fmt.Sprint(old.EventTypes)),
+		logger.NewStringField("hotEventTypes", fmt.Sprint(hot.EventTypes)),
+		logger.NewStringField("oldHotBatchSizes", fmt.Sprint(old.BatchSizes)),
+		logger.NewStringField("hotBatchSizes", fmt.Sprint(hot.BatchSizes)),
+	)
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rudderlabs/rudder-go-kit/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTrafficReloader(t *testing.T, content string) (*trafficReloader, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hot.env")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	userIDs := newUserIDs(1000, false)
+	eventTypes := []string{"track", "page"}
+	rl := &trafficReloader{
+		path: path,
+		validate: func(hot hotSettings) error {
+			return validateHotSettings(hot, 1000, len(eventTypes), 1)
+		},
+		build: func(hot hotSettings) *trafficDistribution {
+			d := &trafficDistribution{
+				hot:     hot,
+				userIDs: getUserIDsConcentrationOf(userIDs, hot.UserGroups),
+			}
+			for i, p := range hot.EventTypes {
+				for j := 0; j < p; j++ {
+					d.eventTypeNames = append(d.eventTypeNames, eventTypes[i])
+				}
+			}
+			return d
+		},
+	}
+	rl.current.Store(rl.build(hotSettings{
+		UserGroups: []int{100},
+		EventTypes: []int{100, 0},
+		BatchSizes: []int{100},
+	}))
+	return rl, path
+}
+
+func TestTrafficReloader(t *testing.T) {
+	t.Run("swaps the distribution", func(t *testing.T) {
+		var reloaded []int
+		rl, _ := newTestTrafficReloader(t, "# move all the traffic to pages\nHOT_EVENT_TYPES=0,100\n\nHOT_USER_GROUPS=\"50,50\"\n")
+		rl.onReload = func(hot hotSettings) { reloaded = hot.BatchSizes }
+		before := rl.Load()
+		for _, name := range before.eventTypeNames {
+			require.Equal(t, "track", name)
+		}
+
+		old, hot, err := rl.Reload()
+		require.NoError(t, err)
+		require.Equal(t, []int{100, 0}, old.EventTypes)
+		require.Equal(t, hotSettings{UserGroups: []int{50, 50}, EventTypes: []int{0, 100}, BatchSizes: []int{100}}, hot)
+		require.Equal(t, []int{100}, reloaded, "the settings missing from the file are kept")
+
+		after := rl.Load()
+		require.Len(t, after.eventTypeNames, 100)
+		for _, name := range after.eventTypeNames {
+			require.Equal(t, "page", name)
+		}
+		require.Equal(t, "track", before.eventTypeNames[0], "the previous distribution is left untouched")
+		for i := 0; i < 1000; i++ {
+			userID, err := strconv.Atoi(after.userIDs[0]())
+			require.NoError(t, err)
+			require.True(t, userID >= 0 && userID < 500, "the users are split in the new groups")
+		}
+	})
+
+	t.Run("keeps the distribution on invalid settings", func(t *testing.T) {
+		for content, expectedErr := range map[string]string{
+			"HOT_EVENT_TYPES=50,40":       "hot event types should sum to 100",
+			"HOT_EVENT_TYPES=100":         "event types and hot event types should have the same length",
+			"HOT_EVENT_TYPES=-10,110":     `invalid percentage "-10"`,
+			"HOT_USER_GROUPS=30,30,40":    "total users should be a multiple of the number of hot user groups",
+			"HOT_BATCH_SIZES=50,50":       "batch sizes and hot batch sizes should have the same length",
+			"MAX_EVENTS_PER_SECOND=1000":  "MAX_EVENTS_PER_SECOND cannot be reloaded",
+			"HOT_EVENT_TYPES":             "expected KEY=VALUE",
+			"HOT_EVENT_TYPES=fifty,fifty": `invalid percentage "fifty"`,
+		} {
+			rl, _ := newTestTrafficReloader(t, content)
+			before := rl.Load()
+			old, hot, err := rl.Reload()
+			require.ErrorContains(t, err, expectedErr, content)
+			require.Equal(t, old, hot)
+			require.Same(t, before, rl.Load(), content)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		rl, path := newTestTrafficReloader(t, "")
+		require.NoError(t, os.Remove(path))
+		_, _, err := rl.Reload()
+		require.Error(t, err)
+	})
+}
+
+func TestReloadHandler(t *testing.T) {
+	var reloader atomic.Pointer[trafficReloader]
+	srv := httptest.NewServer(reloadHandler(&reloader, logger.NOP))
+	t.Cleanup(srv.Close)
+
+	post := func() int {
+		resp, err := http.Post(srv.URL, "text/plain", nil)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusServiceUnavailable, post(), "the generators did not start yet")
+
+	rl, path := newTestTrafficReloader(t, "HOT_EVENT_TYPES=0,100")
+	reloader.Store(rl)
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	require.Equal(t, http.StatusOK, post())
+	require.Equal(t, []int{0, 100}, rl.Load().hot.EventTypes)
+
+	require.NoError(t, os.WriteFile(path, []byte("HOT_EVENT_TYPES=10,10"), 0o600))
+	require.Equal(t, http.StatusBadRequest, post())
+	require.Equal(t, []int{0, 100}, rl.Load().hot.EventTypes)
+}
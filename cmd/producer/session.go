@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	sessionEmitBatch      = "batch"      // the whole session is published as a single batch
+	sessionEmitSequential = "sequential" // every step of the session is published as its own message, in order
+
+	// sessionEventType is the event type of the messages holding a whole session
+	sessionEventType = "session"
+)
+
+func parseSessionEmit(v string) (string, error) {
+	switch v {
+	case sessionEmitBatch, sessionEmitSequential:
+		return v, nil
+	default:
+		return "", fmt.Errorf("session emit out of the known domain [%s,%s]: %s", sessionEmitBatch, sessionEmitSequential, v)
+	}
+}
+
+type sessionStep struct {
+	EventType string
+	Count     int
+}
+
+// parseSessionScript parses SESSION_SCRIPT which is an ordered list of event types, each one optionally repeated
+// (e.g. "identify,page,track*5")
+func parseSessionScript(input string) ([]sessionStep, error) {
+	parts := strings.Split(input, ",")
+	script := make([]sessionStep, 0, len(parts))
+	for _, part := range parts {
+		eventType, count, repeated := strings.Cut(strings.TrimSpace(part), "*")
+		if _, ok := eventGenerators[eventType]; !ok {
+			return nil, fmt.Errorf("unknown event type in session step %q", part)
+		}
+		step := sessionStep{EventType: eventType, Count: 1}
+		if repeated {
+			var err error
+			if step.Count, err = strconv.Atoi(count); err != nil || step.Count < 1 {
+				return nil, fmt.Errorf("invalid repetitions in session step %q: expected a number greater than zero", part)
+			}
+		}
+		script = append(script, step)
+	}
+	return script, nil
+}
+
+// sessionScheduler sits between the users concentration and the messages channel, turning every picked user
+// into a session: the events of the script in order, all of them with the same identity and context.sessionId.
+// The order is guaranteed within a batch, sequential messages are handed to the publishers in order but concurrent
+// slots can still publish them out of order.
+type sessionScheduler struct {
+	script     []sessionStep
+	emit       string
+	loadRunID  string
+	templates  map[string]*template.Template
+	identities identityResolver
+	timestamps func() time.Time
+}
+
+// Next returns the messages of a new session of the given user
+func (s *sessionScheduler) Next(userID string) ([]*message, error) {
+	sessionID, _ := json.Marshal(time.Now().UnixNano())
+
+	var (
+		messages   = make([]*message, 0, len(s.script))
+		msg        map[string]json.RawMessage
+		events     []json.RawMessage
+		noOfEvents int
+	)
+	for _, step := range s.script {
+		payload := eventGenerators[step.EventType](
+			s.templates[step.EventType], s.identities.Resolve(step.EventType, userID), s.loadRunID, step.Count, nil,
+			s.timestamps(),
+		)
+		stepMsg, stepEvents, err := decodeBatch(payload)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode %s payload: %w", step.EventType, err)
+		}
+		encoded := make([]json.RawMessage, len(stepEvents))
+		for i, event := range stepEvents {
+			if encoded[i], err = setSessionID(event, sessionID); err != nil {
+				return nil, fmt.Errorf("cannot set session id of %s event: %w", step.EventType, err)
+			}
+		}
+
+		if s.emit == sessionEmitBatch {
+			msg = stepMsg
+			events = append(events, encoded...)
+			noOfEvents += step.Count
+			continue
+		}
+		if payload, err = encodeBatch(stepMsg, encoded); err != nil {
+			return nil, err
+		}
+		messages = append(messages, &message{
+			Payload:    payload,
+			UserID:     userID,
+			EventType:  step.EventType,
+			NoOfEvents: int64(step.Count),
+		})
+	}
+
+	if s.emit == sessionEmitBatch {
+		payload, err := encodeBatch(msg, events)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, &message{
+			Payload:    payload,
+			UserID:     userID,
+			EventType:  sessionEventType,
+			NoOfEvents: int64(noOfEvents),
+		})
+	}
+	return messages, nil
+}
+
+// setSessionID returns the serialized event with the given context.sessionId
+func setSessionID(event map[string]json.RawMessage, sessionID json.RawMessage) (json.RawMessage, error) {
+	eventContext := make(map[string]json.RawMessage)
+	if raw, ok := event["context"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &eventContext); err != nil {
+			return nil, fmt.Errorf("invalid event context: %w", err)
+		}
+	}
+	eventContext["sessionId"] = sessionID
+	var err error
+	if event["context"], err = json.Marshal(eventContext); err != nil {
+		return nil, fmt.Errorf("cannot encode event context: %w", err)
+	}
+	return json.Marshal(event)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSessionScript(t *testing.T) {
+	script, err := parseSessionScript("identify, page,track*5")
+	require.NoError(t, err)
+	require.Equal(t, []sessionStep{
+		{EventType: "identify", Count: 1},
+		{EventType: "page", Count: 1},
+		{EventType: "track", Count: 5},
+	}, script)
+
+	for _, input := range []string{"", "identify,", "screen", "track*", "track*0", "track*x", "track*2*3"} {
+		_, err := parseSessionScript(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestSessionScheduler(t *testing.T) {
+	templates, err := getTemplates("./../../templates/")
+	require.NoError(t, err)
+
+	type sessionEvent struct {
+		Type        string `json:"type"`
+		UserID      string `json:"userId"`
+		AnonymousID string `json:"anonymousId"`
+		Context     struct {
+			SessionID int64 `json:"sessionId"`
+		} `json:"context"`
+	}
+	decode := func(t *testing.T, messages []*message) []sessionEvent {
+		var events []sessionEvent
+		for _, msg := range messages {
+			var payload struct {
+				Batch []sessionEvent `json:"batch"`
+			}
+			require.NoError(t, json.Unmarshal(msg.Payload, &payload))
+			require.Len(t, payload.Batch, int(msg.NoOfEvents))
+			events = append(events, payload.Batch...)
+		}
+		return events
+	}
+	newScheduler := func(emit string) *sessionScheduler {
+		script, err := parseSessionScript("identify,page,track*3")
+		require.NoError(t, err)
+		return &sessionScheduler{
+			script:     script,
+			emit:       emit,
+			loadRunID:  "load-run-id",
+			templates:  templates,
+			identities: identityResolver{mode: identityModeSplit},
+			timestamps: time.Now,
+		}
+	}
+	requireSession := func(t *testing.T, userID string, events []sessionEvent) int64 {
+		require.Len(t, events, 5)
+		types := make([]string, len(events))
+		for i, event := range events {
+			types[i] = event.Type
+			require.Equal(t, userID, event.UserID)
+			require.Equal(t, events[0].AnonymousID, event.AnonymousID)
+			require.NotEmpty(t, event.AnonymousID)
+			require.NotZero(t, event.Context.SessionID)
+			require.Equal(t, events[0].Context.SessionID, event.Context.SessionID, "session id should be stable")
+		}
+		require.Equal(t, []string{"identify", "page", "track", "track", "track"}, types)
+		return events[0].Context.SessionID
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		s := newScheduler(sessionEmitBatch)
+		messages, err := s.Next("user-1")
+		require.NoError(t, err)
+		require.Len(t, messages, 1)
+		require.Equal(t, sessionEventType, messages[0].EventType)
+		require.Equal(t, "user-1", messages[0].UserID)
+		require.EqualValues(t, 5, messages[0].NoOfEvents)
+		first := requireSession(t, "user-1", decode(t, messages))
+
+		messages, err = s.Next("user-1")
+		require.NoError(t, err)
+		require.NotEqual(t, first, requireSession(t, "user-1", decode(t, messages)), "every session should have its own id")
+	})
+	t.Run("sequential", func(t *testing.T) {
+		s := newScheduler(sessionEmitSequential)
+		messages, err := s.Next("user-2")
+		require.NoError(t, err)
+		require.Len(t, messages, 3)
+		for i, eventType := range []string{"identify", "page", "track"} {
+			require.Equal(t, eventType, messages[i].EventType)
+			require.Equal(t, "user-2", messages[i].UserID)
+		}
+		requireSession(t, "user-2", decode(t, messages))
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rudderlabs/rudder-go-kit/throttling"
+)
+
+// parseEventsPerSecondPerSource parses MAX_EVENTS_PER_SECOND_PER_SOURCE which is either a single number applied to
+// every source or a comma separated list aligned with the given write keys (zero means no limit)
+func parseEventsPerSecondPerSource(input string, writeKeys []string) (map[string]int64, error) {
+	values := strings.Split(input, ",")
+	if len(values) != 1 && len(values) != len(writeKeys) {
+		return nil, fmt.Errorf("expected 1 or %d values, got %d", len(writeKeys), len(values))
+	}
+
+	limits := make(map[string]int64, len(writeKeys))
+	for i, writeKey := range writeKeys {
+		v := values[0]
+		if len(values) > 1 {
+			v = values[i]
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid events per second for source %q: %w", writeKey, err)
+		}
+		if limit < 0 {
+			return nil, fmt.Errorf("events per second for source %q cannot be negative: %d", writeKey, limit)
+		}
+		limits[writeKey] = limit
+	}
+	return limits, nil
+}
+
+// sourceThrottlers rate limits every source independently, each with a burst of up to one second worth of events
+type sourceThrottlers struct {
+	limits   map[string]int64
+	limiters map[string]*throttling.Limiter
+}
+
+func newSourceThrottlers(limits map[string]int64) (*sourceThrottlers, error) {
+	t := &sourceThrottlers{
+		limits:   limits,
+		limiters: make(map[string]*throttling.Limiter, len(limits)),
+	}
+	for writeKey, limit := range limits {
+		if limit == 0 {
+			continue
+		}
+		l, err := throttling.New(throttling.WithInMemoryGCRA(limit))
+		if err != nil {
+			return nil, fmt.Errorf("cannot create throttler for source %q: %w", writeKey, err)
+		}
+		t.limiters[writeKey] = l
+	}
+	return t, nil
+}
+
+// AllowAfter returns whether the given number of events can be sent now for the given source,
+// otherwise how long to wait before retrying. Sources without a limit are always allowed.
+func (t *sourceThrottlers) AllowAfter(ctx context.Context, writeKey string, cost int64) (bool, time.Duration, error) {
+	l, ok := t.limiters[writeKey]
+	if !ok {
+		return true, 0, nil
+	}
+	allowed, after, _, err := l.AllowAfter(ctx, cost, t.limits[writeKey], 1, writeKey)
+	return allowed, after, err
+}
+
+// waitAllowed calls allowAfter until it allows the events, calling onThrottled and waiting for the returned
+// duration every time it does not. It returns false if ctx is canceled while waiting.
+func waitAllowed(ctx context.Context, allowAfter func() (bool, time.Duration, error), onThrottled func()) (bool, error) {
+	for {
+		allowed, after, err := allowAfter()
+		if err != nil {
+			return false, err
+		}
+		if allowed {
+			return true, nil
+		}
+		onThrottled()
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-time.After(after):
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rudderlabs/rudder-go-kit/throttling"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEventsPerSecondPerSource(t *testing.T) {
+	writeKeys := []string{"a", "b"}
+
+	limits, err := parseEventsPerSecondPerSource("100", writeKeys)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"a": 100, "b": 100}, limits)
+
+	limits, err = parseEventsPerSecondPerSource("2000, 500", writeKeys)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"a": 2000, "b": 500}, limits)
+
+	for _, input := range []string{"", "abc", "1,2,3", "100,-1"} {
+		_, err := parseEventsPerSecondPerSource(input, writeKeys)
+		require.Error(t, err, input)
+	}
+}
+
+func TestSourceThrottlers(t *testing.T) {
+	throttlers, err := newSourceThrottlers(map[string]int64{"a": 500, "b": 100, "unlimited": 0})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed = make(map[string]int)
+	)
+	for _, writeKey := range []string{"a", "b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				ok, after, err := throttlers.AllowAfter(ctx, writeKey, 1)
+				require.NoError(t, err)
+				if !ok {
+					select {
+					case <-ctx.Done():
+					case <-time.After(after):
+					}
+					continue
+				}
+				mu.Lock()
+				allowed[writeKey]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// both sources can burst up to one second worth of events on top of their rate
+	require.InDelta(t, 1000, allowed["a"], 100)
+	require.InDelta(t, 200, allowed["b"], 20)
+
+	for i := 0; i < 1000; i++ {
+		ok, _, err := throttlers.AllowAfter(context.Background(), "unlimited", 100)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+}
+
+func TestGlobalAndSourceThrottlers(t *testing.T) {
+	global, err := throttling.New(throttling.WithInMemoryGCRA(200))
+	require.NoError(t, err)
+	throttlers, err := newSourceThrottlers(map[string]int64{"a": 1000, "b": 50})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		allowed   = make(map[string]int)
+		throttled atomic.Int64
+	)
+	for _, writeKey := range []string{"a", "b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limits := []func() (bool, time.Duration, error){
+				func() (bool, time.Duration, error) {
+					ok, after, _, err := global.AllowAfter(ctx, 1, 200, 1, "key")
+					return ok, after, err
+				},
+				func() (bool, time.Duration, error) {
+					return throttlers.AllowAfter(ctx, writeKey, 1)
+				},
+			}
+			for ctx.Err() == nil {
+				ok := true
+				for _, allowAfter := range limits {
+					var err error
+					ok, err = waitAllowed(ctx, allowAfter, func() { throttled.Add(1) })
+					require.NoError(t, err)
+					if !ok {
+						break
+					}
+				}
+				if ok {
+					mu.Lock()
+					allowed[writeKey]++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the global limit caps the sources together, the per source limit still applies to "b"
+	require.InDelta(t, 400, allowed["a"]+allowed["b"], 40)
+	require.LessOrEqual(t, allowed["b"], 110)
+	require.Positive(t, throttled.Load())
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rudderlabs/rudder-go-kit/logger"
+)
+
+const probeMessage = `{"batch":[{"type":"track","event":"rudder_load_probe","anonymousId":"rudder-load-probe"}]}`
+
+const (
+	sourceAssignmentStrict = "strict" // every replica needs its own source
+	sourceAssignmentWrap   = "wrap"   // replicas beyond the number of sources wrap around and share them
+)
+
+const (
+	sourceAssignmentModeReplica = "replica" // every replica uses a single source (see SOURCE_ASSIGNMENT)
+	sourceAssignmentModePinned  = "pinned"  // every slot sticks to a source, slot N uses SOURCES[N % len(SOURCES)]
+)
+
+// pinnedWriteKey is used as the write_key metrics label when the sources are pinned to the slots, the messages
+// published per source are counted in published_messages_by_source_count
+const pinnedWriteKey = "pinned"
+
+func parseSourceAssignment(v string) (string, error) {
+	switch v {
+	case sourceAssignmentStrict, sourceAssignmentWrap:
+		return v, nil
+	default:
+		return "", fmt.Errorf("source assignment out of the known domain [%s,%s]: %s",
+			sourceAssignmentStrict, sourceAssignmentWrap, v,
+		)
+	}
+}
+
+func parseSourceAssignmentMode(v string) (string, error) {
+	switch v {
+	case sourceAssignmentModeReplica, sourceAssignmentModePinned:
+		return v, nil
+	default:
+		return "", fmt.Errorf("source assignment mode out of the known domain [%s,%s]: %s",
+			sourceAssignmentModeReplica, sourceAssignmentModePinned, v,
+		)
+	}
+}
+
+// assignSource returns the write key that the replica with the given instance number should use
+func assignSource(sources []string, instanceNumber int, assignment string) (string, error) {
+	if assignment == sourceAssignmentWrap {
+		return sources[instanceNumber%len(sources)], nil
+	}
+	if instanceNumber >= len(sources) {
+		return "", fmt.Errorf("instance number %d is greater than the number of sources %d, use SOURCE_ASSIGNMENT=%s to share them",
+			instanceNumber, len(sources), sourceAssignmentWrap,
+		)
+	}
+	return sources[instanceNumber], nil
+}
+
+// pinnedSource returns the write key that the given slot uses with SOURCE_ASSIGNMENT_MODE=pinned
+func pinnedSource(sources []string, slot int) string {
+	return sources[slot%len(sources)]
+}
+
+// parseSourceHeaders parses SOURCE_HEADERS, a JSON object with the extra HTTP headers of every write key
+// (e.g. {"write-key-1": {"X-Workspace-Id": "ws-a"}}). All the write keys have to be among the given ones.
+func parseSourceHeaders(input string, writeKeys []string) (map[string]map[string]string, error) {
+	var headers map[string]map[string]string
+	if err := json.Unmarshal([]byte(input), &headers); err != nil {
+		return nil, fmt.Errorf("invalid source headers: %w", err)
+	}
+	for writeKey, sourceHeaders := range headers {
+		if !slices.Contains(writeKeys, writeKey) {
+			return nil, fmt.Errorf("source headers for unknown write key %q", writeKey)
+		}
+		for name := range sourceHeaders {
+			if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+				return nil, fmt.Errorf("invalid header name %q for write key %q", name, writeKey)
+			}
+		}
+	}
+	return headers, nil
+}
+
+type sourceProber interface {
+	Probe(writeKey string, message []byte, timeout time.Duration) (int, error)
+}
+
+// validateSources sends one probe event per write key and returns the write keys that got a 401 or 403 (or an error)
+// together with the reason why they failed
+func validateSources(p sourceProber, sources []string, timeout time.Duration) map[string]string {
+	invalid := make(map[string]string)
+	for _, writeKey := range sources {
+		statusCode, err := p.Probe(writeKey, []byte(probeMessage), timeout)
+		switch {
+		case err != nil:
+			invalid[writeKey] = err.Error()
+		case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+			invalid[writeKey] = fmt.Sprintf("status code %d", statusCode)
+		}
+	}
+	return invalid
+}
+
+// preflightSources validates all the sources and returns the write key that the replica should use.
+// If any source is invalid an error is returned, unless dropInvalid is true: in that case the invalid sources
+// are removed and the write key is picked among the valid ones using the instance number.
+func preflightSources(log logger.Logger, p sourceProber, sources []string, instanceNumber int, dropInvalid bool, timeout time.Duration) (string, error) {
+	valid, err := preflightValidSources(log, p, sources, dropInvalid, timeout)
+	if err != nil {
+		return "", err
+	}
+	return valid[instanceNumber%len(valid)], nil
+}
+
+// preflightValidSources validates the sources like preflightSources and returns the valid ones
+func preflightValidSources(log logger.Logger, p sourceProber, sources []string, dropInvalid bool, timeout time.Duration) ([]string, error) {
+	invalid := validateSources(p, sources, timeout)
+	if len(invalid) == 0 {
+		return sources, nil
+	}
+
+	var report strings.Builder
+	for _, writeKey := range sources {
+		if reason, ok := invalid[writeKey]; ok {
+			report.WriteString(fmt.Sprintf("\n\t%s: %s", writeKey, reason))
+		}
+	}
+	if !dropInvalid {
+		return nil, fmt.Errorf("invalid sources:%s", report.String())
+	}
+	log.Warnn("Dropping invalid sources", logger.NewStringField("invalidSources", report.String()))
+
+	valid := make([]string, 0, len(sources)-len(invalid))
+	for _, writeKey := range sources {
+		if _, ok := invalid[writeKey]; !ok {
+			valid = append(valid, writeKey)
+		}
+	}
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("no valid sources left")
+	}
+	return valid, nil
+}
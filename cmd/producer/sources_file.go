@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// sourcesFileWriteKey is used as the write_key metrics label when the sources are read from SOURCES_FILE
+const sourcesFileWriteKey = "sources_file"
+
+type sourceConfig struct {
+	WriteKey string `json:"writeKey"`
+	Endpoint string `json:"endpoint"` // optional, defaults to HTTP_ENDPOINT
+	Weight   int    `json:"weight"`
+}
+
+// readSourcesFile reads a JSON array of sources like [{"writeKey":"xxx","endpoint":"https://...","weight":70}]
+func readSourcesFile(path string) ([]sourceConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read sources file: %w", err)
+	}
+	var sources []sourceConfig
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal sources file: %w", err)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("sources file has no sources")
+	}
+
+	totalWeight := 0
+	for i, source := range sources {
+		if source.WriteKey == "" {
+			return nil, fmt.Errorf("source %d has an empty write key", i)
+		}
+		if source.Endpoint != "" {
+			if _, err := url.ParseRequestURI(source.Endpoint); err != nil {
+				return nil, fmt.Errorf("source %d has an invalid endpoint: %w", i, err)
+			}
+		}
+		if source.Weight < 0 {
+			return nil, fmt.Errorf("source %d has a negative weight: %d", i, source.Weight)
+		}
+		totalWeight += source.Weight
+	}
+	if totalWeight != 100 {
+		return nil, fmt.Errorf("sources weights should sum to 100: %d", totalWeight)
+	}
+	return sources, nil
+}
+
+func getSourcesConcentration(sources []sourceConfig) []sourceConfig {
+	var (
+		startID              = 0
+		sourcesConcentration = make([]sourceConfig, 100)
+	)
+	for _, source := range sources {
+		for i := startID; i < source.Weight+startID; i++ {
+			sourcesConcentration[i] = source
+		}
+		startID += source.Weight
+	}
+	return sourcesConcentration
+}
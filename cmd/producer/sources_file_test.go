@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+
+	"rudder-load/internal/producer"
+)
+
+func TestReadSourcesFile(t *testing.T) {
+	writeSourcesFile := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "sources.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		sources, err := readSourcesFile(writeSourcesFile(t, `[
+			{"writeKey": "a", "endpoint": "http://dataplane-a/v1/batch", "weight": 70},
+			{"writeKey": "b", "weight": 30}
+		]`))
+		require.NoError(t, err)
+		require.Equal(t, []sourceConfig{
+			{WriteKey: "a", Endpoint: "http://dataplane-a/v1/batch", Weight: 70},
+			{WriteKey: "b", Weight: 30},
+		}, sources)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"not json":        `{`,
+			"empty":           `[]`,
+			"empty write key": `[{"writeKey": "", "weight": 100}]`,
+			"bad endpoint":    `[{"writeKey": "a", "endpoint": "not a url", "weight": 100}]`,
+			"weights not 100": `[{"writeKey": "a", "weight": 70}, {"writeKey": "b", "weight": 20}]`,
+			"negative weight": `[{"writeKey": "a", "weight": 110}, {"writeKey": "b", "weight": -10}]`,
+		} {
+			_, err := readSourcesFile(writeSourcesFile(t, content))
+			require.Error(t, err, name)
+		}
+	})
+	t.Run("missing file", func(t *testing.T) {
+		_, err := readSourcesFile(filepath.Join(t.TempDir(), "missing.json"))
+		require.Error(t, err)
+	})
+}
+
+func TestSourcesConcentrationEndpoints(t *testing.T) {
+	newServer := func(t *testing.T, writeKey string) (*atomic.Int64, string) {
+		var requests atomic.Int64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth, _, _ := r.BasicAuth()
+			if auth != writeKey {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			requests.Add(1)
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(srv.Close)
+		return &requests, srv.URL
+	}
+	requestsA, endpointA := newServer(t, "a")
+	requestsB, endpointB := newServer(t, "b")
+
+	p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=http://127.0.0.1:1"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+
+	sourcesConcentration := getSourcesConcentration([]sourceConfig{
+		{WriteKey: "a", Endpoint: endpointA, Weight: 80},
+		{WriteKey: "b", Endpoint: endpointB, Weight: 20},
+	})
+
+	const total = 1000
+	for i := 0; i < total; i++ {
+		source := sourcesConcentration[rand.Intn(100)]
+		_, err := p.PublishTo(context.Background(), "key", []byte("{}"), map[string]string{
+			"auth":     source.WriteKey,
+			"endpoint": source.Endpoint,
+		})
+		require.NoError(t, err)
+	}
+
+	require.EqualValues(t, total, requestsA.Load()+requestsB.Load())
+	require.InDelta(t, 0.8*total, requestsA.Load(), 0.1*total, fmt.Sprintf("a: %d, b: %d", requestsA.Load(), requestsB.Load()))
+}
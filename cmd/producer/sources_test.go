@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/rudderlabs/rudder-go-kit/logger"
+	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+
+	"rudder-load/internal/producer"
+)
+
+func TestPreflightSources(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeKey, _, _ := r.BasicAuth()
+		if writeKey != "valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+
+	t.Run("all valid", func(t *testing.T) {
+		writeKey, err := preflightSources(logger.NOP, p, []string{"valid", "valid"}, 1, false, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, "valid", writeKey)
+	})
+	t.Run("abort", func(t *testing.T) {
+		_, err := preflightSources(logger.NOP, p, []string{"valid", "invalid"}, 0, false, time.Second)
+		require.ErrorContains(t, err, "invalid: status code 401")
+	})
+	t.Run("drop", func(t *testing.T) {
+		writeKey, err := preflightSources(logger.NOP, p, []string{"valid", "invalid"}, 1, true, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, "valid", writeKey)
+	})
+	t.Run("drop all", func(t *testing.T) {
+		_, err := preflightSources(logger.NOP, p, []string{"invalid"}, 0, true, time.Second)
+		require.ErrorContains(t, err, "no valid sources left")
+	})
+	t.Run("valid sources", func(t *testing.T) {
+		valid, err := preflightValidSources(logger.NOP, p, []string{"invalid", "valid", "invalid"}, true, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, []string{"valid"}, valid)
+
+		_, err = preflightValidSources(logger.NOP, p, []string{"invalid", "valid"}, false, time.Second)
+		require.ErrorContains(t, err, "invalid: status code 401")
+	})
+	t.Run("unreachable", func(t *testing.T) {
+		p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=http://127.0.0.1:1"})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+
+		invalid := validateSources(p, []string{"valid"}, time.Second)
+		require.Contains(t, invalid, "valid")
+	})
+}
+
+func TestAssignSource(t *testing.T) {
+	sources := []string{"a", "b", "c"}
+
+	t.Run("strict", func(t *testing.T) {
+		for instanceNumber, expected := range sources {
+			writeKey, err := assignSource(sources, instanceNumber, sourceAssignmentStrict)
+			require.NoError(t, err)
+			require.Equal(t, expected, writeKey)
+		}
+		_, err := assignSource(sources, 3, sourceAssignmentStrict)
+		require.ErrorContains(t, err, "instance number 3 is greater than the number of sources 3")
+	})
+	t.Run("wrap", func(t *testing.T) {
+		for instanceNumber, expected := range []string{"a", "b", "c", "a", "b", "c", "a"} {
+			writeKey, err := assignSource(sources, instanceNumber, sourceAssignmentWrap)
+			require.NoError(t, err)
+			require.Equal(t, expected, writeKey)
+		}
+		writeKey, err := assignSource(sources, 100, sourceAssignmentWrap)
+		require.NoError(t, err)
+		require.Equal(t, "b", writeKey)
+	})
+	t.Run("pinned", func(t *testing.T) {
+		mode, err := parseSourceAssignmentMode(sourceAssignmentModePinned)
+		require.NoError(t, err)
+		require.Equal(t, sourceAssignmentModePinned, mode)
+
+		_, err = parseSourceAssignment(sourceAssignmentModePinned)
+		require.Error(t, err, "pinned is a SOURCE_ASSIGNMENT_MODE")
+
+		for slot, expected := range []string{"a", "b", "c", "a", "b", "c", "a"} {
+			for i := 0; i < 3; i++ {
+				require.Equal(t, expected, pinnedSource(sources, slot), "a slot always uses the same source")
+			}
+		}
+	})
+	t.Run("unknown", func(t *testing.T) {
+		_, err := parseSourceAssignment("random")
+		require.Error(t, err)
+		_, err = parseSourceAssignmentMode("random")
+		require.Error(t, err)
+	})
+}
+
+func TestIntegrationPinnedSources(t *testing.T) {
+	setenv := func(t *testing.T) {
+		setBaseEnv(t)
+		t.Setenv("MODE", "http")
+		t.Setenv("CONCURRENCY", "3")
+		t.Setenv("MAX_EVENTS_PER_SECOND", "300")
+		t.Setenv("SOURCES", "a,b,c")
+		t.Setenv("SOURCE_ASSIGNMENT_MODE", sourceAssignmentModePinned)
+	}
+
+	t.Run("every slot uses its source", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var (
+			mu        sync.Mutex
+			writeKeys = make(map[string]int)
+		)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeKey, _, _ := r.BasicAuth()
+			mu.Lock()
+			writeKeys[writeKey]++
+			if len(writeKeys) == 3 {
+				cancel()
+			}
+			mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(srv.Close)
+
+		setenv(t)
+		t.Setenv("HTTP_ENDPOINT", srv.URL)
+
+		require.Equal(t, 0, run(ctx, logger.NOP))
+		require.ErrorIs(t, ctx.Err(), context.Canceled, "all the sources should be used by the instance")
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.ElementsMatch(t, []string{"a", "b", "c"}, slices.Collect(maps.Keys(writeKeys)))
+	})
+
+	t.Run("metrics labels", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(srv.Close)
+
+		setenv(t)
+		t.Setenv("HTTP_ENDPOINT", srv.URL)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if exitCode := run(ctx, logger.NOP); exitCode != 0 {
+				t.Errorf("run exited with %d", exitCode)
+			}
+		}()
+
+		require.Eventually(t, func() bool {
+			resp, err := http.Get("http://localhost:9102/metrics")
+			if err != nil {
+				return false
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			var parser expfmt.TextParser
+			families, err := parser.TextToMetricFamilies(resp.Body)
+			if err != nil {
+				return false
+			}
+			mf, ok := families[metricsPrefix+"published_messages_by_source_count"]
+			if !ok {
+				return false
+			}
+			sources := make(map[string]string) // source label to write_key label
+			for _, m := range mf.GetMetric() {
+				labels := make(map[string]string)
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				sources[labels["source"]] = labels["write_key"]
+			}
+			return len(sources) == 3 && sources["a"] == pinnedWriteKey && sources["b"] == pinnedWriteKey &&
+				sources["c"] == pinnedWriteKey
+		}, 10*time.Second, 100*time.Millisecond, "the pinned sources should be counted with the same write_key label")
+
+		cancel()
+		<-done
+	})
+
+	t.Run("sources file", func(t *testing.T) {
+		sourcesFile := filepath.Join(t.TempDir(), "sources.json")
+		require.NoError(t, os.WriteFile(sourcesFile, []byte(`[{"writeKey":"a","weight":100}]`), 0o600))
+
+		setenv(t)
+		t.Setenv("HTTP_ENDPOINT", "http://127.0.0.1:1")
+		t.Setenv("SOURCES_FILE", sourcesFile)
+
+		require.Equal(t, 1, run(context.Background(), logger.NOP))
+	})
+}
+
+func TestParseSourceHeaders(t *testing.T) {
+	writeKeys := []string{"write-key-1", "write-key-2", "write-key-3"}
+
+	headers, err := parseSourceHeaders(
+		`{"write-key-1": {"X-Workspace-Id": "ws-a"}, "write-key-2": {"X-Workspace-Id": "ws-b", "X-Region": "eu"}}`,
+		writeKeys,
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[string]map[string]string{
+		"write-key-1": {"X-Workspace-Id": "ws-a"},
+		"write-key-2": {"X-Workspace-Id": "ws-b", "X-Region": "eu"},
+	}, headers)
+
+	for _, input := range []string{
+		``,
+		`not json`,
+		`{"write-key-1": "ws-a"}`,
+		`{"unknown": {"X-Workspace-Id": "ws-a"}}`,
+		`{"write-key-1": {"": "ws-a"}}`,
+		`{"write-key-1": {"X Workspace": "ws-a"}}`,
+	} {
+		_, err := parseSourceHeaders(input, writeKeys)
+		require.Error(t, err, input)
+	}
+}
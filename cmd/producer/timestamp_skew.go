@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// timestampSkew shifts the generated event timestamps (e.g. originalTimestamp, sentAt) by a random offset
+// drawn uniformly from the [from, to] range, relative to now
+type timestampSkew struct {
+	from, to time.Duration
+}
+
+// parseTimestampSkew parses a skew like "-72h..-1h", a single offset like "-24h" or an empty string (i.e. no skew)
+func parseTimestampSkew(input string) (timestampSkew, error) {
+	if input == "" {
+		return timestampSkew{}, nil
+	}
+	fromValue, toValue, isRange := strings.Cut(input, "..")
+	if !isRange {
+		toValue = fromValue
+	}
+	from, err := time.ParseDuration(strings.TrimSpace(fromValue))
+	if err != nil {
+		return timestampSkew{}, fmt.Errorf("invalid timestamp skew %q: %w", input, err)
+	}
+	to, err := time.ParseDuration(strings.TrimSpace(toValue))
+	if err != nil {
+		return timestampSkew{}, fmt.Errorf("invalid timestamp skew %q: %w", input, err)
+	}
+	if from > to {
+		return timestampSkew{}, fmt.Errorf("invalid timestamp skew %q: %s is after %s", input, from, to)
+	}
+	return timestampSkew{from: from, to: to}, nil
+}
+
+// Timestamp returns now shifted by a random offset in the skew range
+func (s timestampSkew) Timestamp() time.Time {
+	offset := s.from
+	if s.to > s.from {
+		offset += time.Duration(rand.Int63n(int64(s.to - s.from + 1)))
+	}
+	return time.Now().Add(offset)
+}
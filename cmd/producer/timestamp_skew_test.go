@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimestampSkew(t *testing.T) {
+	for input, expected := range map[string]timestampSkew{
+		"":            {},
+		"0":           {},
+		"-24h":        {from: -24 * time.Hour, to: -24 * time.Hour},
+		"-72h..-1h":   {from: -72 * time.Hour, to: -time.Hour},
+		"-1h30m..0s":  {from: -90 * time.Minute},
+		" -2h .. 1h ": {from: -2 * time.Hour, to: time.Hour},
+	} {
+		skew, err := parseTimestampSkew(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, skew, input)
+	}
+
+	for _, input := range []string{"yesterday", "-1h..", "..-1h", "-1h..-72h", "-1h..-2h..-3h"} {
+		_, err := parseTimestampSkew(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestTimestampSkew(t *testing.T) {
+	templates, err := getTemplates("./../../templates/")
+	require.NoError(t, err)
+
+	skew, err := parseTimestampSkew("-72h..-1h")
+	require.NoError(t, err)
+
+	eventTypes, err := parseEventTypes("track,page")
+	require.NoError(t, err)
+	concentration := getEventTypesConcentration("load-run-id", eventTypes, []int{50, 50}, eventGenerators, templates,
+		identityResolver{mode: identityModeSimple}, skew.Timestamp,
+	)
+
+	from := time.Now().Add(-72 * time.Hour).Add(-time.Second) // RFC3339 timestamps are truncated to the second
+	for i := 0; i < 100; i++ {
+		var batch struct {
+			Batch []map[string]any `json:"batch"`
+		}
+		payload, n := concentration[i]("user-1", 1)
+		require.Equal(t, 1, n)
+		require.NoError(t, json.Unmarshal(payload, &batch))
+		require.Len(t, batch.Batch, 1)
+
+		fields := []string{"originalTimestamp", "sentAt"}
+		if batch.Batch[0]["type"] == "track" {
+			fields = []string{"timestamp"}
+		}
+		to := time.Now().Add(-time.Hour)
+		for _, field := range fields {
+			ts, err := time.Parse(time.RFC3339, batch.Batch[0][field].(string))
+			require.NoError(t, err)
+			require.True(t, ts.After(from) && ts.Before(to), "%s %s is not in [%s, %s]", field, ts, from, to)
+		}
+	}
+
+	t.Run("no skew", func(t *testing.T) {
+		skew, err := parseTimestampSkew("")
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now(), skew.Timestamp(), time.Second)
+	})
+}
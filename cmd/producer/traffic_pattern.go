@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// trafficPatternSteady keeps the events per second constant (or following EVENTS_PER_SECOND_RAMP)
+	trafficPatternSteady = "steady"
+	// trafficPatternBursty makes the events per second oscillate around the target, see burstModulation
+	trafficPatternBursty = "bursty"
+
+	burstShapeSine   = "sine"
+	burstShapeSquare = "square"
+)
+
+func parseTrafficPattern(v string) (string, error) {
+	switch v {
+	case trafficPatternSteady, trafficPatternBursty:
+		return v, nil
+	default:
+		return "", fmt.Errorf("unknown traffic pattern %q: expected %s or %s", v, trafficPatternSteady, trafficPatternBursty)
+	}
+}
+
+// burstModulation makes the events per second oscillate around the target every period so that the peak is factor
+// times the trough (BURST_FACTOR) while the average over a whole period stays equal to the target
+type burstModulation struct {
+	shape     string
+	period    time.Duration
+	amplitude float64 // fraction of the target added at the peak and removed at the trough
+}
+
+func newBurstModulation(shape string, factor float64, period time.Duration) (*burstModulation, error) {
+	if shape != burstShapeSine && shape != burstShapeSquare {
+		return nil, fmt.Errorf("unknown burst shape %q: expected %s or %s", shape, burstShapeSine, burstShapeSquare)
+	}
+	if factor < 1 {
+		return nil, fmt.Errorf("burst factor has to be greater than or equal to one: %v", factor)
+	}
+	if period <= 0 {
+		return nil, fmt.Errorf("burst period has to be greater than zero: %s", period)
+	}
+	return &burstModulation{
+		shape:     shape,
+		period:    period,
+		amplitude: (factor - 1) / (factor + 1), // (1 + a) / (1 - a) = factor
+	}, nil
+}
+
+// Modulate returns the events per second after the given elapsed time for the given target
+func (b *burstModulation) Modulate(eventsPerSecond float64, elapsed time.Duration) float64 {
+	phase := float64(elapsed%b.period) / float64(b.period)
+	if b.shape == burstShapeSquare {
+		if phase < 0.5 {
+			return eventsPerSecond * (1 + b.amplitude)
+		}
+		return eventsPerSecond * (1 - b.amplitude)
+	}
+	return eventsPerSecond * (1 + b.amplitude*math.Sin(2*math.Pi*phase))
+}
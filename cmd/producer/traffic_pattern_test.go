@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrafficPattern(t *testing.T) {
+	for _, v := range []string{trafficPatternSteady, trafficPatternBursty} {
+		pattern, err := parseTrafficPattern(v)
+		require.NoError(t, err)
+		require.Equal(t, v, pattern)
+	}
+	_, err := parseTrafficPattern("spiky")
+	require.Error(t, err)
+}
+
+func TestBurstModulation(t *testing.T) {
+	const eventsPerSecond = 1000.0
+	period := time.Minute
+
+	average := func(b *burstModulation, from time.Duration) float64 {
+		const steps = 6000
+		var sum float64
+		for i := 0; i < steps; i++ {
+			sum += b.Modulate(eventsPerSecond, from+time.Duration(i)*period/steps)
+		}
+		return sum / steps
+	}
+
+	for _, shape := range []string{burstShapeSine, burstShapeSquare} {
+		t.Run(shape, func(t *testing.T) {
+			b, err := newBurstModulation(shape, 3, period)
+			require.NoError(t, err)
+
+			require.InDelta(t, eventsPerSecond, average(b, 0), 0.001*eventsPerSecond)
+			require.InDelta(t, eventsPerSecond, average(b, 7*time.Second), 0.001*eventsPerSecond, "any full period")
+			require.InDelta(t, eventsPerSecond, average(b, 3*period), 0.001*eventsPerSecond, "every period")
+
+			peak, trough := b.Modulate(eventsPerSecond, period/4), b.Modulate(eventsPerSecond, 3*period/4)
+			require.InDelta(t, 1500, peak, 0.001)
+			require.InDelta(t, 500, trough, 0.001)
+			require.InDelta(t, 3, peak/trough, 0.001, "the peak should be BURST_FACTOR times the trough")
+		})
+	}
+
+	t.Run("factor one is steady", func(t *testing.T) {
+		b, err := newBurstModulation(burstShapeSine, 1, period)
+		require.NoError(t, err)
+		for _, elapsed := range []time.Duration{0, period / 4, period / 2, 3 * period / 4} {
+			require.Equal(t, eventsPerSecond, b.Modulate(eventsPerSecond, elapsed))
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := newBurstModulation("triangle", 2, period)
+		require.Error(t, err)
+		_, err = newBurstModulation(burstShapeSine, 0.5, period)
+		require.Error(t, err)
+		_, err = newBurstModulation(burstShapeSine, 2, 0)
+		require.Error(t, err)
+	})
+}
+
+func TestBurstyThrottler(t *testing.T) {
+	const eventsPerSecond = 100
+	period := 10 * time.Second
+
+	for _, shape := range []string{burstShapeSine, burstShapeSquare} {
+		t.Run(shape, func(t *testing.T) {
+			b, err := newBurstModulation(shape, 4, period)
+			require.NoError(t, err)
+
+			now := time.Now()
+			target := prometheus.NewGauge(prometheus.GaugeOpts{Name: "target_events_per_second"})
+			throttler := newRateThrottler(func(elapsed time.Duration) float64 {
+				return b.Modulate(eventsPerSecond, elapsed)
+			}, target)
+			throttler.now = func() time.Time { return now }
+
+			// a publisher always ready to send, one event at a time
+			var (
+				start       = now
+				sent        int
+				maxRate     float64
+				minRate     = float64(eventsPerSecond)
+				targetValue = func() float64 {
+					var m dto.Metric
+					require.NoError(t, target.Write(&m))
+					return m.GetGauge().GetValue()
+				}
+			)
+			for now.Sub(start) < 5*period {
+				allowed, after := throttler.AllowAfter(1)
+				if allowed {
+					sent++
+				} else {
+					now = now.Add(after)
+				}
+				maxRate, minRate = max(maxRate, targetValue()), min(minRate, targetValue())
+			}
+
+			// the initial burst of up to one second worth of events is amortized over the periods
+			expected := float64(eventsPerSecond) * (5 * period).Seconds()
+			require.InDelta(t, expected, float64(sent), 0.05*expected)
+			require.InDelta(t, 160, maxRate, 1, "the target should peak at 1.6x")
+			require.InDelta(t, 40, minRate, 1, "the target should drop to 0.4x")
+		})
+	}
+}
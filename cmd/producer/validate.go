@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	validatePayloadsOff    = "false"
+	validatePayloadsOn     = "true"   // invalid payloads are dropped
+	validatePayloadsStrict = "strict" // the first invalid payload stops the run
+)
+
+const schemasExtension = ".schema.json"
+
+var errInvalidPayload = errors.New("invalid payload")
+
+func parseValidatePayloads(v string) (string, error) {
+	switch v {
+	case validatePayloadsOff, validatePayloadsOn, validatePayloadsStrict:
+		return v, nil
+	default:
+		return "", fmt.Errorf("validate payloads out of the known domain [%s,%s,%s]: %s",
+			validatePayloadsOff, validatePayloadsOn, validatePayloadsStrict, v,
+		)
+	}
+}
+
+// payloadSchema is the subset of JSON Schema that the events can be checked against: type, required, properties,
+// items, enum and minLength. The other keywords are ignored.
+type payloadSchema struct {
+	Type       string                    `json:"type"`
+	Required   []string                  `json:"required"`
+	Properties map[string]*payloadSchema `json:"properties"`
+	Items      *payloadSchema            `json:"items"`
+	Enum       []any                     `json:"enum"`
+	MinLength  int                       `json:"minLength"`
+}
+
+// loadSchemas loads the event schemas in the given directory, one per event type (e.g. track.schema.json)
+func loadSchemas(schemasPath string) (map[string]*payloadSchema, error) {
+	files, err := os.ReadDir(schemasPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read schemas directory: %w", err)
+	}
+
+	schemas := make(map[string]*payloadSchema)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), schemasExtension) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(schemasPath, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read schema file: %w", err)
+		}
+		var schema payloadSchema
+		if err := json.Unmarshal(data, &schema); err != nil {
+			return nil, fmt.Errorf("cannot parse schema file %s: %w", file.Name(), err)
+		}
+		if err := schema.check(); err != nil {
+			return nil, fmt.Errorf("invalid schema file %s: %w", file.Name(), err)
+		}
+		schemas[strings.TrimSuffix(file.Name(), schemasExtension)] = &schema
+	}
+	return schemas, nil
+}
+
+// validatePayload checks that the generated payload is valid JSON and, if there is a schema for the event type,
+// that every event of the batch matches it
+func validatePayload(eventType string, payload []byte, schemas map[string]*payloadSchema) error {
+	if !json.Valid(payload) {
+		return fmt.Errorf("%w for event type %s: %s", errInvalidPayload, eventType, payload)
+	}
+	schema, ok := schemas[eventType]
+	if !ok {
+		return nil
+	}
+
+	var batch struct {
+		Batch []any `json:"batch"`
+	}
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return fmt.Errorf("%w for event type %s: %v: %s", errInvalidPayload, eventType, err, payload)
+	}
+	for i, event := range batch.Batch {
+		if err := schema.validate(fmt.Sprintf("batch[%d]", i), event); err != nil {
+			return fmt.Errorf("%w for event type %s: %v: %s", errInvalidPayload, eventType, err, payload)
+		}
+	}
+	return nil
+}
+
+func (s *payloadSchema) check() error {
+	switch s.Type {
+	case "", "object", "array", "string", "number", "integer", "boolean", "null":
+	default:
+		return fmt.Errorf("unknown type %q", s.Type)
+	}
+	for name, property := range s.Properties {
+		if err := property.check(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check()
+	}
+	return nil
+}
+
+// validate checks the given value, decoded by encoding/json, against the schema. The path locates the value in the
+// payload for the error messages.
+func (s *payloadSchema) validate(path string, v any) error {
+	if s.Type != "" && !hasSchemaType(v, s.Type) {
+		return fmt.Errorf("%s is not of type %s", path, s.Type)
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		return fmt.Errorf("%s is not one of %v", path, s.Enum)
+	}
+	switch v := v.(type) {
+	case string:
+		if utf8.RuneCountInString(v) < s.MinLength {
+			return fmt.Errorf("%s is shorter than %d", path, s.MinLength)
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s has no %s", path, name)
+			}
+		}
+		for name, property := range s.Properties {
+			if value, ok := v[name]; ok {
+				if err := property.validate(path+"."+name, value); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hasSchemaType(v any, typ string) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		return typ == "object"
+	case []any:
+		return typ == "array"
+	case string:
+		return typ == "string"
+	case float64:
+		return typ == "number" || (typ == "integer" && v == float64(int64(v)))
+	case bool:
+		return typ == "boolean"
+	case nil:
+		return typ == "null"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePayload(t *testing.T) {
+	// the comma between the events is missing so the payload is invalid for batch sizes > 1
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "track"+templatesExtension), []byte(`{
+		"batch": [
+			{{range $i := loop $.NoOfEvents }}
+			{"type": "track", "userId": "{{$.UserID}}", "event": "{{$.Event}}"}
+			{{- end }}
+		]
+	}`), 0o600)
+	require.NoError(t, err)
+
+	templates, err := getTemplates(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, validatePayload("track", trackFunc(templates["track"], identity{UserID: "123"}, "456", 1, nil, time.Now()), nil))
+	err = validatePayload("track", trackFunc(templates["track"], identity{UserID: "123"}, "456", 2, nil, time.Now()), nil)
+	require.ErrorIs(t, err, errInvalidPayload)
+	require.ErrorContains(t, err, "event type track")
+
+	t.Run("schemas", func(t *testing.T) {
+		schemas, err := loadSchemas("testdata/schemas")
+		require.NoError(t, err)
+		require.Len(t, schemas, 2)
+
+		templates, err := getTemplates("./../../templates/")
+		require.NoError(t, err)
+
+		require.NoError(t, validatePayload("alias", aliasFunc(templates["alias"], identity{UserID: "123", AnonymousID: "789"}, "456", 2, nil, time.Now()), schemas))
+		for _, id := range []identity{{UserID: "123"}, {AnonymousID: "789"}} {
+			err := validatePayload("alias", aliasFunc(templates["alias"], id, "456", 2, nil, time.Now()), schemas)
+			require.ErrorIs(t, err, errInvalidPayload)
+			require.ErrorContains(t, err, "batch[0].")
+		}
+
+		for payload, expectedErr := range map[string]string{
+			`{"batch":[{"type":"track","userId":"123","event":"e"},{"type":"track","userId":"123"}]}`: "batch[1] has no event",
+			`{"batch":[{"type":"track","userId":"123","event":1}]}`:                                   "batch[0].event is not of type string",
+			`{"batch":[{"type":"page","userId":"123","event":"e"}]}`:                                  "batch[0].type is not one of [track]",
+		} {
+			err := validatePayload("track", []byte(payload), schemas)
+			require.ErrorIs(t, err, errInvalidPayload)
+			require.ErrorContains(t, err, expectedErr)
+		}
+		require.NoError(t, validatePayload("page", []byte(`{"batch":[{"type":"page"}]}`), schemas),
+			"event types without a schema are only checked to be valid JSON",
+		)
+	})
+
+	t.Run("default templates", func(t *testing.T) {
+		templates, err := getTemplates("./../../templates/")
+		require.NoError(t, err)
+		for name, generator := range eventGenerators {
+			for _, n := range []int{1, 2, 10} {
+				require.NoError(t, validatePayload(name, generator(templates[name], identity{UserID: "123", AnonymousID: "789"}, "456", n, nil, time.Now()), nil))
+			}
+		}
+	})
+}
+
+func TestLoadSchemas(t *testing.T) {
+	for name, schema := range map[string]string{
+		"not json":     `{"type":`,
+		"unknown type": `{"type": "object", "properties": {"event": {"type": "text"}}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "track"+schemasExtension), []byte(schema), 0o600))
+			_, err := loadSchemas(dir)
+			require.Error(t, err)
+		})
+	}
+	t.Run("missing directory", func(t *testing.T) {
+		_, err := loadSchemas(filepath.Join(t.TempDir(), "schemas"))
+		require.Error(t, err)
+	})
+}
+
+func TestParseValidatePayloads(t *testing.T) {
+	for _, v := range []string{"false", "true", "strict"} {
+		mode, err := parseValidatePayloads(v)
+		require.NoError(t, err)
+		require.Equal(t, v, mode)
+	}
+	_, err := parseValidatePayloads("yes")
+	require.Error(t, err)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// reservedIPv4Ranges are the IPv4 ranges that should never show up as a client IP in the X-Forwarded-For header
+// (private, loopback, link-local, documentation, multicast, etc...)
+var reservedIPv4Ranges = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.88.99.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+)
+
+// maxXFFAttempts is the number of random draws per IP before giving up on a CIDR that is (almost) fully reserved
+const maxXFFAttempts = 1000
+
+type xffIP struct {
+	IP     string
+	Bucket string // first octet of the IP, used as a bounded metric label (i.e. /8 bucket)
+}
+
+type xffPool struct {
+	ips    []xffIP
+	sticky bool
+}
+
+// newXFFPool generates a pool of "size" public-looking IPv4 addresses.
+// If cidrs is empty the addresses are drawn from the whole IPv4 space, otherwise only from the given CIDRs.
+// If sticky is true then the same userID will always get the same IP.
+func newXFFPool(size int, cidrs []string, sticky bool) (*xffPool, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("xff pool size has to be greater than zero: %d", size)
+	}
+
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid xff cidr %q: %w", cidr, err)
+		}
+		if n.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid xff cidr %q: only IPv4 is supported", cidr)
+		}
+		networks = append(networks, n)
+	}
+	if len(networks) == 0 {
+		networks = mustParseCIDRs("0.0.0.0/0")
+	}
+
+	ips := make([]xffIP, size)
+	for i := range ips {
+		ip, err := randomPublicIP(networks[rand.Intn(len(networks))])
+		if err != nil {
+			return nil, err
+		}
+		ips[i] = xffIP{
+			IP:     ip.String(),
+			Bucket: strconv.Itoa(int(ip[0])),
+		}
+	}
+
+	return &xffPool{ips: ips, sticky: sticky}, nil
+}
+
+// Get returns an IP from the pool for the given userID
+func (p *xffPool) Get(userID string) xffIP {
+	if !p.sticky {
+		return p.ips[rand.Intn(len(p.ips))]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(userID))
+	return p.ips[h.Sum32()%uint32(len(p.ips))]
+}
+
+func randomPublicIP(n *net.IPNet) (net.IP, error) {
+	base := binary.BigEndian.Uint32(n.IP.To4())
+	mask := binary.BigEndian.Uint32(net.IP(n.Mask).To4())
+	for i := 0; i < maxXFFAttempts; i++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, (base&mask)|(rand.Uint32()&^mask))
+		if !isReservedIP(ip) {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot find a public IP in %s", n.String())
+}
+
+func isReservedIP(ip net.IP) bool {
+	if ip.Equal(net.IPv4bcast) {
+		return true
+	}
+	for _, r := range reservedIPv4Ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Errorf("invalid cidr %q: %w", cidr, err))
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
+
+type clientIPPool struct {
+	Network *net.IPNet
+	Weight  int
+}
+
+// parseClientIPPools parses CLIENT_IP_POOLS, a list of weighted IPv4 CIDRs like "10.0.0.0/16:50,203.0.113.0/24:50"
+// whose weights should sum to 100
+func parseClientIPPools(input string) ([]clientIPPool, error) {
+	var (
+		total = 0
+		parts = strings.Split(input, ",")
+		pools = make([]clientIPPool, 0, len(parts))
+	)
+	for _, part := range parts {
+		cidr, weightValue, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid client ip pool %q: expected <cidr>:<weight>", part)
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client ip pool cidr %q: %w", cidr, err)
+		}
+		if n.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid client ip pool cidr %q: only IPv4 is supported", cidr)
+		}
+		weight, err := strconv.Atoi(weightValue)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight for client ip pool %q", part)
+		}
+		total += weight
+		pools = append(pools, clientIPPool{Network: n, Weight: weight})
+	}
+	if total != 100 {
+		return nil, fmt.Errorf("client ip pools weights should sum to 100: %d", total)
+	}
+	return pools, nil
+}
+
+// clientIPs assigns every user an IP drawn from the weighted pools.
+// Both the pool and the IP are derived from the userID so the same user always comes from the same IP, whatever the
+// event type. Unlike the X-Forwarded-For pool the IPs can be private, since the CIDRs are chosen explicitly.
+type clientIPs struct {
+	concentration []*net.IPNet // the pool of every percentage
+}
+
+func newClientIPs(pools []clientIPPool) *clientIPs {
+	c := &clientIPs{concentration: make([]*net.IPNet, 100)}
+	startID := 0
+	for _, pool := range pools {
+		for i := startID; i < pool.Weight+startID; i++ {
+			c.concentration[i] = pool.Network
+		}
+		startID += pool.Weight
+	}
+	return c
+}
+
+// Get returns the IP of the given userID
+func (c *clientIPs) Get(userID string) xffIP {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(userID))
+	sum := h.Sum64()
+
+	n := c.concentration[sum%100]
+	base := binary.BigEndian.Uint32(n.IP.To4())
+	mask := binary.BigEndian.Uint32(net.IP(n.Mask).To4())
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, (base&mask)|(uint32(sum>>32)&^mask))
+	return xffIP{
+		IP:     ip.String(),
+		Bucket: strconv.Itoa(int(ip[0])),
+	}
+}
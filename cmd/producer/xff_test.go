@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+
+	"rudder-load/internal/producer"
+)
+
+func TestXFFPool(t *testing.T) {
+	t.Run("public IPs only", func(t *testing.T) {
+		pool, err := newXFFPool(10000, nil, false)
+		require.NoError(t, err)
+		require.Len(t, pool.ips, 10000)
+
+		for _, xff := range pool.ips {
+			ip := net.ParseIP(xff.IP)
+			require.NotNil(t, ip, xff.IP)
+			require.NotNil(t, ip.To4(), xff.IP)
+			require.False(t, isReservedIP(ip), xff.IP)
+			require.Equal(t, strconv.Itoa(int(ip.To4()[0])), xff.Bucket)
+		}
+	})
+	t.Run("cidrs", func(t *testing.T) {
+		pool, err := newXFFPool(1000, []string{"8.8.0.0/16", " 1.1.1.0/24"}, false)
+		require.NoError(t, err)
+
+		allowed := mustParseCIDRs("8.8.0.0/16", "1.1.1.0/24")
+		for _, xff := range pool.ips {
+			ip := net.ParseIP(xff.IP)
+			require.True(t, allowed[0].Contains(ip) || allowed[1].Contains(ip), xff.IP)
+		}
+	})
+	t.Run("reserved cidr", func(t *testing.T) {
+		_, err := newXFFPool(10, []string{"192.168.0.0/16"}, false)
+		require.Error(t, err)
+	})
+	t.Run("invalid cidr", func(t *testing.T) {
+		_, err := newXFFPool(10, []string{"not-a-cidr"}, false)
+		require.Error(t, err)
+		_, err = newXFFPool(10, []string{"2001:db8::/32"}, false)
+		require.Error(t, err)
+	})
+	t.Run("invalid size", func(t *testing.T) {
+		_, err := newXFFPool(0, nil, false)
+		require.Error(t, err)
+	})
+	t.Run("sticky", func(t *testing.T) {
+		pool, err := newXFFPool(1000, nil, true)
+		require.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			userID := strconv.Itoa(i)
+			expected := pool.Get(userID)
+			for j := 0; j < 10; j++ {
+				require.Equal(t, expected, pool.Get(userID))
+			}
+		}
+	})
+}
+
+func TestXFFHeader(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.Header.Get("X-Forwarded-For"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+
+	pool, err := newXFFPool(10, nil, false)
+	require.NoError(t, err)
+	poolIPs := make(map[string]bool, len(pool.ips))
+	for _, xff := range pool.ips {
+		poolIPs[xff.IP] = true
+	}
+
+	const requests = 100
+	for i := 0; i < requests; i++ {
+		xff := pool.Get(strconv.Itoa(i))
+		_, err := p.PublishTo(context.Background(), "key", []byte("{}"), map[string]string{"x_forwarded_for": xff.IP})
+		require.NoError(t, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, requests)
+	for _, ip := range received {
+		require.True(t, poolIPs[ip], "%q is not one of the pool IPs", ip)
+	}
+}
+
+func TestClientIPs(t *testing.T) {
+	t.Run("parse", func(t *testing.T) {
+		pools, err := parseClientIPPools("10.0.0.0/16:50, 203.0.113.0/24:50")
+		require.NoError(t, err)
+		require.Len(t, pools, 2)
+		require.Equal(t, "10.0.0.0/16", pools[0].Network.String())
+		require.Equal(t, 50, pools[0].Weight)
+		require.Equal(t, "203.0.113.0/24", pools[1].Network.String())
+		require.Equal(t, 50, pools[1].Weight)
+
+		for _, input := range []string{
+			"", "10.0.0.0/16", "10.0.0.0/16:100,", "10.0.0.0/33:100", "not-a-cidr:100", "2001:db8::/32:100",
+			"10.0.0.0/16:x", "10.0.0.0/16:50,10.1.0.0/16:40", "10.0.0.0/16:150,10.1.0.0/16:-50",
+		} {
+			_, err := parseClientIPPools(input)
+			require.Error(t, err, input)
+		}
+	})
+	t.Run("pool membership and stability", func(t *testing.T) {
+		pools, err := parseClientIPPools("10.0.0.0/16:70,203.0.113.0/24:30")
+		require.NoError(t, err)
+		ips := newClientIPs(pools)
+
+		const users = 10000
+		perPool := make(map[string]int)
+		for i := 0; i < users; i++ {
+			userID := strconv.Itoa(i)
+			xff := ips.Get(userID)
+			ip := net.ParseIP(xff.IP)
+			require.NotNil(t, ip, xff.IP)
+			require.Equal(t, strconv.Itoa(int(ip.To4()[0])), xff.Bucket)
+			switch {
+			case pools[0].Network.Contains(ip):
+				perPool[pools[0].Network.String()]++
+			case pools[1].Network.Contains(ip):
+				perPool[pools[1].Network.String()]++
+			default:
+				t.Fatalf("IP %s of user %s is not in the pools", xff.IP, userID)
+			}
+			for j := 0; j < 3; j++ {
+				require.Equal(t, xff, ips.Get(userID), "the same user should always get the same IP")
+			}
+		}
+		require.InDelta(t, 0.7, float64(perPool["10.0.0.0/16"])/users, 0.05)
+		require.InDelta(t, 0.3, float64(perPool["203.0.113.0/24"])/users, 0.05)
+	})
+	t.Run("single IP", func(t *testing.T) {
+		pools, err := parseClientIPPools("8.8.8.8/32:100")
+		require.NoError(t, err)
+		ips := newClientIPs(pools)
+		for i := 0; i < 10; i++ {
+			require.Equal(t, "8.8.8.8", ips.Get(strconv.Itoa(i)).IP)
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package producer
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type endpoint struct {
+	url                 string
+	healthy             bool
+	consecutiveFailures int
+	unhealthyUntil      time.Time
+}
+
+// endpointPool round-robins the requests across the healthy endpoints.
+// An endpoint is marked unhealthy for a backoff window after a number of consecutive failures, after that it is
+// tried again and it goes back to unhealthy at the first failure. If all the endpoints are unhealthy they are
+// used anyway in a round-robin fashion.
+type endpointPool struct {
+	failureThreshold int
+	unhealthyBackoff time.Duration
+	now              func() time.Time
+
+	onRequest func(endpoint string, failed bool)
+	onHealth  func(endpoint string, healthy bool)
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	cursor    int
+}
+
+func newEndpointPool(urls []string, failureThreshold int, unhealthyBackoff time.Duration) *endpointPool {
+	endpoints := make([]*endpoint, 0, len(urls))
+	for _, url := range urls {
+		endpoints = append(endpoints, &endpoint{url: url, healthy: true})
+	}
+	return &endpointPool{
+		failureThreshold: failureThreshold,
+		unhealthyBackoff: unhealthyBackoff,
+		now:              time.Now,
+		endpoints:        endpoints,
+	}
+}
+
+// Len returns the number of endpoints
+func (p *endpointPool) Len() int {
+	return len(p.endpoints)
+}
+
+// Next returns the next endpoint that is not within its unhealthy backoff window
+func (p *endpointPool) Next() *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	for i := 0; i < len(p.endpoints); i++ {
+		e := p.endpoints[(p.cursor+i)%len(p.endpoints)]
+		if !now.Before(e.unhealthyUntil) {
+			p.cursor = (p.cursor + i + 1) % len(p.endpoints)
+			return e
+		}
+	}
+	e := p.endpoints[p.cursor]
+	p.cursor = (p.cursor + 1) % len(p.endpoints)
+	return e
+}
+
+// Report records the outcome of a request sent to the given endpoint
+func (p *endpointPool) Report(e *endpoint, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.onRequest != nil {
+		p.onRequest(e.url, failed)
+	}
+	if !failed {
+		e.consecutiveFailures = 0
+		p.setHealthy(e, true)
+		return
+	}
+	e.consecutiveFailures++
+	if e.consecutiveFailures >= p.failureThreshold {
+		e.unhealthyUntil = p.now().Add(p.unhealthyBackoff)
+		p.setHealthy(e, false)
+	}
+}
+
+func (p *endpointPool) setHealthy(e *endpoint, healthy bool) {
+	if e.healthy == healthy {
+		return
+	}
+	e.healthy = healthy
+	if p.onHealth != nil {
+		p.onHealth(e.url, healthy)
+	}
+}
+
+// isEndpointFailure returns true if the error is about the endpoint itself (i.e. network errors and 5xx responses)
+// rather than about the request, in which case the request should be sent to another endpoint
+func isEndpointFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	var validationErr *ResponseValidationError
+	if errors.As(err, &validationErr) { // the endpoint replied, the message is not sent to the next one
+		return false
+	}
+	return true
+}
@@ -47,6 +47,18 @@ func getOptionalBoolSetting(m map[string]string, setting string, defaultValue bo
 	return b, nil
 }
 
+func getOptionalFloatSetting(m map[string]string, setting string, defaultValue float64) (float64, error) {
+	v, ok := m[setting]
+	if v == "" || !ok {
+		return defaultValue, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid float for setting %q: %v", setting, err)
+	}
+	return f, nil
+}
+
 func getOptionalDurationSetting(m map[string]string, setting string, defaultValue time.Duration) (time.Duration, error) {
 	v, ok := m[setting]
 	if v == "" || !ok {
@@ -65,7 +77,7 @@ func readConfiguration(prefix string, environ []string) (map[string]string, erro
 		if strings.Index(v, prefix) != 0 {
 			continue
 		}
-		kv := strings.Split(v, "=")
+		kv := strings.SplitN(v, "=", 2)
 		if len(kv) != 2 {
 			return nil, fmt.Errorf("invalid pulsar config %q", v)
 		}
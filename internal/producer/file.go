@@ -0,0 +1,204 @@
+package producer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// FileFormatNDJSON writes every message as a JSON object on its own line
+	FileFormatNDJSON = "ndjson"
+	// FileFormatLengthPrefixed writes every message as its key, write key and payload, each one prefixed by its
+	// length as a big endian uint32
+	FileFormatLengthPrefixed = "length_prefixed"
+)
+
+// FileRecord is a message written by the FilePublisher
+type FileRecord struct {
+	Key      string          `json:"key"`
+	WriteKey string          `json:"writeKey"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// FilePublisher writes the messages to files in FILE_OUTPUT_PATH instead of sending them, so that the traffic of
+// a run can be inspected or replayed. The files are named after the publisher name and moved to a new one
+// once FILE_ROTATE_SIZE is reached.
+type FilePublisher struct {
+	dir           string
+	name          string
+	format        string
+	rotateSize    int64 // zero means no rotation
+	fsyncInterval time.Duration
+
+	mu       sync.Mutex // avoids interleaving concurrent messages
+	seq      int
+	file     *os.File
+	w        *bufio.Writer
+	size     int64
+	lastSync time.Time
+}
+
+// NewFilePublisher returns a publisher writing to files named after name, e.g. the hostname or the slot
+func NewFilePublisher(name string, environ []string) (*FilePublisher, error) {
+	conf, err := readConfiguration("FILE_", environ)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read file configuration: %v", err)
+	}
+	dir, err := getRequiredStringSetting(conf, "output_path")
+	if err != nil {
+		return nil, err
+	}
+	format, err := getOptionalStringSetting(conf, "format", FileFormatNDJSON)
+	if err != nil {
+		return nil, err
+	}
+	if format != FileFormatNDJSON && format != FileFormatLengthPrefixed {
+		return nil, fmt.Errorf("unknown file format %q: expected %s or %s", format, FileFormatNDJSON, FileFormatLengthPrefixed)
+	}
+	rotateSize, err := getOptionalIntSetting(conf, "rotate_size", 0)
+	if err != nil {
+		return nil, err
+	}
+	if rotateSize < 0 {
+		return nil, fmt.Errorf("rotate size cannot be negative: %d", rotateSize)
+	}
+	fsyncInterval, err := getOptionalDurationSetting(conf, "fsync_interval", 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create output path %q: %w", dir, err)
+	}
+
+	p := &FilePublisher{
+		dir:           dir,
+		name:          name,
+		format:        format,
+		rotateSize:    rotateSize,
+		fsyncInterval: fsyncInterval,
+	}
+	if err := p.open(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// PublishTo appends the message to the current file, it returns the number of bytes written
+func (p *FilePublisher) PublishTo(_ context.Context, key string, message []byte, extra map[string]string) (int, error) {
+	record, err := p.encode(key, extra["auth"], message)
+	if err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.file == nil {
+		return 0, fmt.Errorf("file publisher is closed")
+	}
+	if p.rotateSize > 0 && p.size > 0 && p.size+int64(len(record)) > p.rotateSize {
+		if err := p.closeFile(); err != nil {
+			return 0, err
+		}
+		if err := p.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := p.w.Write(record)
+	p.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("cannot write to %q: %w", p.file.Name(), err)
+	}
+	if p.fsyncInterval > 0 && time.Since(p.lastSync) >= p.fsyncInterval {
+		if err := p.sync(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close flushes and syncs the current file
+func (p *FilePublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return nil
+	}
+	return p.closeFile()
+}
+
+func (p *FilePublisher) encode(key, writeKey string, message []byte) ([]byte, error) {
+	if p.format == FileFormatLengthPrefixed {
+		record := make([]byte, 0, 12+len(key)+len(writeKey)+len(message))
+		for _, field := range [][]byte{[]byte(key), []byte(writeKey), message} {
+			record = binary.BigEndian.AppendUint32(record, uint32(len(field)))
+			record = append(record, field...)
+		}
+		return record, nil
+	}
+
+	payload := json.RawMessage(message)
+	if !json.Valid(message) { // not JSON, written as a string
+		quoted, err := json.Marshal(string(message))
+		if err != nil {
+			return nil, err
+		}
+		payload = quoted
+	}
+	record, err := json.Marshal(FileRecord{Key: key, WriteKey: writeKey, Payload: payload})
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode record: %w", err)
+	}
+	return append(record, '\n'), nil
+}
+
+// open creates the next file, skipping the ones already there (e.g. written before a restart) so that they are
+// never overwritten
+func (p *FilePublisher) open() error {
+	extension := "ndjson"
+	if p.format == FileFormatLengthPrefixed {
+		extension = "bin"
+	}
+	for {
+		p.seq++
+		path := filepath.Join(p.dir, fmt.Sprintf("%s-%d.%s", p.name, p.seq, extension))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot create %q: %w", path, err)
+		}
+		p.file, p.w, p.size, p.lastSync = f, bufio.NewWriter(f), 0, time.Now()
+		return nil
+	}
+}
+
+func (p *FilePublisher) sync() error {
+	if err := p.w.Flush(); err != nil {
+		return fmt.Errorf("cannot flush %q: %w", p.file.Name(), err)
+	}
+	if err := p.file.Sync(); err != nil {
+		return fmt.Errorf("cannot sync %q: %w", p.file.Name(), err)
+	}
+	p.lastSync = time.Now()
+	return nil
+}
+
+func (p *FilePublisher) closeFile() error {
+	err := p.sync()
+	if closeErr := p.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("cannot close %q: %w", p.file.Name(), closeErr)
+	}
+	p.file, p.w = nil, nil
+	return err
+}
@@ -0,0 +1,205 @@
+package producer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilePublisher(t *testing.T) {
+	message := []byte(`{"batch":[{"type":"track"}]}`)
+
+	readNDJSON := func(t *testing.T, path string) []FileRecord {
+		t.Helper()
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+
+		var records []FileRecord
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record FileRecord
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records = append(records, record)
+		}
+		require.NoError(t, scanner.Err())
+		return records
+	}
+	readLengthPrefixed := func(t *testing.T, path string) []FileRecord {
+		t.Helper()
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+
+		readField := func() ([]byte, error) {
+			var size uint32
+			if err := binary.Read(f, binary.BigEndian, &size); err != nil {
+				return nil, err
+			}
+			field := make([]byte, size)
+			_, err := io.ReadFull(f, field)
+			return field, err
+		}
+		var records []FileRecord
+		for {
+			key, err := readField()
+			if errors.Is(err, io.EOF) {
+				return records
+			}
+			require.NoError(t, err)
+			writeKey, err := readField()
+			require.NoError(t, err)
+			payload, err := readField()
+			require.NoError(t, err)
+			records = append(records, FileRecord{Key: string(key), WriteKey: string(writeKey), Payload: payload})
+		}
+	}
+
+	t.Run("ndjson", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "output")
+		p, err := NewFilePublisher("producer", []string{"FILE_OUTPUT_PATH=" + dir})
+		require.NoError(t, err)
+
+		n, err := p.PublishTo(context.Background(), "user-1", message, map[string]string{"auth": "wk1"})
+		require.NoError(t, err)
+		require.Positive(t, n)
+		_, err = p.PublishTo(context.Background(), "user-2", []byte("not json"), map[string]string{"auth": "wk2"})
+		require.NoError(t, err)
+		require.NoError(t, p.Close())
+
+		require.Equal(t, []FileRecord{
+			{Key: "user-1", WriteKey: "wk1", Payload: message},
+			{Key: "user-2", WriteKey: "wk2", Payload: json.RawMessage(`"not json"`)},
+		}, readNDJSON(t, filepath.Join(dir, "producer-1.ndjson")))
+
+		_, err = p.PublishTo(context.Background(), "user-1", message, nil)
+		require.Error(t, err, "the publisher is closed")
+	})
+
+	t.Run("length prefixed", func(t *testing.T) {
+		dir := t.TempDir()
+		p, err := NewFilePublisher("producer", []string{
+			"FILE_OUTPUT_PATH=" + dir,
+			"FILE_FORMAT=length_prefixed",
+			"FILE_FSYNC_INTERVAL=1ns",
+		})
+		require.NoError(t, err)
+
+		n, err := p.PublishTo(context.Background(), "user-1", message, map[string]string{"auth": "wk1"})
+		require.NoError(t, err)
+		require.Equal(t, 12+len("user-1")+len("wk1")+len(message), n)
+		_, err = p.PublishTo(context.Background(), "user-2", []byte("not json\n"), map[string]string{"auth": "wk2"})
+		require.NoError(t, err)
+
+		// synced on every message
+		require.Equal(t, []FileRecord{
+			{Key: "user-1", WriteKey: "wk1", Payload: message},
+			{Key: "user-2", WriteKey: "wk2", Payload: []byte("not json\n")},
+		}, readLengthPrefixed(t, filepath.Join(dir, "producer-1.bin")))
+		require.NoError(t, p.Close())
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		dir := t.TempDir()
+		p, err := NewFilePublisher("producer_0", []string{"FILE_OUTPUT_PATH=" + dir, "FILE_ROTATE_SIZE=200"})
+		require.NoError(t, err)
+
+		var written int
+		for i := 0; i < 10; i++ {
+			n, err := p.PublishTo(context.Background(), "user-"+strconv.Itoa(i), message, map[string]string{"auth": "wk"})
+			require.NoError(t, err)
+			written += n
+		}
+		require.NoError(t, p.Close())
+
+		files, err := filepath.Glob(filepath.Join(dir, "producer_0-*.ndjson"))
+		require.NoError(t, err)
+		require.Greater(t, len(files), 1)
+
+		var (
+			records []FileRecord
+			size    int64
+		)
+		for i := range files { // read in order, the glob sorts producer_0-10 before producer_0-2
+			path := filepath.Join(dir, fmt.Sprintf("producer_0-%d.ndjson", i+1))
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			require.LessOrEqual(t, info.Size(), int64(200))
+			size += info.Size()
+			records = append(records, readNDJSON(t, path)...)
+		}
+		require.EqualValues(t, written, size)
+		require.Len(t, records, 10)
+		for i, record := range records {
+			require.Equal(t, "user-"+strconv.Itoa(i), record.Key)
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		dir := t.TempDir()
+		p, err := NewFilePublisher("producer", []string{"FILE_OUTPUT_PATH=" + dir})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					_, err := p.PublishTo(context.Background(), strconv.Itoa(i), message, map[string]string{"auth": "wk"})
+					require.NoError(t, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		require.NoError(t, p.Close())
+
+		records := readNDJSON(t, filepath.Join(dir, "producer-1.ndjson"))
+		require.Len(t, records, 1000)
+		for _, record := range records {
+			require.JSONEq(t, string(message), string(record.Payload))
+		}
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		dir := t.TempDir()
+		for i := 0; i < 2; i++ { // e.g. the pod is restarted with the same hostname
+			p, err := NewFilePublisher("producer", []string{"FILE_OUTPUT_PATH=" + dir})
+			require.NoError(t, err)
+			_, err = p.PublishTo(context.Background(), "user-"+strconv.Itoa(i), message, map[string]string{"auth": "wk"})
+			require.NoError(t, err)
+			require.NoError(t, p.Close())
+		}
+
+		require.Equal(t, []FileRecord{{Key: "user-0", WriteKey: "wk", Payload: message}},
+			readNDJSON(t, filepath.Join(dir, "producer-1.ndjson")), "the previous capture is kept",
+		)
+		require.Equal(t, []FileRecord{{Key: "user-1", WriteKey: "wk", Payload: message}},
+			readNDJSON(t, filepath.Join(dir, "producer-2.ndjson")),
+		)
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, environ := range [][]string{
+			nil,
+			{"FILE_OUTPUT_PATH=" + dir, "FILE_FORMAT=csv"},
+			{"FILE_OUTPUT_PATH=" + dir, "FILE_ROTATE_SIZE=-1"},
+			{"FILE_OUTPUT_PATH=" + dir, "FILE_FSYNC_INTERVAL=soon"},
+		} {
+			_, err := NewFilePublisher("producer", environ)
+			require.Error(t, err, environ)
+		}
+	})
+}
@@ -3,29 +3,127 @@ package producer
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	clientTypeFastHTTP = "fasthttp"
 	clientTypeHTTP     = "http"
+
+	compressionTypeNone = "none"
+	compressionTypeGzip = "gzip"
+	compressionTypeZstd = "zstd"
 )
 
+// HTTPStatusError is returned when the server replies with a status code other than 200 OK
+type HTTPStatusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // from the Retry-After header, zero if missing or invalid
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("http request failed with status code: %d: %s", e.StatusCode, e.Body)
+}
+
+// HTTPStatusCode returns the status code of the response
+func (e *HTTPStatusError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
 type HTTPProducer struct {
 	c           *fasthttp.Client
-	endpoint    string
+	endpoints   *endpointPool
 	contentType string
 	keyHeader   string
 	clientType  string
-	compression bool
+	compression string
+	// clientIPHeader carries the simulated client IP (i.e. extra["x_forwarded_for"])
+	clientIPHeader string
+	prewarmMethod  string
+	prewarmPath    string // the path of the endpoints is used if empty
+
+	maxRetries      int
+	retryBackoffMin time.Duration
+	retryBackoffMax time.Duration
+	onRetry         func(statusCode int)
+	retryAfterMax   time.Duration // zero if the Retry-After header is not honored by the retries
+	requestTimeout  time.Duration // zero if the requests are bounded only by the context and the transport timeouts
+
+	sourceHeaders map[string]map[string]string // read-only, per write key
+	tracer        trace.Tracer                 // nil if tracing is disabled
+	validate      ResponseValidator            // nil if the response bodies are not validated
+	signer        *requestSigner               // nil if the requests are not signed
+}
+
+type HTTPProducerOption func(*HTTPProducer)
+
+// WithOnRetry sets a function that is called with the response status code every time a request is retried,
+// the status code is zero for requests that timed out
+func WithOnRetry(f func(statusCode int)) HTTPProducerOption {
+	return func(p *HTTPProducer) {
+		p.onRetry = f
+	}
+}
+
+// WithRetryAfterMax makes the retries of 429 responses with a Retry-After header wait for it, capped by maxWait
+func WithRetryAfterMax(maxWait time.Duration) HTTPProducerOption {
+	return func(p *HTTPProducer) {
+		p.retryAfterMax = maxWait
+	}
+}
+
+// WithRequestTimeout bounds every attempt of PublishTo with timeout, the attempts that time out are retried like the
+// 429 and 5xx responses
+func WithRequestTimeout(timeout time.Duration) HTTPProducerOption {
+	return func(p *HTTPProducer) {
+		p.requestTimeout = timeout
+	}
+}
+
+// WithResponseValidator validates the body of every 200 OK response, invalid bodies are reported as
+// ResponseValidationError
+func WithResponseValidator(v ResponseValidator) HTTPProducerOption {
+	return func(p *HTTPProducer) {
+		p.validate = v
+	}
+}
+
+// WithSourceHeaders sets the extra headers to be sent with the requests of every write key (i.e. extra["auth"])
+func WithSourceHeaders(headers map[string]map[string]string) HTTPProducerOption {
+	return func(p *HTTPProducer) {
+		p.sourceHeaders = headers
+	}
 }
 
-func NewHTTPProducer(environ []string) (*HTTPProducer, error) {
+// WithOnEndpointRequest sets a function that is called every time a request is sent to one of the HTTP_ENDPOINT
+// endpoints, failed is true on network errors and 5xx responses
+func WithOnEndpointRequest(f func(endpoint string, failed bool)) HTTPProducerOption {
+	return func(p *HTTPProducer) {
+		p.endpoints.onRequest = f
+	}
+}
+
+// WithOnEndpointHealth sets a function that is called with the initial health of every endpoint and then every
+// time an endpoint changes health
+func WithOnEndpointHealth(f func(endpoint string, healthy bool)) HTTPProducerOption {
+	return func(p *HTTPProducer) {
+		p.endpoints.onHealth = f
+	}
+}
+
+func NewHTTPProducer(environ []string, opts ...HTTPProducerOption) (*HTTPProducer, error) {
 	conf, err := readConfiguration("HTTP_", environ)
 	if err != nil {
 		return nil, fmt.Errorf("cannot read http configuration: %v", err)
@@ -37,13 +135,27 @@ func NewHTTPProducer(environ []string) (*HTTPProducer, error) {
 	if clientType != clientTypeHTTP && clientType != clientTypeFastHTTP {
 		return nil, fmt.Errorf("client type out of the known domain [%s,%s]: %s", clientTypeHTTP, clientTypeFastHTTP, clientType)
 	}
-	endpoint, err := getRequiredStringSetting(conf, "endpoint")
+	endpointsValue, err := getRequiredStringSetting(conf, "endpoint")
 	if err != nil {
 		return nil, err
 	}
-	_, err = url.Parse(endpoint)
+	endpoints := strings.Split(endpointsValue, ",")
+	for i := range endpoints {
+		endpoints[i] = strings.TrimSpace(endpoints[i])
+		if _, err := url.Parse(endpoints[i]); err != nil {
+			return nil, fmt.Errorf("invalid endpoint: %v", err)
+		}
+	}
+	endpointFailureThreshold, err := getOptionalIntSetting(conf, "endpoint_failure_threshold", 3)
+	if err != nil {
+		return nil, err
+	}
+	if endpointFailureThreshold < 1 {
+		return nil, fmt.Errorf("endpoint failure threshold has to be greater than zero: %d", endpointFailureThreshold)
+	}
+	endpointUnhealthyBackoff, err := getOptionalDurationSetting(conf, "endpoint_unhealthy_backoff", 30*time.Second)
 	if err != nil {
-		return nil, fmt.Errorf("invalid endpoint: %v", err)
+		return nil, err
 	}
 	readTimeout, err := getOptionalDurationSetting(conf, "read_timeout", 500*time.Millisecond)
 	if err != nil {
@@ -69,8 +181,33 @@ func NewHTTPProducer(environ []string) (*HTTPProducer, error) {
 	if err != nil {
 		return nil, err
 	}
+	defaultCompressionType := compressionTypeNone
+	if compression { // HTTP_COMPRESSION=true is kept for backwards compatibility
+		defaultCompressionType = compressionTypeGzip
+	}
+	compressionType, err := getOptionalStringSetting(conf, "compression_type", defaultCompressionType)
+	if err != nil {
+		return nil, err
+	}
+	switch compressionType {
+	case compressionTypeNone, compressionTypeGzip, compressionTypeZstd:
+	default:
+		return nil, fmt.Errorf("compression type out of the known domain [%s,%s,%s]: %s",
+			compressionTypeNone, compressionTypeGzip, compressionTypeZstd, compressionType,
+		)
+	}
+
+	tlsConfig, err := newTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := newRequestSigner(conf)
+	if err != nil {
+		return nil, err
+	}
 
 	client := &fasthttp.Client{
+		TLSConfig:                     tlsConfig,
 		ReadTimeout:                   readTimeout,
 		WriteTimeout:                  writeTimeout,
 		MaxIdleConnDuration:           maxIdleConn,
@@ -93,30 +230,263 @@ func NewHTTPProducer(environ []string) (*HTTPProducer, error) {
 	if err != nil {
 		return nil, err
 	}
+	clientIPHeader, err := getOptionalStringSetting(conf, "client_ip_header", "X-Forwarded-For")
+	if err != nil {
+		return nil, err
+	}
+	prewarmMethod, err := getOptionalStringSetting(conf, "prewarm_method", fasthttp.MethodHead)
+	if err != nil {
+		return nil, err
+	}
+	switch prewarmMethod {
+	case fasthttp.MethodHead, fasthttp.MethodOptions, fasthttp.MethodGet, fasthttp.MethodPost:
+	default:
+		return nil, fmt.Errorf("prewarm method out of the known domain [%s,%s,%s,%s]: %s",
+			fasthttp.MethodHead, fasthttp.MethodOptions, fasthttp.MethodGet, fasthttp.MethodPost, prewarmMethod,
+		)
+	}
+	prewarmPath, err := getOptionalStringSetting(conf, "prewarm_path", "")
+	if err != nil {
+		return nil, err
+	}
+	if prewarmPath != "" && !strings.HasPrefix(prewarmPath, "/") {
+		return nil, fmt.Errorf("prewarm path has to start with /: %s", prewarmPath)
+	}
+	maxRetries, err := getOptionalIntSetting(conf, "max_retries", 0)
+	if err != nil {
+		return nil, err
+	}
+	retryBackoffMin, err := getOptionalDurationSetting(conf, "retry_backoff_min", 100*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	retryBackoffMax, err := getOptionalDurationSetting(conf, "retry_backoff_max", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if retryBackoffMin <= 0 || retryBackoffMax < retryBackoffMin {
+		return nil, fmt.Errorf("invalid retry backoff: min %s, max %s", retryBackoffMin, retryBackoffMax)
+	}
 
-	return &HTTPProducer{
-		c:           client,
-		endpoint:    endpoint,
-		contentType: contentType,
-		keyHeader:   keyHeader,
-		clientType:  clientType,
-		compression: compression,
-	}, nil
+	p := &HTTPProducer{
+		c:               client,
+		endpoints:       newEndpointPool(endpoints, int(endpointFailureThreshold), endpointUnhealthyBackoff),
+		contentType:     contentType,
+		keyHeader:       keyHeader,
+		clientIPHeader:  clientIPHeader,
+		prewarmMethod:   prewarmMethod,
+		prewarmPath:     prewarmPath,
+		clientType:      clientType,
+		compression:     compressionType,
+		maxRetries:      int(maxRetries),
+		retryBackoffMin: retryBackoffMin,
+		retryBackoffMax: retryBackoffMax,
+		signer:          signer,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.endpoints.onHealth != nil {
+		for _, e := range p.endpoints.endpoints {
+			p.endpoints.onHealth(e.url, true)
+		}
+	}
+	return p, nil
 }
 
-func (p *HTTPProducer) PublishTo(_ context.Context, key string, message []byte, extra map[string]string) (int, error) {
-	req := fasthttp.AcquireRequest()
-	req.SetRequestURI(p.endpoint)
+// PublishTo sends the message retrying with a jittered exponential backoff on 429 and 5xx responses and on
+// timeouts, up to HTTP_MAX_RETRIES times. On 429 responses with a Retry-After header it waits at least for it (see
+// WithRetryAfterMax). If the context has a deadline the requests are aborted once it is reached.
+func (p *HTTPProducer) PublishTo(ctx context.Context, key string, message []byte, extra map[string]string) (int, error) {
+	for attempt := 0; ; attempt++ {
+		n, err := p.publishAttempt(ctx, key, message, extra)
+		if err == nil || attempt >= p.maxRetries {
+			return n, err
+		}
+
+		var statusErr *HTTPStatusError
+		switch {
+		case errors.As(err, &statusErr) && isRetryableStatusCode(statusErr.StatusCode):
+			if p.onRetry != nil {
+				p.onRetry(statusErr.StatusCode)
+			}
+		case isTimeout(err):
+			if p.onRetry != nil {
+				p.onRetry(0)
+			}
+		default:
+			return n, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(p.retryWait(attempt, statusErr)):
+		}
+	}
+}
+
+// publishAttempt sends the message bounding the request with the request timeout, if any
+func (p *HTTPProducer) publishAttempt(ctx context.Context, key string, message []byte, extra map[string]string) (int, error) {
+	if p.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.requestTimeout)
+		defer cancel()
+	}
+	return p.publishWithFailover(ctx, key, message, extra)
+}
+
+// publishWithFailover sends the message to the next healthy endpoint. On network errors and 5xx responses the
+// message is sent to the following endpoints until one succeeds or all of them have been tried.
+// Messages with an explicit endpoint (i.e. extra["endpoint"]) are sent to it as they are.
+func (p *HTTPProducer) publishWithFailover(ctx context.Context, key string, message []byte, extra map[string]string) (int, error) {
+	if e, ok := extra["endpoint"]; ok {
+		return p.publish(ctx, e, key, message, extra)
+	}
 
-	if p.compression {
-		_, err := fasthttp.WriteGzipLevel(req.BodyWriter(), message, fasthttp.CompressBestSpeed)
-		if err != nil {
-			return 0, fmt.Errorf("cannot compress message: %w", err)
+	var (
+		n   int
+		err error
+	)
+	for i := 0; i < p.endpoints.Len(); i++ {
+		e := p.endpoints.Next()
+		n, err = p.publish(ctx, e.url, key, message, extra)
+		failed := isEndpointFailure(err)
+		p.endpoints.Report(e, failed)
+		if !failed {
+			return n, err
 		}
-		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return n, err
+}
+
+func (p *HTTPProducer) publish(ctx context.Context, endpoint, key string, message []byte, extra map[string]string) (n int, err error) {
+	req, err := p.newRequest(endpoint, key, message, extra)
+	if err != nil {
+		return 0, err
+	}
+
+	var statusCode int // zero until a response is received
+	if p.tracer != nil {
+		span := p.startSpan(ctx, req, endpoint, extra)
+		defer func() { endSpan(span, statusCode, err) }()
+	}
+
+	res := fasthttp.AcquireResponse()
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		err = p.c.DoDeadline(req, res, deadline)
 	} else {
+		err = p.c.Do(req, res)
+	}
+	n = len(req.Body())
+	fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+
+	if hasDeadline && errors.Is(err, fasthttp.ErrTimeout) {
+		return 0, fmt.Errorf("http request failed: %w", context.DeadlineExceeded)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("http request failed: %w", err)
+	}
+	statusCode = res.StatusCode()
+	if res.StatusCode() != http.StatusOK {
+		return 0, &HTTPStatusError{
+			StatusCode: res.StatusCode(),
+			Body:       string(res.Body()),
+			RetryAfter: parseRetryAfter(string(res.Header.Peek(fasthttp.HeaderRetryAfter)), time.Now()),
+		}
+	}
+	if p.validate != nil {
+		if err := p.validate(res.Body()); err != nil {
+			return 0, &ResponseValidationError{Body: string(res.Body()), Err: err}
+		}
+	}
+
+	return n, err
+}
+
+// parseRetryAfter parses a Retry-After header value, either in seconds or as an HTTP date.
+// It returns zero if the value is missing, invalid or in the past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// Probe sends the message to the next endpoint authenticating with the given write key and returns the status
+// code. It is meant to be used before generating load (e.g. to validate write keys).
+func (p *HTTPProducer) Probe(writeKey string, message []byte, timeout time.Duration) (int, error) {
+	req, err := p.newRequest(p.endpoints.Next().url, "", message, map[string]string{"auth": writeKey})
+	if err != nil {
+		return 0, err
+	}
+	defer fasthttp.ReleaseRequest(req)
+
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	if err := p.c.DoTimeout(req, res, timeout); err != nil {
+		return 0, fmt.Errorf("http request failed: %w", err)
+	}
+	return res.StatusCode(), nil
+}
+
+// Prewarm sends a HTTP_PREWARM_METHOD request (HEAD by default, POSTs have no body) to HTTP_PREWARM_PATH of the
+// next endpoint to establish a connection before generating load. Any response counts as a warm connection, so
+// non-2xx status codes are not errors. fasthttp closes the connection after HEAD responses without a Content-Length
+// (servers usually omit it for HEAD), OPTIONS can be used for those servers.
+func (p *HTTPProducer) Prewarm(deadline time.Time) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	req.SetRequestURI(p.endpoints.Next().url)
+	if p.prewarmPath != "" {
+		req.URI().SetPath(p.prewarmPath)
+		req.URI().SetQueryString("")
+	}
+	req.Header.SetMethod(p.prewarmMethod)
+	if err := p.c.DoDeadline(req, res, deadline); err != nil {
+		return fmt.Errorf("http request failed: %w", err)
+	}
+	return nil
+}
+
+// MaxConnsPerHost returns the max number of connections that the client can open (i.e. HTTP_MAX_CONNS_PER_HOST)
+func (p *HTTPProducer) MaxConnsPerHost() int {
+	return p.c.MaxConnsPerHost
+}
+
+func (p *HTTPProducer) newRequest(endpoint, key string, message []byte, extra map[string]string) (*fasthttp.Request, error) {
+	req := fasthttp.AcquireRequest()
+	req.SetRequestURI(appendQueryParams(endpoint, extra["query_params"]))
+
+	// fasthttp reuses pooled gzip and zstd writers
+	var err error
+	switch p.compression {
+	case compressionTypeGzip:
+		_, err = fasthttp.WriteGzipLevel(req.BodyWriter(), message, fasthttp.CompressBestSpeed)
+		req.Header.Set("Content-Encoding", compressionTypeGzip)
+	case compressionTypeZstd:
+		_, err = fasthttp.WriteZstdLevel(req.BodyWriter(), message, fasthttp.CompressZstdBestSpeed)
+		req.Header.Set("Content-Encoding", compressionTypeZstd)
+	default:
 		req.SetBody(message)
 	}
+	if err != nil {
+		fasthttp.ReleaseRequest(req)
+		return nil, fmt.Errorf("cannot compress message: %w", err)
+	}
 
 	req.Header.SetMethod(fasthttp.MethodPost)
 	req.Header.SetContentType(p.contentType)
@@ -125,25 +495,72 @@ func (p *HTTPProducer) PublishTo(_ context.Context, key string, message []byte,
 	}
 	if auth, ok := extra["auth"]; ok {
 		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth+":")))
+		for name, value := range p.sourceHeaders[auth] {
+			req.Header.Set(name, value)
+		}
 	}
 	if anonymousID, ok := extra["anonymous_id"]; ok {
 		req.Header.Set("AnonymousId", anonymousID)
 	}
+	if xff, ok := extra["x_forwarded_for"]; ok {
+		req.Header.Set(p.clientIPHeader, xff)
+	}
+	if p.signer != nil {
+		if err := p.signer.Sign(req, extra["auth"], message); err != nil {
+			fasthttp.ReleaseRequest(req)
+			return nil, err
+		}
+	}
 
-	res := fasthttp.AcquireResponse()
-	err := p.c.Do(req, res)
-	n := len(req.Body())
-	fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(res)
+	return req, nil
+}
 
-	if err != nil {
-		return 0, fmt.Errorf("http request failed: %w", err)
+// retryBackoff returns the exponential backoff for the given attempt with a random jitter in the [50%,100%] range
+func (p *HTTPProducer) retryBackoff(attempt int) time.Duration {
+	backoff := p.retryBackoffMax
+	if attempt < 32 { // avoid overflowing
+		backoff = min(p.retryBackoffMin*time.Duration(1<<attempt), p.retryBackoffMax)
 	}
-	if res.StatusCode() != http.StatusOK {
-		return 0, fmt.Errorf("http request failed with status code: %d: %s", res.StatusCode(), res.Body())
+	if backoff <= 0 { // overflow
+		backoff = p.retryBackoffMax
 	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
 
-	return n, err
+// retryWait returns how long to wait before retrying after statusErr (nil on timeouts): the backoff of the attempt
+// or, if longer, the Retry-After of a 429 response capped by retryAfterMax
+func (p *HTTPProducer) retryWait(attempt int, statusErr *HTTPStatusError) time.Duration {
+	wait := p.retryBackoff(attempt)
+	if statusErr != nil && statusErr.StatusCode == http.StatusTooManyRequests && p.retryAfterMax > 0 {
+		wait = max(wait, min(statusErr.RetryAfter, p.retryAfterMax))
+	}
+	return wait
+}
+
+func isRetryableStatusCode(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// isTimeout returns true if the request timed out, either hitting the deadline of its context or the transport timeouts
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, fasthttp.ErrTimeout) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// appendQueryParams appends the given query params to the endpoint taking into account that the endpoint might
+// already have a query string
+func appendQueryParams(endpoint, params string) string {
+	if params == "" {
+		return endpoint
+	}
+	if !strings.Contains(endpoint, "?") {
+		return endpoint + "?" + params
+	}
+	if strings.HasSuffix(endpoint, "?") || strings.HasSuffix(endpoint, "&") {
+		return endpoint + params
+	}
+	return endpoint + "&" + params
 }
 
 func (p *HTTPProducer) Close() error {
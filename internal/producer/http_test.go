@@ -0,0 +1,467 @@
+package producer
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	nethttptest "net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestHTTPProducerRetries(t *testing.T) {
+	newServer := func(t *testing.T, failures int32, statusCode int) (*atomic.Int32, string) {
+		var requests atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) <= failures {
+				w.WriteHeader(statusCode)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(srv.Close)
+		return &requests, srv.URL
+	}
+	newProducer := func(t *testing.T, endpoint string, retried *[]int) *HTTPProducer {
+		p, err := NewHTTPProducer([]string{
+			"HTTP_ENDPOINT=" + endpoint,
+			"HTTP_MAX_RETRIES=3",
+			"HTTP_RETRY_BACKOFF_MIN=1ms",
+			"HTTP_RETRY_BACKOFF_MAX=10ms",
+		}, WithOnRetry(func(statusCode int) {
+			*retried = append(*retried, statusCode)
+		}))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+		return p
+	}
+
+	t.Run("eventually published", func(t *testing.T) {
+		requests, endpoint := newServer(t, 2, http.StatusServiceUnavailable)
+		var retried []int
+		p := newProducer(t, endpoint, &retried)
+
+		n, err := p.PublishTo(context.Background(), "key", []byte("message"), nil)
+		require.NoError(t, err)
+		require.Equal(t, len("message"), n)
+		require.EqualValues(t, 3, requests.Load())
+		require.Equal(t, []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}, retried)
+	})
+	t.Run("too many requests", func(t *testing.T) {
+		_, endpoint := newServer(t, 1, http.StatusTooManyRequests)
+		var retried []int
+		p := newProducer(t, endpoint, &retried)
+
+		_, err := p.PublishTo(context.Background(), "key", []byte("message"), nil)
+		require.NoError(t, err)
+		require.Equal(t, []int{http.StatusTooManyRequests}, retried)
+	})
+	t.Run("retry after", func(t *testing.T) {
+		var requests atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(srv.Close)
+
+		for _, tc := range []struct {
+			name          string
+			retryAfterMax time.Duration
+			minWait       time.Duration
+			maxWait       time.Duration
+		}{
+			{name: "honored", retryAfterMax: time.Minute, minWait: time.Second, maxWait: 5 * time.Second},
+			{name: "capped", retryAfterMax: 200 * time.Millisecond, minWait: 200 * time.Millisecond, maxWait: time.Second},
+			{name: "not honored", retryAfterMax: 0, minWait: 0, maxWait: 200 * time.Millisecond},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				requests.Store(0)
+				var retried []int
+				p, err := NewHTTPProducer([]string{
+					"HTTP_ENDPOINT=" + srv.URL,
+					"HTTP_MAX_RETRIES=3",
+					"HTTP_RETRY_BACKOFF_MIN=1ms",
+					"HTTP_RETRY_BACKOFF_MAX=10ms",
+				}, WithOnRetry(func(statusCode int) {
+					retried = append(retried, statusCode)
+				}), WithRetryAfterMax(tc.retryAfterMax))
+				require.NoError(t, err)
+				t.Cleanup(func() { _ = p.Close() })
+
+				start := time.Now()
+				_, err = p.PublishTo(context.Background(), "key", []byte("message"), nil)
+				elapsed := time.Since(start)
+				require.NoError(t, err)
+				require.EqualValues(t, 2, requests.Load())
+				require.Equal(t, []int{http.StatusTooManyRequests}, retried)
+				require.GreaterOrEqual(t, elapsed, tc.minWait)
+				require.Less(t, elapsed, tc.maxWait)
+			})
+		}
+	})
+	t.Run("retry after exhausted", func(t *testing.T) {
+		var requests atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		t.Cleanup(srv.Close)
+
+		p, err := NewHTTPProducer([]string{
+			"HTTP_ENDPOINT=" + srv.URL,
+			"HTTP_MAX_RETRIES=2",
+			"HTTP_RETRY_BACKOFF_MIN=1ms",
+			"HTTP_RETRY_BACKOFF_MAX=10ms",
+		}, WithRetryAfterMax(10*time.Millisecond))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+
+		_, err = p.PublishTo(context.Background(), "key", []byte("message"), nil)
+		var statusErr *HTTPStatusError
+		require.ErrorAs(t, err, &statusErr)
+		require.Equal(t, http.StatusTooManyRequests, statusErr.StatusCode)
+		require.EqualValues(t, 3, requests.Load(), "the attempts are bounded by HTTP_MAX_RETRIES")
+	})
+	t.Run("retry after canceled", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		t.Cleanup(srv.Close)
+
+		p, err := NewHTTPProducer([]string{
+			"HTTP_ENDPOINT=" + srv.URL,
+			"HTTP_MAX_RETRIES=1",
+		}, WithRetryAfterMax(time.Hour))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err = p.PublishTo(ctx, "key", []byte("message"), nil)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), time.Second)
+	})
+	t.Run("timeouts", func(t *testing.T) {
+		var requests atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) <= 2 {
+				time.Sleep(200 * time.Millisecond)
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		t.Cleanup(srv.Close)
+
+		newTimeoutProducer := func(t *testing.T, maxRetries string, retried *[]int) *HTTPProducer {
+			p, err := NewHTTPProducer([]string{
+				"HTTP_ENDPOINT=" + srv.URL,
+				"HTTP_MAX_RETRIES=" + maxRetries,
+				"HTTP_RETRY_BACKOFF_MIN=1ms",
+				"HTTP_RETRY_BACKOFF_MAX=10ms",
+			}, WithOnRetry(func(statusCode int) {
+				*retried = append(*retried, statusCode)
+			}), WithRequestTimeout(50*time.Millisecond))
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = p.Close() })
+			return p
+		}
+
+		t.Run("eventually published", func(t *testing.T) {
+			requests.Store(0)
+			var retried []int
+			p := newTimeoutProducer(t, "3", &retried)
+
+			_, err := p.PublishTo(context.Background(), "key", []byte("message"), nil)
+			require.NoError(t, err)
+			require.EqualValues(t, 3, requests.Load())
+			require.Equal(t, []int{0, 0}, retried)
+		})
+		t.Run("retries exhausted", func(t *testing.T) {
+			requests.Store(0)
+			var retried []int
+			p := newTimeoutProducer(t, "1", &retried)
+
+			_, err := p.PublishTo(context.Background(), "key", []byte("message"), nil)
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+			require.EqualValues(t, 2, requests.Load())
+			require.Equal(t, []int{0}, retried)
+		})
+	})
+	t.Run("retries exhausted", func(t *testing.T) {
+		requests, endpoint := newServer(t, 10, http.StatusInternalServerError)
+		var retried []int
+		p := newProducer(t, endpoint, &retried)
+
+		_, err := p.PublishTo(context.Background(), "key", []byte("message"), nil)
+		var statusErr *HTTPStatusError
+		require.ErrorAs(t, err, &statusErr)
+		require.Equal(t, http.StatusInternalServerError, statusErr.StatusCode)
+		require.EqualValues(t, 4, requests.Load())
+		require.Len(t, retried, 3)
+	})
+	t.Run("non retryable", func(t *testing.T) {
+		requests, endpoint := newServer(t, 10, http.StatusBadRequest)
+		var retried []int
+		p := newProducer(t, endpoint, &retried)
+
+		_, err := p.PublishTo(context.Background(), "key", []byte("message"), nil)
+		require.Error(t, err)
+		require.EqualValues(t, 1, requests.Load())
+		require.Empty(t, retried)
+	})
+	t.Run("context canceled", func(t *testing.T) {
+		_, endpoint := newServer(t, 10, http.StatusServiceUnavailable)
+		p, err := NewHTTPProducer([]string{
+			"HTTP_ENDPOINT=" + endpoint,
+			"HTTP_MAX_RETRIES=3",
+			"HTTP_RETRY_BACKOFF_MIN=1h",
+			"HTTP_RETRY_BACKOFF_MAX=1h",
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err = p.PublishTo(ctx, "key", []byte("message"), nil)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestHTTPProducerCompression(t *testing.T) {
+	message := []byte(`{"batch":[{"type":"track","event":"compressed"}]}`)
+
+	for _, tc := range []struct {
+		name            string
+		environ         []string
+		contentEncoding string
+	}{
+		{name: "none", environ: nil, contentEncoding: ""},
+		{name: "legacy gzip", environ: []string{"HTTP_COMPRESSION=true"}, contentEncoding: "gzip"},
+		{name: "gzip", environ: []string{"HTTP_COMPRESSION_TYPE=gzip"}, contentEncoding: "gzip"},
+		{name: "zstd", environ: []string{"HTTP_COMPRESSION_TYPE=zstd"}, contentEncoding: "zstd"},
+		{name: "explicit none", environ: []string{"HTTP_COMPRESSION=true", "HTTP_COMPRESSION_TYPE=none"}, contentEncoding: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bodies := make(chan []byte, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, tc.contentEncoding, r.Header.Get("Content-Encoding"))
+
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				switch r.Header.Get("Content-Encoding") {
+				case "gzip":
+					body, err = fasthttp.AppendGunzipBytes(nil, body)
+				case "zstd":
+					body, err = fasthttp.AppendUnzstdBytes(nil, body)
+				}
+				require.NoError(t, err)
+
+				bodies <- body
+				w.WriteHeader(http.StatusOK)
+			}))
+			t.Cleanup(srv.Close)
+
+			p, err := NewHTTPProducer(append(tc.environ, "HTTP_ENDPOINT="+srv.URL))
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = p.Close() })
+
+			_, err = p.PublishTo(context.Background(), "key", message, nil)
+			require.NoError(t, err)
+			require.Equal(t, message, <-bodies)
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewHTTPProducer([]string{"HTTP_ENDPOINT=http://localhost", "HTTP_COMPRESSION_TYPE=brotli"})
+		require.Error(t, err)
+	})
+}
+
+func TestHTTPProducerClientIPHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		environ []string
+		header  string
+	}{
+		{name: "default", environ: nil, header: "X-Forwarded-For"},
+		{name: "custom", environ: []string{"HTTP_CLIENT_IP_HEADER=X-Real-IP"}, header: "X-Real-IP"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := make(chan http.Header, 2)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headers <- r.Header.Clone()
+				w.WriteHeader(http.StatusOK)
+			}))
+			t.Cleanup(srv.Close)
+
+			p, err := NewHTTPProducer(append(tc.environ, "HTTP_ENDPOINT="+srv.URL))
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = p.Close() })
+
+			_, err = p.PublishTo(context.Background(), "key", []byte("{}"), map[string]string{"x_forwarded_for": "10.0.1.2"})
+			require.NoError(t, err)
+			require.Equal(t, "10.0.1.2", (<-headers).Get(tc.header))
+
+			_, err = p.PublishTo(context.Background(), "key", []byte("{}"), nil)
+			require.NoError(t, err)
+			require.Empty(t, (<-headers).Get(tc.header))
+		})
+	}
+}
+
+func TestHTTPProducerSourceHeaders(t *testing.T) {
+	type request struct {
+		writeKey    string
+		workspaceID string
+		region      string
+	}
+	requests := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeKey, _, _ := r.BasicAuth()
+		requests <- request{
+			writeKey:    writeKey,
+			workspaceID: r.Header.Get("X-Workspace-Id"),
+			region:      r.Header.Get("X-Region"),
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	// the producer is shared by all the write keys
+	p, err := NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL}, WithSourceHeaders(map[string]map[string]string{
+		"write-key-1": {"X-Workspace-Id": "ws-a", "X-Region": "eu"},
+		"write-key-2": {"X-Workspace-Id": "ws-b"},
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+
+	for _, expected := range []request{
+		{writeKey: "write-key-1", workspaceID: "ws-a", region: "eu"},
+		{writeKey: "write-key-2", workspaceID: "ws-b"},
+		{writeKey: "write-key-3"},
+		{writeKey: "write-key-1", workspaceID: "ws-a", region: "eu"},
+		{writeKey: "write-key-2", workspaceID: "ws-b"},
+	} {
+		_, err := p.PublishTo(context.Background(), "key", []byte("{}"), map[string]string{"auth": expected.writeKey})
+		require.NoError(t, err)
+		require.Equal(t, expected, <-requests)
+	}
+}
+
+func TestHTTPProducerEndpointsFailover(t *testing.T) {
+	var requestsA, requestsB atomic.Int32
+	srvA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsA.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srvA.Close)
+	handlerB := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsB.Add(1)
+		w.WriteHeader(http.StatusOK)
+	})
+	// the standard library server is used since it can be restarted on the same address
+	srvB := nethttptest.NewServer(handlerB)
+	endpointB := srvB.URL
+
+	var (
+		mu       sync.Mutex
+		health   = make(map[string]bool)
+		failures = make(map[string]int)
+	)
+	p, err := NewHTTPProducer([]string{
+		"HTTP_ENDPOINT=" + srvA.URL + ", " + endpointB,
+		"HTTP_ENDPOINT_FAILURE_THRESHOLD=2",
+		"HTTP_ENDPOINT_UNHEALTHY_BACKOFF=100ms",
+	}, WithOnEndpointHealth(func(endpoint string, healthy bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		health[endpoint] = healthy
+	}), WithOnEndpointRequest(func(endpoint string, failed bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failed {
+			failures[endpoint]++
+		}
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+
+	publish := func(messages int) {
+		for i := 0; i < messages; i++ {
+			_, err := p.PublishTo(context.Background(), "key", []byte("message"), nil)
+			require.NoError(t, err)
+		}
+	}
+	healthy := func(endpoint string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return health[endpoint]
+	}
+
+	publish(10)
+	require.EqualValues(t, 5, requestsA.Load())
+	require.EqualValues(t, 5, requestsB.Load())
+	require.True(t, healthy(srvA.URL))
+	require.True(t, healthy(endpointB))
+
+	srvB.Close()
+	publish(10) // B fails twice then it is skipped, every message is published to A
+	require.EqualValues(t, 15, requestsA.Load())
+	require.EqualValues(t, 5, requestsB.Load())
+	require.False(t, healthy(endpointB))
+	mu.Lock()
+	require.Equal(t, map[string]int{endpointB: 2}, failures)
+	mu.Unlock()
+
+	listener, err := net.Listen("tcp", srvB.Listener.Addr().String())
+	require.NoError(t, err)
+	srvB = nethttptest.NewUnstartedServer(handlerB)
+	_ = srvB.Listener.Close()
+	srvB.Listener = listener
+	srvB.Start()
+	t.Cleanup(srvB.Close)
+
+	time.Sleep(200 * time.Millisecond) // wait for the unhealthy backoff to expire
+	publish(10)
+	require.Greater(t, requestsB.Load(), int32(5), "traffic should go back to B")
+	require.True(t, healthy(endpointB))
+}
+
+func TestAppendQueryParams(t *testing.T) {
+	require.Equal(t, "http://localhost/v1/batch", appendQueryParams("http://localhost/v1/batch", ""))
+	require.Equal(t, "http://localhost/v1/batch?routing=fast", appendQueryParams("http://localhost/v1/batch", "routing=fast"))
+	require.Equal(t, "http://localhost/v1/batch?a=1&routing=fast", appendQueryParams("http://localhost/v1/batch?a=1", "routing=fast"))
+	require.Equal(t, "http://localhost/v1/batch?routing=fast", appendQueryParams("http://localhost/v1/batch?", "routing=fast"))
+	require.Equal(t, "http://localhost/v1/batch?a=1&routing=fast", appendQueryParams("http://localhost/v1/batch?a=1&", "routing=fast"))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 11, 5, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "", expected: 0},
+		{value: "1", expected: time.Second},
+		{value: " 120 ", expected: 2 * time.Minute},
+		{value: "-1", expected: 0},
+		{value: "Tue, 05 Nov 2024 10:00:30 GMT", expected: 30 * time.Second},
+		{value: "Tue, 05 Nov 2024 09:59:30 GMT", expected: 0},
+		{value: "soon", expected: 0},
+	} {
+		require.Equal(t, tc.expected, parseRetryAfter(tc.value, now), tc.value)
+	}
+}
@@ -0,0 +1,104 @@
+package producer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// SignatureBodyCompressed signs the body as it is sent, i.e. after HTTP_COMPRESSION_TYPE is applied
+	SignatureBodyCompressed = "compressed"
+	// SignatureBodyUncompressed signs the body before compression
+	SignatureBodyUncompressed = "uncompressed"
+)
+
+// requestSigner sets the hex(hmac-sha256(secret, body)) signature header, the secret being the one of the write key
+// the request is authenticated with (i.e. extra["auth"])
+type requestSigner struct {
+	header       string
+	secrets      map[string][]byte
+	uncompressed bool
+}
+
+// newRequestSigner builds the signer from the signature_* settings, it returns nil if signature_header is not set
+func newRequestSigner(conf map[string]string) (*requestSigner, error) {
+	header, _ := getOptionalStringSetting(conf, "signature_header", "")
+	secretsValue, _ := getOptionalStringSetting(conf, "signature_secret", "")
+	body, _ := getOptionalStringSetting(conf, "signature_body", SignatureBodyCompressed)
+	if header == "" {
+		return nil, nil
+	}
+	if strings.ContainsAny(header, " \t\r\n:") {
+		return nil, fmt.Errorf("invalid signature header name %q", header)
+	}
+	if body != SignatureBodyCompressed && body != SignatureBodyUncompressed {
+		return nil, fmt.Errorf("signature body out of the known domain [%s,%s]: %s",
+			SignatureBodyCompressed, SignatureBodyUncompressed, body,
+		)
+	}
+	secrets, err := parseSignatureSecrets(secretsValue)
+	if err != nil {
+		return nil, err
+	}
+	return &requestSigner{header: header, secrets: secrets, uncompressed: body == SignatureBodyUncompressed}, nil
+}
+
+// parseSignatureSecrets parses a JSON object with the secret of every write key (e.g. {"write-key-1": "secret-1"})
+func parseSignatureSecrets(input string) (map[string][]byte, error) {
+	if input == "" {
+		return nil, fmt.Errorf("missing required setting %q", "signature_secret")
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal([]byte(input), &secrets); err != nil {
+		return nil, fmt.Errorf("invalid signature secrets: %w", err)
+	}
+	m := make(map[string][]byte, len(secrets))
+	for writeKey, secret := range secrets {
+		if secret == "" {
+			return nil, fmt.Errorf("empty signature secret for write key %q", writeKey)
+		}
+		m[writeKey] = []byte(secret)
+	}
+	return m, nil
+}
+
+// Sign sets the signature header of the request whose body is already set, message being the uncompressed body
+func (s *requestSigner) Sign(req *fasthttp.Request, writeKey string, message []byte) error {
+	secret, ok := s.secrets[writeKey]
+	if !ok {
+		return fmt.Errorf("missing signature secret for write key %q", writeKey)
+	}
+	body := req.Body()
+	if s.uncompressed {
+		body = message
+	}
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+	req.Header.Set(s.header, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// ValidateSignatureSecrets returns an error if HTTP_SIGNATURE_HEADER is set and any of the given write keys has no
+// secret in HTTP_SIGNATURE_SECRET. It is meant to be used before generating load.
+func ValidateSignatureSecrets(environ, writeKeys []string) error {
+	conf, err := readConfiguration("HTTP_", environ)
+	if err != nil {
+		return fmt.Errorf("cannot read http configuration: %v", err)
+	}
+	s, err := newRequestSigner(conf)
+	if err != nil || s == nil {
+		return err
+	}
+	for _, writeKey := range writeKeys {
+		if _, ok := s.secrets[writeKey]; !ok {
+			return fmt.Errorf("missing signature secret for write key %q", writeKey)
+		}
+	}
+	return nil
+}
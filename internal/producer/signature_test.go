@@ -0,0 +1,127 @@
+package producer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPProducerSignature(t *testing.T) {
+	sign := func(secret string, body []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	type received struct {
+		body, uncompressed []byte
+		signature          string
+	}
+	newServer := func(t *testing.T) (string, <-chan received) {
+		ch := make(chan received, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			uncompressed := body
+			if r.Header.Get("Content-Encoding") == compressionTypeGzip {
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				require.NoError(t, err)
+				uncompressed, err = io.ReadAll(zr)
+				require.NoError(t, err)
+			}
+			ch <- received{body: body, uncompressed: uncompressed, signature: r.Header.Get("X-Signature")}
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL, ch
+	}
+	secrets := `HTTP_SIGNATURE_SECRET={"write-key-1":"secret-1","write-key-2":"secret-2"}`
+	message := []byte(`{"batch":[{"type":"track"}]}`)
+
+	for _, tc := range []struct {
+		name        string
+		environ     []string
+		signedBody  func(r received) []byte
+		compression bool
+	}{
+		{
+			name:       "uncompressed",
+			environ:    nil,
+			signedBody: func(r received) []byte { return r.body },
+		},
+		{
+			name:        "compressed body",
+			environ:     []string{"HTTP_COMPRESSION_TYPE=gzip"},
+			signedBody:  func(r received) []byte { return r.body },
+			compression: true,
+		},
+		{
+			name:        "uncompressed body",
+			environ:     []string{"HTTP_COMPRESSION_TYPE=gzip", "HTTP_SIGNATURE_BODY=uncompressed"},
+			signedBody:  func(r received) []byte { return r.uncompressed },
+			compression: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			url, ch := newServer(t)
+			environ := append([]string{"HTTP_ENDPOINT=" + url, "HTTP_SIGNATURE_HEADER=X-Signature", secrets}, tc.environ...)
+			p, err := NewHTTPProducer(environ)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = p.Close() })
+
+			for _, writeKey := range []string{"write-key-1", "write-key-2"} {
+				_, err = p.PublishTo(context.Background(), "key", message, map[string]string{"auth": writeKey})
+				require.NoError(t, err)
+
+				r := <-ch
+				require.Equal(t, message, r.uncompressed)
+				require.Equal(t, tc.compression, !bytes.Equal(r.body, message))
+				require.Equal(t, sign("secret-"+writeKey[len("write-key-"):], tc.signedBody(r)), r.signature)
+			}
+
+			_, err = p.PublishTo(context.Background(), "key", message, map[string]string{"auth": "write-key-3"})
+			require.ErrorContains(t, err, `missing signature secret for write key "write-key-3"`)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		url, ch := newServer(t)
+		p, err := NewHTTPProducer([]string{"HTTP_ENDPOINT=" + url, secrets})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+
+		_, err = p.PublishTo(context.Background(), "key", message, map[string]string{"auth": "write-key-3"})
+		require.NoError(t, err)
+		require.Empty(t, (<-ch).signature)
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		for _, environ := range [][]string{
+			{"HTTP_SIGNATURE_HEADER=X-Signature"},
+			{"HTTP_SIGNATURE_HEADER=X-Signature", "HTTP_SIGNATURE_SECRET=secret"},
+			{"HTTP_SIGNATURE_HEADER=X-Signature", `HTTP_SIGNATURE_SECRET={"write-key-1":""}`},
+			{"HTTP_SIGNATURE_HEADER=X Signature", secrets},
+			{"HTTP_SIGNATURE_HEADER=X-Signature", "HTTP_SIGNATURE_BODY=raw", secrets},
+		} {
+			_, err := NewHTTPProducer(append([]string{"HTTP_ENDPOINT=http://127.0.0.1:1"}, environ...))
+			require.Error(t, err, environ)
+		}
+	})
+}
+
+func TestValidateSignatureSecrets(t *testing.T) {
+	environ := []string{"HTTP_SIGNATURE_HEADER=X-Signature", `HTTP_SIGNATURE_SECRET={"write-key-1":"secret-1"}`}
+	require.NoError(t, ValidateSignatureSecrets(environ, []string{"write-key-1"}))
+	require.EqualError(t, ValidateSignatureSecrets(environ, []string{"write-key-1", "write-key-2"}),
+		`missing signature secret for write key "write-key-2"`,
+	)
+	require.NoError(t, ValidateSignatureSecrets(nil, []string{"write-key-1"}), "signing is disabled")
+	require.Error(t, ValidateSignatureSecrets(environ[:1], []string{"write-key-1"}), "the secret is missing")
+}
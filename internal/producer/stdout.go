@@ -1,19 +1,101 @@
 package producer
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
 )
 
-type StdOutPublisher struct{}
+type StdOutPublisher struct {
+	w          io.Writer
+	sampleRate float64 // fraction of the messages that are printed
+	pretty     bool
+	maxEvents  int64 // max number of printed messages, zero means no limit
 
-func NewStdoutPublisher() *StdOutPublisher {
-	return &StdOutPublisher{}
+	mu      sync.Mutex // avoids interleaving concurrent messages
+	printed atomic.Int64
 }
 
+type StdoutPublisherOption func(*StdOutPublisher)
+
+// WithWriter sets where the messages are printed, os.Stdout by default
+func WithWriter(w io.Writer) StdoutPublisherOption {
+	return func(p *StdOutPublisher) {
+		p.w = w
+	}
+}
+
+func NewStdoutPublisher(environ []string, opts ...StdoutPublisherOption) (*StdOutPublisher, error) {
+	conf, err := readConfiguration("STDOUT_", environ)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read stdout configuration: %v", err)
+	}
+	sampleRate, err := getOptionalFloatSetting(conf, "sample_rate", 1)
+	if err != nil {
+		return nil, err
+	}
+	if sampleRate < 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("sample rate has to be between 0 and 1: %v", sampleRate)
+	}
+	pretty, err := getOptionalBoolSetting(conf, "pretty", false)
+	if err != nil {
+		return nil, err
+	}
+	maxEvents, err := getOptionalIntSetting(conf, "max_events", 0)
+	if err != nil {
+		return nil, err
+	}
+	if maxEvents < 0 {
+		return nil, fmt.Errorf("max events cannot be negative: %d", maxEvents)
+	}
+
+	p := &StdOutPublisher{
+		w:          os.Stdout,
+		sampleRate: sampleRate,
+		pretty:     pretty,
+		maxEvents:  maxEvents,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p, nil
+}
+
+// PublishTo prints a sample of the messages (see STDOUT_SAMPLE_RATE) up to STDOUT_MAX_EVENTS.
+// Messages that are not printed are still reported as published.
 func (p *StdOutPublisher) PublishTo(_ context.Context, key string, message []byte, extra map[string]string) (int, error) {
-	_, err := fmt.Printf("%s %s [%v]\n\n", key, message, extra)
-	return 0, err
+	if p.sampleRate < 1 && rand.Float64() >= p.sampleRate {
+		return len(message), nil
+	}
+	if p.maxEvents > 0 && p.printed.Add(1) > p.maxEvents {
+		return len(message), nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.pretty {
+		if _, err := fmt.Fprintf(p.w, "%s %s [%v]\n\n", key, message, extra); err != nil {
+			return 0, err
+		}
+		return len(message), nil
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, message, "", "  "); err != nil {
+		buf.Reset() // not JSON, printed as it is
+		buf.Write(message)
+	}
+	if _, err := fmt.Fprintf(p.w, "--- key: %s writeKey: %s\n%s\n\n", key, extra["auth"], buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(message), nil
 }
 
 func (p *StdOutPublisher) Close() error {
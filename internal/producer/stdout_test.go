@@ -0,0 +1,76 @@
+package producer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStdoutPublisher(t *testing.T) {
+	message := []byte(`{"batch":[{"type":"track"}]}`)
+
+	newPublisher := func(t *testing.T, environ ...string) (*StdOutPublisher, *bytes.Buffer) {
+		t.Helper()
+		var buf bytes.Buffer
+		p, err := NewStdoutPublisher(environ, WithWriter(&buf))
+		require.NoError(t, err)
+		return p, &buf
+	}
+
+	t.Run("default", func(t *testing.T) {
+		p, buf := newPublisher(t)
+		n, err := p.PublishTo(context.Background(), "user-1", message, map[string]string{"auth": "wk"})
+		require.NoError(t, err)
+		require.Equal(t, len(message), n)
+		require.Equal(t, "user-1 "+string(message)+" [map[auth:wk]]\n\n", buf.String())
+	})
+	t.Run("pretty", func(t *testing.T) {
+		p, buf := newPublisher(t, "STDOUT_PRETTY=true")
+		_, err := p.PublishTo(context.Background(), "user-1", message, map[string]string{"auth": "wk"})
+		require.NoError(t, err)
+		require.Equal(t, `--- key: user-1 writeKey: wk
+{
+  "batch": [
+    {
+      "type": "track"
+    }
+  ]
+}
+
+`, buf.String())
+	})
+	t.Run("sampling", func(t *testing.T) {
+		p, buf := newPublisher(t, "STDOUT_SAMPLE_RATE=0")
+		for i := 0; i < 10; i++ {
+			n, err := p.PublishTo(context.Background(), "user-1", message, nil)
+			require.NoError(t, err)
+			require.Equal(t, len(message), n, "messages that are not printed are still published")
+		}
+		require.Empty(t, buf.String())
+
+		p, buf = newPublisher(t, "STDOUT_SAMPLE_RATE=0.5")
+		for i := 0; i < 1000; i++ {
+			_, err := p.PublishTo(context.Background(), "user-1", message, nil)
+			require.NoError(t, err)
+		}
+		require.InDelta(t, 500, strings.Count(buf.String(), "user-1"), 100)
+	})
+	t.Run("max events", func(t *testing.T) {
+		p, buf := newPublisher(t, "STDOUT_MAX_EVENTS=3")
+		for i := 0; i < 10; i++ {
+			n, err := p.PublishTo(context.Background(), "user-1", message, nil)
+			require.NoError(t, err)
+			require.Equal(t, len(message), n)
+		}
+		require.Equal(t, 3, strings.Count(buf.String(), "user-1"))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, environ := range []string{"STDOUT_SAMPLE_RATE=2", "STDOUT_SAMPLE_RATE=x", "STDOUT_PRETTY=x", "STDOUT_MAX_EVENTS=-1"} {
+			_, err := NewStdoutPublisher([]string{environ})
+			require.Error(t, err, environ)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package producer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// newTLSConfig builds the client TLS configuration from the tls_* settings, it returns nil if none is set
+func newTLSConfig(conf map[string]string) (*tls.Config, error) {
+	certFile, _ := getOptionalStringSetting(conf, "tls_cert_file", "")
+	keyFile, _ := getOptionalStringSetting(conf, "tls_key_file", "")
+	caFile, _ := getOptionalStringSetting(conf, "tls_ca_file", "")
+	insecureSkipVerify, err := getOptionalBoolSetting(conf, "tls_insecure_skip_verify", false)
+	if err != nil {
+		return nil, err
+	}
+	if certFile == "" && keyFile == "" && caFile == "" && !insecureSkipVerify {
+		return nil, nil
+	}
+
+	c := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecureSkipVerify, // only meant for test environments
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both the TLS cert file and key file are required: cert %q, key %q", certFile, keyFile)
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load TLS client certificate (cert %q, key %q): %w", certFile, keyFile, err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read TLS CA file %q: %w", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no valid PEM certificates in TLS CA file %q", caFile)
+		}
+		c.RootCAs = pool
+	}
+	return c, nil
+}
@@ -0,0 +1,118 @@
+package producer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPProducerTLS(t *testing.T) {
+	dir := t.TempDir()
+	writePEM := func(t *testing.T, name, blockType string, der []byte) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+		return path
+	}
+
+	// a CA signing the client certificate
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rudder-load-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "rudder-load"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, &clientKey.PublicKey, caKey)
+	require.NoError(t, err)
+	clientKeyDER, err := x509.MarshalECPrivateKey(clientKey)
+	require.NoError(t, err)
+	certFile := writePEM(t, "client.crt", "CERTIFICATE", clientDER)
+	keyFile := writePEM(t, "client.key", "EC PRIVATE KEY", clientKeyDER)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	serverCAFile := writePEM(t, "server-ca.crt", "CERTIFICATE", srv.Certificate().Raw)
+
+	publish := func(t *testing.T, environ ...string) error {
+		t.Helper()
+		p, err := NewHTTPProducer(append(environ, "HTTP_ENDPOINT="+srv.URL))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+		_, err = p.PublishTo(context.Background(), "key", []byte("message"), nil)
+		return err
+	}
+
+	t.Run("client certificate", func(t *testing.T) {
+		require.NoError(t, publish(t,
+			"HTTP_TLS_CERT_FILE="+certFile,
+			"HTTP_TLS_KEY_FILE="+keyFile,
+			"HTTP_TLS_CA_FILE="+serverCAFile,
+		))
+	})
+	t.Run("insecure skip verify", func(t *testing.T) {
+		require.NoError(t, publish(t,
+			"HTTP_TLS_CERT_FILE="+certFile,
+			"HTTP_TLS_KEY_FILE="+keyFile,
+			"HTTP_TLS_INSECURE_SKIP_VERIFY=true",
+		))
+	})
+	t.Run("without client certificate", func(t *testing.T) {
+		require.Error(t, publish(t, "HTTP_TLS_CA_FILE="+serverCAFile))
+	})
+	t.Run("unknown server CA", func(t *testing.T) {
+		require.Error(t, publish(t, "HTTP_TLS_CERT_FILE="+certFile, "HTTP_TLS_KEY_FILE="+keyFile))
+	})
+	t.Run("invalid settings", func(t *testing.T) {
+		missing := filepath.Join(dir, "missing.crt")
+		for name, environ := range map[string][]string{
+			"missing cert file": {"HTTP_TLS_CERT_FILE=" + missing, "HTTP_TLS_KEY_FILE=" + keyFile},
+			"missing key file":  {"HTTP_TLS_CERT_FILE=" + certFile},
+			"missing CA file":   {"HTTP_TLS_CA_FILE=" + missing},
+			"invalid CA file":   {"HTTP_TLS_CA_FILE=" + keyFile},
+		} {
+			_, err := NewHTTPProducer(append(environ, "HTTP_ENDPOINT="+srv.URL))
+			require.Error(t, err, name)
+		}
+		_, err := NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL, "HTTP_TLS_CA_FILE=" + missing})
+		require.ErrorContains(t, err, missing)
+	})
+}
@@ -0,0 +1,93 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracer starts a span for every request and injects the W3C trace context headers (i.e. traceparent) into it.
+// A nil tracer disables tracing.
+func WithTracer(tracer trace.Tracer) HTTPProducerOption {
+	return func(p *HTTPProducer) {
+		p.tracer = tracer
+	}
+}
+
+// NewOTLPTracerProvider returns a tracer provider exporting the spans via OTLP, the exporter and the sampler are
+// configured with the standard OTEL_EXPORTER_OTLP_* and OTEL_TRACES_SAMPLER* env variables.
+// The given attributes are added to the resource of every span (e.g. deployment and mode).
+func NewOTLPTracerProvider(ctx context.Context, attributes map[string]string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create OTLP traces exporter: %w", err)
+	}
+	attrs := make([]attribute.KeyValue, 0, len(attributes))
+	for k, v := range attributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attrs...))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create traces resource: %w", err)
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res)), nil
+}
+
+// startSpan starts the span of a request to the given endpoint and injects its context into the request headers.
+// The slot, if any, is read from extra["slot"].
+func (p *HTTPProducer) startSpan(ctx context.Context, req *fasthttp.Request, endpoint string, extra map[string]string) trace.Span {
+	attrs := []attribute.KeyValue{
+		attribute.String("url.full", endpoint),
+		attribute.Int("http.request.body.size", len(req.Body())),
+	}
+	if eventType, ok := extra["event_type"]; ok {
+		attrs = append(attrs, attribute.String("event_type", eventType))
+	}
+	if slot, ok := extra["slot"]; ok {
+		attrs = append(attrs, attribute.String("slot", slot))
+	}
+	ctx, span := p.tracer.Start(ctx, "publish", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	propagation.TraceContext{}.Inject(ctx, fasthttpHeaderCarrier{h: &req.Header})
+	return span
+}
+
+// endSpan records the response status code (zero if no response was received) and the error, if any
+func endSpan(span trace.Span, statusCode int, err error) {
+	if statusCode > 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// fasthttpHeaderCarrier adapts the fasthttp request headers to the OpenTelemetry propagators
+type fasthttpHeaderCarrier struct {
+	h *fasthttp.RequestHeader
+}
+
+func (c fasthttpHeaderCarrier) Get(key string) string {
+	return string(c.h.Peek(key))
+}
+
+func (c fasthttpHeaderCarrier) Set(key, value string) {
+	c.h.Set(key, value)
+}
+
+func (c fasthttpHeaderCarrier) Keys() []string {
+	var keys []string
+	c.h.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
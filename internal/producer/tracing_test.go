@@ -0,0 +1,96 @@
+package producer
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHTTPProducerTracing(t *testing.T) {
+	traceparents := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+
+	p, err := NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL + "/v1/batch"}, WithTracer(tp.Tracer("test")))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+
+	attributes := func(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+
+	t.Run("success", func(t *testing.T) {
+		exporter.Reset()
+		message := []byte(`{"batch":[{"type":"track"}]}`)
+		_, err := p.PublishTo(context.Background(), "key", message, map[string]string{
+			"auth": "write-key", "event_type": "track", "slot": "3",
+		})
+		require.NoError(t, err)
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		span := spans[0]
+		require.Equal(t, "publish", span.Name)
+		require.Equal(t, trace.SpanKindClient, span.SpanKind)
+		require.Equal(t, codes.Unset, span.Status.Code)
+
+		attrs := attributes(span)
+		require.EqualValues(t, http.StatusOK, attrs["http.response.status_code"].AsInt64())
+		require.EqualValues(t, len(message), attrs["http.request.body.size"].AsInt64())
+		require.Equal(t, "track", attrs["event_type"].AsString())
+		require.Equal(t, "3", attrs["slot"].AsString())
+
+		// traceparent: version-traceid-spanid-flags
+		require.Equal(t,
+			"00-"+span.SpanContext.TraceID().String()+"-"+span.SpanContext.SpanID().String()+"-01",
+			<-traceparents,
+		)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		exporter.Reset()
+		_, err := p.PublishTo(context.Background(), "key", []byte("{}"), map[string]string{
+			"auth": "write-key", "query_params": "fail=true",
+		})
+		require.Error(t, err)
+		<-traceparents
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		require.Equal(t, codes.Error, spans[0].Status.Code)
+		require.EqualValues(t, http.StatusBadRequest, attributes(spans[0])["http.response.status_code"].AsInt64())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		p, err := NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL + "/v1/batch"})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = p.Close() })
+
+		_, err = p.PublishTo(context.Background(), "key", []byte("{}"), map[string]string{"auth": "write-key"})
+		require.NoError(t, err)
+		require.Empty(t, <-traceparents)
+	})
+}
@@ -0,0 +1,112 @@
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	// ValidatorTypeExact expects the response body to be exactly the given text, e.g. "exact:OK"
+	ValidatorTypeExact = "exact"
+	// ValidatorTypeJSONStatus expects a JSON array of messages (or an object with a "messages" array) all having
+	// "success" as status, e.g. [{"status":"success"},{"status":"success"}]
+	ValidatorTypeJSONStatus = "json-status"
+	// ValidatorTypeRegex expects the response body to match the given pattern, e.g. "regex:^[0-9]+$"
+	ValidatorTypeRegex = "regex"
+)
+
+// ResponseValidator returns an error if the body of a 200 OK response is not the expected one
+type ResponseValidator func(body []byte) error
+
+// ResponseValidationError is returned when the server replies with 200 OK but the response body is not valid
+type ResponseValidationError struct {
+	Body string
+	Err  error
+}
+
+func (e *ResponseValidationError) Error() string {
+	return fmt.Sprintf("invalid response body: %v: %s", e.Err, e.Body)
+}
+
+func (e *ResponseValidationError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatusCode returns the status code of the response, the bodies are validated only on 200 OK responses
+func (e *ResponseValidationError) HTTPStatusCode() int {
+	return http.StatusOK
+}
+
+// responseValidators builds the validators from the argument following the type (e.g. "OK" for "exact:OK")
+var responseValidators = map[string]func(arg string) (ResponseValidator, error){
+	ValidatorTypeExact: func(arg string) (ResponseValidator, error) {
+		return func(body []byte) error {
+			if string(body) != arg {
+				return fmt.Errorf("expected %q", arg)
+			}
+			return nil
+		}, nil
+	},
+	ValidatorTypeJSONStatus: func(arg string) (ResponseValidator, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("%s does not take any argument: %s", ValidatorTypeJSONStatus, arg)
+		}
+		return validateJSONStatus, nil
+	},
+	ValidatorTypeRegex: func(arg string) (ResponseValidator, error) {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+		}
+		return func(body []byte) error {
+			if !re.Match(body) {
+				return fmt.Errorf("expected to match %q", arg)
+			}
+			return nil
+		}, nil
+	},
+}
+
+// NewResponseValidator returns the validator of the given VALIDATOR_TYPE, i.e. the validator type optionally
+// followed by ":" and its argument (e.g. "exact:OK", "json-status" or "regex:^[0-9]+$").
+// It returns nil if validatorType is empty.
+func NewResponseValidator(validatorType string) (ResponseValidator, error) {
+	if validatorType == "" {
+		return nil, nil
+	}
+	name, arg, _ := strings.Cut(validatorType, ":")
+	newValidator, ok := responseValidators[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown validator type %q: expected %s:<body>, %s or %s:<pattern>",
+			name, ValidatorTypeExact, ValidatorTypeJSONStatus, ValidatorTypeRegex,
+		)
+	}
+	return newValidator(arg)
+}
+
+type messageStatus struct {
+	Status string `json:"status"`
+}
+
+// validateJSONStatus returns an error with the index of the first message whose status is not "success"
+func validateJSONStatus(body []byte) error {
+	var statuses []messageStatus
+	if err := json.Unmarshal(body, &statuses); err != nil {
+		var wrapped struct {
+			Messages []messageStatus `json:"messages"`
+		}
+		if err := json.Unmarshal(body, &wrapped); err != nil || wrapped.Messages == nil {
+			return fmt.Errorf("expected a JSON array of message statuses")
+		}
+		statuses = wrapped.Messages
+	}
+	for i, s := range statuses {
+		if s.Status != "success" {
+			return fmt.Errorf("message %d has status %q", i, s.Status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package producer
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseValidators(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		v, err := NewResponseValidator("")
+		require.NoError(t, err)
+		require.Nil(t, v)
+	})
+
+	t.Run("exact", func(t *testing.T) {
+		v, err := NewResponseValidator("exact:OK")
+		require.NoError(t, err)
+		require.NoError(t, v([]byte("OK")))
+		require.Error(t, v([]byte("OK\n")))
+		require.Error(t, v(nil))
+
+		v, err = NewResponseValidator("exact:")
+		require.NoError(t, err)
+		require.NoError(t, v(nil), "an empty body is expected")
+	})
+
+	t.Run("json-status", func(t *testing.T) {
+		v, err := NewResponseValidator("json-status")
+		require.NoError(t, err)
+		require.NoError(t, v([]byte(`[{"status":"success"},{"status":"success"}]`)))
+		require.NoError(t, v([]byte(`{"messages":[{"status":"success","id":"1"}]}`)))
+		require.NoError(t, v([]byte(`[]`)))
+
+		err = v([]byte(`[{"status":"success"},{"status":"success"},{"status":"failed"},{"status":"dropped"}]`))
+		require.EqualError(t, err, `message 2 has status "failed"`)
+		err = v([]byte(`{"messages":[{"status":"aborted"},{"status":"success"}]}`))
+		require.EqualError(t, err, `message 0 has status "aborted"`)
+		require.EqualError(t, v([]byte(`[{"id":"1"}]`)), `message 0 has status ""`)
+
+		for _, body := range []string{"OK", "", `{"status":"success"}`, `{"messages":"success"}`} {
+			require.Error(t, v([]byte(body)), body)
+		}
+
+		_, err = NewResponseValidator("json-status:strict")
+		require.Error(t, err)
+	})
+
+	t.Run("regex", func(t *testing.T) {
+		v, err := NewResponseValidator("regex:^[0-9]+$")
+		require.NoError(t, err)
+		require.NoError(t, v([]byte("42")))
+		require.Error(t, v([]byte("42 events")))
+
+		v, err = NewResponseValidator("regex:a:b")
+		require.NoError(t, err)
+		require.NoError(t, v([]byte("a:b")), "the pattern can contain colons")
+
+		_, err = NewResponseValidator("regex:[")
+		require.Error(t, err)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewResponseValidator("json")
+		require.Error(t, err)
+	})
+}
+
+func TestHTTPProducerResponseValidator(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Query().Get("body")))
+	}))
+	t.Cleanup(srv.Close)
+
+	v, err := NewResponseValidator("exact:OK")
+	require.NoError(t, err)
+	p, err := NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL + "/v1/batch"}, WithResponseValidator(v))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close() })
+
+	_, err = p.PublishTo(context.Background(), "key", []byte("{}"), map[string]string{
+		"auth": "write-key", "query_params": "body=OK",
+	})
+	require.NoError(t, err)
+
+	_, err = p.PublishTo(context.Background(), "key", []byte("{}"), map[string]string{
+		"auth": "write-key", "query_params": "body=Accepted",
+	})
+	var validationErr *ResponseValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Equal(t, "Accepted", validationErr.Body)
+	require.False(t, isEndpointFailure(err), "the endpoint replied")
+}
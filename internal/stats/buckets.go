@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// defaultDurationBuckets are 0.5ms, 10ms, 25ms, 50ms, 100ms, 250ms, 500ms, 1s, 2.5s, 5s, 10s
+	defaultDurationBuckets = []float64{0.0005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	// defaultPayloadSizeBuckets are in bytes
+	defaultPayloadSizeBuckets = []float64{10, 50, 100, 250, 500, 1000, 2000, 3000, 4000, 5000, 10000}
+	// defaultPayloadSizeBytesBuckets are 256B, 512B, 1KiB, ..., 2MiB, 4MiB
+	defaultPayloadSizeBytesBuckets = prometheus.ExponentialBuckets(256, 2, 15)
+)
+
+// buckets holds the buckets of the histograms owned by the Factory
+type buckets struct {
+	publishDuration      []float64 // PUBLISH_DURATION_BUCKETS
+	httpResponseDuration []float64 // HTTP_RESPONSE_DURATION_BUCKETS
+	payloadSize          []float64 // PUBLISH_PAYLOAD_SIZE_BUCKETS
+	payloadSizeBytes     []float64 // PAYLOAD_SIZE_BYTES_BUCKETS
+}
+
+// bucketsFromEnv returns the default buckets overridden by the env variables, if set
+func bucketsFromEnv() (buckets, error) {
+	var (
+		b   buckets
+		err error
+	)
+	if b.publishDuration, err = getBucketsSetting("PUBLISH_DURATION_BUCKETS", defaultDurationBuckets); err != nil {
+		return b, err
+	}
+	if b.httpResponseDuration, err = getBucketsSetting("HTTP_RESPONSE_DURATION_BUCKETS", defaultDurationBuckets); err != nil {
+		return b, err
+	}
+	if b.payloadSize, err = getBucketsSetting("PUBLISH_PAYLOAD_SIZE_BUCKETS", defaultPayloadSizeBuckets); err != nil {
+		return b, err
+	}
+	if b.payloadSizeBytes, err = getBucketsSetting("PAYLOAD_SIZE_BYTES_BUCKETS", defaultPayloadSizeBytesBuckets); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// getBucketsSetting parses the comma separated buckets of the given env variable (e.g. "0.005,0.01,0.05,0.1").
+// The buckets have to be in ascending order.
+func getBucketsSetting(key string, defaultValue []float64) ([]float64, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue, nil
+	}
+	parts := strings.Split(value, ",")
+	result := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		bucket, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q in %s: %w", part, key, err)
+		}
+		if len(result) > 0 && bucket <= result[len(result)-1] {
+			return nil, fmt.Errorf("buckets in %s should be in ascending order: %s", key, value)
+		}
+		result = append(result, bucket)
+	}
+	return result, nil
+}
@@ -0,0 +1,144 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+type Option func(*Factory)
+
+// WithMeterProvider mirrors the metrics to the given OpenTelemetry meter provider (e.g. one pushing via OTLP)
+// with the same names and attributes as the Prometheus ones
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(f *Factory) {
+		f.meterProvider = mp
+	}
+}
+
+// NewOTLPMeterProvider returns a meter provider that periodically pushes the metrics via OTLP gRPC.
+// The exporter is configured via the standard environment variables (e.g. OTEL_EXPORTER_OTLP_ENDPOINT and
+// OTEL_METRIC_EXPORT_INTERVAL). Shutdown has to be called to flush the metrics before exiting.
+func NewOTLPMeterProvider(ctx context.Context) (*sdkmetric.MeterProvider, error) {
+	exporter, err := otlpmetricgrpc.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create OTLP metrics exporter: %w", err)
+	}
+	return sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter))), nil
+}
+
+// otelInstruments mirrors the Prometheus metrics of the Factory
+type otelInstruments struct {
+	attributes []attribute.KeyValue // the Prometheus const labels
+
+	publishDurationSeconds metric.Float64Histogram
+	errorRateTotal         metric.Int64Counter
+	messagesTotal          metric.Int64Counter
+	payloadSize            metric.Float64Histogram
+	payloadBytesTotal      metric.Int64Counter
+	sentBytesTotal         metric.Int64Counter
+	payloadSizeBytes       metric.Float64Histogram
+	httpResponses          metric.Int64Counter
+	httpResponseDuration   metric.Float64Histogram
+}
+
+func newOTelInstruments(mp metric.MeterProvider, prefix string, constLabels map[string]string, b buckets) (*otelInstruments, error) {
+	var (
+		err   error
+		meter = mp.Meter("rudder-load")
+		o     = &otelInstruments{}
+	)
+	for k, v := range constLabels {
+		o.attributes = append(o.attributes, attribute.String(k, v))
+	}
+
+	if o.publishDurationSeconds, err = meter.Float64Histogram(prefix+"publish_duration_seconds",
+		metric.WithDescription("Publish duration in seconds"),
+		metric.WithExplicitBucketBoundaries(b.publishDuration...),
+	); err != nil {
+		return nil, err
+	}
+	if o.errorRateTotal, err = meter.Int64Counter(prefix+"publish_error_rate_total",
+		metric.WithDescription("Total error rate"),
+	); err != nil {
+		return nil, err
+	}
+	if o.messagesTotal, err = meter.Int64Counter(prefix+"publish_messages_total",
+		metric.WithDescription("Total messages sent"),
+	); err != nil {
+		return nil, err
+	}
+	if o.payloadSize, err = meter.Float64Histogram(prefix+"publish_payload_size",
+		metric.WithDescription("Payload size in bytes"),
+		metric.WithExplicitBucketBoundaries(b.payloadSize...),
+	); err != nil {
+		return nil, err
+	}
+	if o.payloadBytesTotal, err = meter.Int64Counter(prefix+"publish_payload_bytes_total",
+		metric.WithDescription("Total bytes of the published payloads before compression"),
+	); err != nil {
+		return nil, err
+	}
+	if o.sentBytesTotal, err = meter.Int64Counter(prefix+"publish_sent_bytes_total",
+		metric.WithDescription("Total bytes sent over the wire (i.e. after compression)"),
+	); err != nil {
+		return nil, err
+	}
+	if o.payloadSizeBytes, err = meter.Float64Histogram(prefix+"payload_size_bytes",
+		metric.WithDescription("Uncompressed payload size in bytes"),
+		metric.WithExplicitBucketBoundaries(b.payloadSizeBytes...),
+	); err != nil {
+		return nil, err
+	}
+	if o.httpResponses, err = meter.Int64Counter(prefix+"http_responses_count",
+		metric.WithDescription("Number of HTTP responses per status code"),
+	); err != nil {
+		return nil, err
+	}
+	if o.httpResponseDuration, err = meter.Float64Histogram(prefix+"http_response_duration_seconds",
+		metric.WithDescription("HTTP response duration in seconds per status class (e.g. 2xx, 4xx, 5xx)"),
+		metric.WithExplicitBucketBoundaries(b.httpResponseDuration...),
+	); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// with returns the const labels attributes plus the given label values
+func (o *otelInstruments) with(labels ...attribute.KeyValue) metric.MeasurementOption {
+	return metric.WithAttributes(append(labels, o.attributes...)...)
+}
+
+func (o *otelInstruments) recordPayloadSize(ctx context.Context, size int) {
+	o.payloadSizeBytes.Record(ctx, float64(size), o.with())
+}
+
+func (o *otelInstruments) recordPublish(
+	ctx context.Context, eventType, queryParams string, failed bool, elapsed float64, size, sent int,
+) {
+	if failed {
+		o.errorRateTotal.Add(ctx, 1, o.with())
+	} else {
+		o.messagesTotal.Add(ctx, 1, o.with())
+		o.payloadSize.Record(ctx, float64(size), o.with())
+		o.payloadBytesTotal.Add(ctx, int64(size), o.with())
+		o.sentBytesTotal.Add(ctx, int64(sent), o.with())
+	}
+	o.publishDurationSeconds.Record(ctx, elapsed, o.with(
+		attribute.String(errorLabel, strconv.FormatBool(failed)),
+		attribute.String(eventTypeLabel, eventType),
+		attribute.String(queryParamsLabel, queryParams),
+	))
+}
+
+func (o *otelInstruments) recordHTTPResponse(ctx context.Context, statusCode int, elapsed float64) {
+	o.httpResponses.Add(ctx, 1, o.with(attribute.String(statusCodeLabel, strconv.Itoa(statusCode))))
+	o.httpResponseDuration.Record(ctx, elapsed, o.with(
+		attribute.String(statusClassLabel, strconv.Itoa(statusCode/100)+"xx"),
+	))
+}
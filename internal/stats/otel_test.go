@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestWithMeterProvider(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	t.Cleanup(func() { _ = mp.Shutdown(context.Background()) })
+
+	reg := prometheus.NewRegistry()
+	f, err := NewFactory(reg, Data{Prefix: "test_", Mode: "stdout", WriteKey: "wk"}, WithMeterProvider(mp))
+	require.NoError(t, err)
+
+	s := f.New(fakePublisher{})
+	for _, eventType := range []string{"track", "track", "page"} {
+		_, err := s.PublishTo(context.Background(), "key", make([]byte, 100), map[string]string{"event_type": eventType})
+		require.NoError(t, err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m.Data
+	}
+
+	messages, ok := metrics["test_publish_messages_total"].(metricdata.Sum[int64])
+	require.True(t, ok)
+	require.Len(t, messages.DataPoints, 1)
+	require.EqualValues(t, 3, messages.DataPoints[0].Value)
+	mode, ok := messages.DataPoints[0].Attributes.Value("mode")
+	require.True(t, ok)
+	require.Equal(t, "stdout", mode.AsString())
+	writeKey, ok := messages.DataPoints[0].Attributes.Value("write_key")
+	require.True(t, ok)
+	require.Equal(t, "wk", writeKey.AsString())
+
+	sentBytes, ok := metrics["test_publish_sent_bytes_total"].(metricdata.Sum[int64])
+	require.True(t, ok)
+	require.Len(t, sentBytes.DataPoints, 1)
+	require.EqualValues(t, 300, sentBytes.DataPoints[0].Value)
+
+	durations, ok := metrics["test_publish_duration_seconds"].(metricdata.Histogram[float64])
+	require.True(t, ok)
+	counts := make(map[string]uint64)
+	for _, dp := range durations.DataPoints {
+		failed, ok := dp.Attributes.Value(attribute.Key(errorLabel))
+		require.True(t, ok)
+		require.Equal(t, "false", failed.AsString())
+		eventType, ok := dp.Attributes.Value(attribute.Key(eventTypeLabel))
+		require.True(t, ok)
+		counts[eventType.AsString()] = dp.Count
+	}
+	require.Equal(t, map[string]uint64{"track": 2, "page": 1}, counts)
+
+	_, ok = metrics["test_http_responses_count"]
+	require.False(t, ok, "no HTTP responses are recorded for non HTTP publishers")
+}
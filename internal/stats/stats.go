@@ -4,14 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/metric"
 )
 
 const (
-	errorLabel = "error"
+	errorLabel       = "error"
+	eventTypeLabel   = "event_type"
+	queryParamsLabel = "query_params"
+	statusCodeLabel  = "status_code"
+	statusClassLabel = "status_class"
 )
 
 type publisher interface {
@@ -19,6 +25,12 @@ type publisher interface {
 	Close() error
 }
 
+// statusCoder is implemented by the publishing errors that carry the status code of an HTTP response
+// (e.g. producer.HTTPStatusError)
+type statusCoder interface {
+	HTTPStatusCode() int
+}
+
 type Stats struct {
 	p publisher
 	f *Factory
@@ -35,7 +47,10 @@ type Data struct {
 }
 
 type Factory struct {
-	reg *prometheus.Registry
+	reg                 *prometheus.Registry
+	meterProvider       metric.MeterProvider
+	otel                *otelInstruments // nil unless a meter provider is set
+	recordHTTPResponses bool             // whether the response status codes should be recorded
 
 	// metrics
 	createTopicDurationSeconds *prometheus.HistogramVec
@@ -43,9 +58,22 @@ type Factory struct {
 	errorRateTotal             prometheus.Counter
 	messagesTotal              prometheus.Counter
 	payloadSize                prometheus.Histogram
+	payloadBytesTotal          prometheus.Counter
+	sentBytesTotal             prometheus.Counter
+	payloadSizeBytes           prometheus.Histogram
+	httpResponses              *prometheus.CounterVec
+	httpResponseDuration       *prometheus.HistogramVec
+}
+
+// WithHTTPResponses records the status code of the responses, the publishers return no error only on 200 OK
+// responses and an error implementing HTTPStatusCode() int on any other response
+func WithHTTPResponses() Option {
+	return func(f *Factory) {
+		f.recordHTTPResponses = true
+	}
 }
 
-func NewFactory(reg *prometheus.Registry, data Data) (*Factory, error) {
+func NewFactory(reg *prometheus.Registry, data Data, opts ...Option) (*Factory, error) {
 	if reg == nil {
 		return nil, fmt.Errorf("prometheus registry is nil")
 	}
@@ -59,12 +87,16 @@ func NewFactory(reg *prometheus.Registry, data Data) (*Factory, error) {
 		"total_users": strconv.Itoa(data.TotalUsers),
 	}
 
-	publishDurationSecondsLabels := []string{errorLabel}
+	buckets, err := bucketsFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("invalid histogram buckets: %w", err)
+	}
+
+	publishDurationSecondsLabels := []string{errorLabel, eventTypeLabel, queryParamsLabel}
 	publishDurationSeconds := prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name: data.Prefix + "publish_duration_seconds",
-		Help: "Publish duration in seconds",
-		// Buckets: []float64{0.5ms, 10ms, 25ms, 50ms, 100ms, 250ms, 500ms, 1s, 2.5s, 5s, 10s}
-		Buckets:     []float64{0.0005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		Name:        data.Prefix + "publish_duration_seconds",
+		Help:        "Publish duration in seconds",
+		Buckets:     buckets.publishDuration,
 		ConstLabels: constLabels,
 	}, publishDurationSecondsLabels)
 	reg.MustRegister(publishDurationSeconds)
@@ -86,18 +118,70 @@ func NewFactory(reg *prometheus.Registry, data Data) (*Factory, error) {
 	payloadSize := prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name:        data.Prefix + "publish_payload_size",
 		Help:        "Payload size in bytes",
-		Buckets:     []float64{10, 50, 100, 250, 500, 1000, 2000, 3000, 4000, 5000, 10000},
+		Buckets:     buckets.payloadSize,
 		ConstLabels: constLabels,
 	})
 	reg.MustRegister(payloadSize)
 
-	return &Factory{
+	payloadBytesTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        data.Prefix + "publish_payload_bytes_total",
+		Help:        "Total bytes of the published payloads before compression",
+		ConstLabels: constLabels,
+	})
+	reg.MustRegister(payloadBytesTotal)
+
+	sentBytesTotal := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        data.Prefix + "publish_sent_bytes_total",
+		Help:        "Total bytes sent over the wire (i.e. after compression)",
+		ConstLabels: constLabels,
+	})
+	reg.MustRegister(sentBytesTotal)
+
+	payloadSizeBytes := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:        data.Prefix + "payload_size_bytes",
+		Help:        "Uncompressed payload size in bytes",
+		Buckets:     buckets.payloadSizeBytes,
+		ConstLabels: constLabels,
+	})
+	reg.MustRegister(payloadSizeBytes)
+
+	httpResponses := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        data.Prefix + "http_responses_count",
+		Help:        "Number of HTTP responses per status code",
+		ConstLabels: constLabels,
+	}, []string{statusCodeLabel})
+	reg.MustRegister(httpResponses)
+
+	httpResponseDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:        data.Prefix + "http_response_duration_seconds",
+		Help:        "HTTP response duration in seconds per status class (e.g. 2xx, 4xx, 5xx)",
+		Buckets:     buckets.httpResponseDuration,
+		ConstLabels: constLabels,
+	}, []string{statusClassLabel})
+	reg.MustRegister(httpResponseDuration)
+
+	f := &Factory{
 		reg:                    reg,
 		publishDurationSeconds: publishDurationSeconds,
 		errorRateTotal:         errorRateTotal,
 		messagesTotal:          messagesTotal,
 		payloadSize:            payloadSize,
-	}, nil
+		payloadBytesTotal:      payloadBytesTotal,
+		sentBytesTotal:         sentBytesTotal,
+		payloadSizeBytes:       payloadSizeBytes,
+		httpResponses:          httpResponses,
+		httpResponseDuration:   httpResponseDuration,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.meterProvider != nil {
+		if f.otel, err = newOTelInstruments(f.meterProvider, data.Prefix, constLabels, buckets); err != nil {
+			return nil, fmt.Errorf("cannot create OpenTelemetry instruments: %w", err)
+		}
+	}
+
+	return f, nil
 }
 
 func (f *Factory) New(p publisher) *Stats {
@@ -107,6 +191,8 @@ func (f *Factory) New(p publisher) *Stats {
 	}
 }
 
+// PublishTo records the result of publishing a message once, so the retries have to happen in the wrapped
+// publisher (e.g. the HTTP_MAX_RETRIES of the HTTP producer) for only the final failure to be counted as an error
 func (s *Stats) PublishTo(ctx context.Context, key string, message []byte, extra map[string]string) (int, error) {
 	start := time.Now()
 	n, err := s.p.PublishTo(ctx, key, message, extra)
@@ -116,8 +202,15 @@ func (s *Stats) PublishTo(ctx context.Context, key string, message []byte, extra
 		return 0, err
 	}
 
+	s.f.payloadSizeBytes.Observe(float64(len(message)))
+	if s.f.otel != nil {
+		s.f.otel.recordPayloadSize(ctx, len(message))
+	}
+
 	labels := prometheus.Labels{
-		errorLabel: "false",
+		errorLabel:       "false",
+		eventTypeLabel:   extra["event_type"],   // empty if the event type is not available
+		queryParamsLabel: extra["query_params"], // empty if no query params are appended (see HTTP_QUERY_PARAMS)
 	}
 	if err != nil {
 		s.f.errorRateTotal.Inc()
@@ -125,12 +218,42 @@ func (s *Stats) PublishTo(ctx context.Context, key string, message []byte, extra
 	} else {
 		s.f.messagesTotal.Inc()
 		s.f.payloadSize.Observe(float64(len(message)))
+		s.f.payloadBytesTotal.Add(float64(len(message)))
+		s.f.sentBytesTotal.Add(float64(n))
 	}
 	s.f.publishDurationSeconds.With(labels).Observe(elapsed)
+	if s.f.otel != nil {
+		s.f.otel.recordPublish(ctx, labels[eventTypeLabel], labels[queryParamsLabel], err != nil, elapsed, len(message), n)
+	}
+
+	if s.f.recordHTTPResponses {
+		s.observeHTTPResponse(ctx, err, elapsed)
+	}
 
 	return n, err
 }
 
+// observeHTTPResponse records the status code of the response, if any (e.g. there is none on timeouts).
+// No error means a 200 OK response, otherwise the status code is taken from the error (see statusCoder).
+func (s *Stats) observeHTTPResponse(ctx context.Context, err error, elapsed float64) {
+	var (
+		statusCode = http.StatusOK
+		statusErr  statusCoder
+	)
+	switch {
+	case err == nil:
+	case errors.As(err, &statusErr):
+		statusCode = statusErr.HTTPStatusCode()
+	default:
+		return
+	}
+	s.f.httpResponses.WithLabelValues(strconv.Itoa(statusCode)).Inc()
+	s.f.httpResponseDuration.WithLabelValues(strconv.Itoa(statusCode/100) + "xx").Observe(elapsed)
+	if s.f.otel != nil {
+		s.f.otel.recordHTTPResponse(ctx, statusCode, elapsed)
+	}
+}
+
 func (s *Stats) Close() error {
 	return s.p.Close()
 }
@@ -0,0 +1,347 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rudderlabs/rudder-go-kit/testhelper/httptest"
+	"github.com/stretchr/testify/require"
+
+	"rudder-load/internal/producer"
+)
+
+type fakePublisher struct{}
+
+func (fakePublisher) PublishTo(_ context.Context, _ string, message []byte, _ map[string]string) (int, error) {
+	return len(message), nil
+}
+
+func (fakePublisher) Close() error { return nil }
+
+type canceledPublisher struct{ fakePublisher }
+
+func (canceledPublisher) PublishTo(context.Context, string, []byte, map[string]string) (int, error) {
+	return 0, context.Canceled
+}
+
+func TestPayloadSizeBytes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	f, err := NewFactory(reg, Data{Prefix: "test_", Mode: "stdout"})
+	require.NoError(t, err)
+
+	s := f.New(fakePublisher{})
+	for _, size := range []int{100, 256, 300, 5000, 3 << 20, 10 << 20} {
+		_, err := s.PublishTo(context.Background(), "key", make([]byte, size), nil)
+		require.NoError(t, err)
+	}
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	buckets := make(map[float64]uint64)
+	for _, mf := range families {
+		if mf.GetName() != "test_payload_size_bytes" {
+			continue
+		}
+		require.Len(t, mf.GetMetric(), 1)
+		h := mf.GetMetric()[0].GetHistogram()
+		require.EqualValues(t, 6, h.GetSampleCount())
+		for _, b := range h.GetBucket() {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+	}
+	require.Len(t, buckets, 15)
+	require.EqualValues(t, 2, buckets[256])     // 100, 256
+	require.EqualValues(t, 3, buckets[512])     // 300
+	require.EqualValues(t, 3, buckets[4096])    // nothing between 512 and 4KiB
+	require.EqualValues(t, 4, buckets[8192])    // 5000
+	require.EqualValues(t, 5, buckets[4194304]) // 3MiB, while 10MiB only lands in +Inf
+
+	t.Run("canceled publishes are not observed", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		f, err := NewFactory(reg, Data{Prefix: "test_", Mode: "stdout"})
+		require.NoError(t, err)
+
+		_, err = f.New(canceledPublisher{}).PublishTo(context.Background(), "key", make([]byte, 100), nil)
+		require.ErrorIs(t, err, context.Canceled)
+
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		for _, mf := range families {
+			if mf.GetName() == "test_payload_size_bytes" {
+				require.Zero(t, mf.GetMetric()[0].GetHistogram().GetSampleCount())
+			}
+		}
+	})
+}
+
+func TestPublishDurationLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	f, err := NewFactory(reg, Data{Prefix: "test_", Mode: "stdout"})
+	require.NoError(t, err)
+
+	s := f.New(fakePublisher{})
+	for _, extra := range []map[string]string{
+		{"event_type": "track", "query_params": "routing=fast"},
+		{"event_type": "track", "query_params": "routing=fast"},
+		{"event_type": "track", "query_params": "routing=slow"},
+		{"event_type": "page"},
+	} {
+		_, err := s.PublishTo(context.Background(), "key", []byte("{}"), extra)
+		require.NoError(t, err)
+	}
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	counts := make(map[string]uint64) // event type and query params to number of publishes
+	for _, mf := range families {
+		if mf.GetName() != "test_publish_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			counts[labels[eventTypeLabel]+"?"+labels[queryParamsLabel]] = m.GetHistogram().GetSampleCount()
+		}
+	}
+	require.Equal(t, map[string]uint64{"track?routing=fast": 2, "track?routing=slow": 1, "page?": 1}, counts)
+}
+
+func TestRetriesAreRecordedOnce(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1)%3 != 0 { // every third request succeeds
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	gather := func(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+		t.Helper()
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		values := make(map[string]float64)
+		for _, mf := range families {
+			for _, m := range mf.GetMetric() {
+				switch mf.GetName() {
+				case "test_publish_error_rate_total", "test_publish_messages_total":
+					values[mf.GetName()] = m.GetCounter().GetValue()
+				case "test_publish_duration_seconds":
+					for _, l := range m.GetLabel() {
+						if l.GetName() == errorLabel {
+							values[mf.GetName()+"{error="+l.GetValue()+"}"] = float64(m.GetHistogram().GetSampleCount())
+						}
+					}
+				}
+			}
+		}
+		return values
+	}
+
+	for _, tc := range []struct {
+		name       string
+		maxRetries string
+		expected   map[string]float64
+	}{
+		{
+			name:       "published after retrying",
+			maxRetries: "2",
+			expected: map[string]float64{
+				"test_publish_error_rate_total":              0,
+				"test_publish_messages_total":                1,
+				"test_publish_duration_seconds{error=false}": 1,
+			},
+		},
+		{
+			name:       "retries exhausted",
+			maxRetries: "1",
+			expected: map[string]float64{
+				"test_publish_error_rate_total":             1,
+				"test_publish_messages_total":               0,
+				"test_publish_duration_seconds{error=true}": 1,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requests.Store(0)
+			p, err := producer.NewHTTPProducer([]string{
+				"HTTP_ENDPOINT=" + srv.URL,
+				"HTTP_MAX_RETRIES=" + tc.maxRetries,
+				"HTTP_RETRY_BACKOFF_MIN=1ms",
+				"HTTP_RETRY_BACKOFF_MAX=1ms",
+			})
+			require.NoError(t, err)
+
+			reg := prometheus.NewRegistry()
+			f, err := NewFactory(reg, Data{Prefix: "test_", Mode: "http"}, WithHTTPResponses())
+			require.NoError(t, err)
+
+			s := f.New(p)
+			t.Cleanup(func() { _ = s.Close() })
+			_, _ = s.PublishTo(context.Background(), "key", []byte("{}"), nil)
+			require.Equal(t, tc.expected, gather(t, reg))
+		})
+	}
+}
+
+func TestHTTPResponses(t *testing.T) {
+	var requests atomic.Int64
+	statusCodes := []int{http.StatusOK, http.StatusTooManyRequests, http.StatusInternalServerError}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCodes[(requests.Add(1)-1)%int64(len(statusCodes))])
+	}))
+	t.Cleanup(srv.Close)
+
+	p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL})
+	require.NoError(t, err)
+
+	reg := prometheus.NewRegistry()
+	f, err := NewFactory(reg, Data{Prefix: "test_", Mode: "http"}, WithHTTPResponses())
+	require.NoError(t, err)
+
+	s := f.New(p)
+	t.Cleanup(func() { _ = s.Close() })
+	for i := 0; i < 9; i++ {
+		_, _ = s.PublishTo(context.Background(), "key", []byte("{}"), nil)
+	}
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	var (
+		responses = make(map[string]float64)
+		durations = make(map[string]uint64)
+	)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				switch {
+				case mf.GetName() == "test_http_responses_count" && l.GetName() == statusCodeLabel:
+					responses[l.GetValue()] = m.GetCounter().GetValue()
+				case mf.GetName() == "test_http_response_duration_seconds" && l.GetName() == statusClassLabel:
+					durations[l.GetValue()] = m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	require.Equal(t, map[string]float64{"200": 3, "429": 3, "500": 3}, responses)
+	require.Equal(t, map[string]uint64{"2xx": 3, "4xx": 3, "5xx": 3}, durations)
+
+	t.Run("invalid response bodies are 200 OK responses", func(t *testing.T) {
+		p, err := producer.NewHTTPProducer([]string{"HTTP_ENDPOINT=" + srv.URL},
+			producer.WithResponseValidator(func([]byte) error { return errors.New("unexpected body") }),
+		)
+		require.NoError(t, err)
+
+		reg := prometheus.NewRegistry()
+		f, err := NewFactory(reg, Data{Prefix: "test_", Mode: "http"}, WithHTTPResponses())
+		require.NoError(t, err)
+
+		s := f.New(p)
+		t.Cleanup(func() { _ = s.Close() })
+		requests.Store(0) // the server replies 200 OK first
+		_, err = s.PublishTo(context.Background(), "key", []byte("{}"), nil)
+		var validationErr *producer.ResponseValidationError
+		require.ErrorAs(t, err, &validationErr)
+
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		responses := make(map[string]float64)
+		for _, mf := range families {
+			if mf.GetName() != "test_http_responses_count" {
+				continue
+			}
+			for _, m := range mf.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == statusCodeLabel {
+						responses[l.GetValue()] = m.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+		require.Equal(t, map[string]float64{"200": 1}, responses)
+	})
+
+	t.Run("responses are recorded only with WithHTTPResponses", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		f, err := NewFactory(reg, Data{Prefix: "test_", Mode: "stdout"})
+		require.NoError(t, err)
+
+		_, err = f.New(fakePublisher{}).PublishTo(context.Background(), "key", []byte("{}"), nil)
+		require.NoError(t, err)
+
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		for _, mf := range families {
+			require.NotEqual(t, "test_http_responses_count", mf.GetName())
+		}
+	})
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	histogramBuckets := func(t *testing.T, reg *prometheus.Registry) map[string][]float64 {
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		buckets := make(map[string][]float64)
+		for _, mf := range families {
+			for _, m := range mf.GetMetric() {
+				if m.GetHistogram() == nil {
+					continue
+				}
+				var upperBounds []float64
+				for _, b := range m.GetHistogram().GetBucket() {
+					upperBounds = append(upperBounds, b.GetUpperBound())
+				}
+				buckets[mf.GetName()] = upperBounds
+			}
+		}
+		return buckets
+	}
+	publish := func(t *testing.T, f *Factory) {
+		_, err := f.New(fakePublisher{}).PublishTo(context.Background(), "key", []byte("{}"), nil)
+		require.NoError(t, err)
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		f, err := NewFactory(reg, Data{Prefix: "test_", Mode: "stdout"})
+		require.NoError(t, err)
+		publish(t, f)
+
+		buckets := histogramBuckets(t, reg)
+		require.Equal(t, defaultDurationBuckets, buckets["test_publish_duration_seconds"])
+		require.Equal(t, defaultPayloadSizeBuckets, buckets["test_publish_payload_size"])
+		require.Equal(t, defaultPayloadSizeBytesBuckets, buckets["test_payload_size_bytes"])
+	})
+	t.Run("custom", func(t *testing.T) {
+		t.Setenv("PUBLISH_DURATION_BUCKETS", "0.005, 0.01,0.05,0.1,0.5,1,2,5,10,30")
+		t.Setenv("PUBLISH_PAYLOAD_SIZE_BUCKETS", "1000,10000")
+		t.Setenv("PAYLOAD_SIZE_BYTES_BUCKETS", "1024")
+
+		reg := prometheus.NewRegistry()
+		f, err := NewFactory(reg, Data{Prefix: "test_", Mode: "stdout"})
+		require.NoError(t, err)
+		publish(t, f)
+
+		buckets := histogramBuckets(t, reg)
+		require.Equal(t, []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 2, 5, 10, 30}, buckets["test_publish_duration_seconds"])
+		require.Equal(t, []float64{1000, 10000}, buckets["test_publish_payload_size"])
+		require.Equal(t, []float64{1024}, buckets["test_payload_size_bytes"])
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, value := range []string{",", "0.1,abc", "1,0.5", "0.1,0.1"} {
+			t.Setenv("HTTP_RESPONSE_DURATION_BUCKETS", value)
+			_, err := NewFactory(prometheus.NewRegistry(), Data{Prefix: "test_", Mode: "stdout"})
+			require.ErrorContains(t, err, "HTTP_RESPONSE_DURATION_BUCKETS", value)
+		}
+	})
+}